@@ -0,0 +1,217 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+const (
+	// DefaultCommandTimeout is the handler time limit used when CommandDispatcher.Timeout is not set
+	DefaultCommandTimeout = 30 * time.Second
+	// DefaultPollInterval is the interval used by HttpComm.PollCommands when none is given
+	DefaultPollInterval = 30 * time.Second
+
+	// Number of command ids remembered to skip the duplicates
+	seenCommandsSize = 1024
+)
+
+// CommandArgs are the arguments of a command, the values are already parsed as int,
+// float64, bool or string
+type CommandArgs map[string]any
+
+// String returns the argument as a string, numbers and booleans are formatted
+func (a CommandArgs) String(key string) (string, error) {
+	value, ok := a[key]
+	if !ok {
+		return "", fmt.Errorf("missing argument %s", key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Int returns the argument as an int
+func (a CommandArgs) Int(key string) (int, error) {
+	value, ok := a[key]
+	if !ok {
+		return 0, fmt.Errorf("missing argument %s", key)
+	}
+	if i, ok := value.(int); ok {
+		return i, nil
+	}
+	return 0, fmt.Errorf("argument %s should be an integer, got %v", key, value)
+}
+
+// Float returns the argument as a float64, integers are converted
+func (a CommandArgs) Float(key string) (float64, error) {
+	value, ok := a[key]
+	if !ok {
+		return 0, fmt.Errorf("missing argument %s", key)
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("argument %s should be a number, got %v", key, value)
+}
+
+// Bool returns the argument as a bool
+func (a CommandArgs) Bool(key string) (bool, error) {
+	value, ok := a[key]
+	if !ok {
+		return false, fmt.Errorf("missing argument %s", key)
+	}
+	if b, ok := value.(bool); ok {
+		return b, nil
+	}
+	return false, fmt.Errorf("argument %s should be a boolean, got %v", key, value)
+}
+
+// CommandHandler runs a command, the returned message is sent back to the server in the
+// <Pc> packet. On error, the error message is sent instead.
+//
+// The handler should return once ctx is done, a handler that ignores ctx keeps running
+// in the background after the timeout and its result is discarded
+type CommandHandler func(ctx context.Context, args CommandArgs) (string, error)
+
+// CommandDispatcher runs the commands of the <Ac> packets with the handler registered for
+// their name and answers each one with a <Pc> packet.
+//
+// Set it as the Commands of a TcpComm or an HttpComm. Commands already answered are skipped,
+// so a command sent again by the server is not run twice. A command whose <Pc> could not
+// be sent is run again when the server sends it again
+type CommandDispatcher struct {
+	// Time limit of a handler, the context of the handler is cancelled once it expires
+	// and the <Pc> reports the timeout without waiting for the handler.
+	// By default is DefaultCommandTimeout
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]CommandHandler
+	seen     map[int]bool
+	// Command ids being run, a duplicate received meanwhile is skipped
+	running map[int]bool
+	// Seen command ids in arrival order, the oldest ones are forgotten first
+	order []int
+}
+
+// Handle registers the handler of the commands with the given name, replacing the previous one
+func (d *CommandDispatcher) Handle(name string, handler CommandHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.handlers == nil {
+		d.handlers = make(map[string]CommandHandler)
+	}
+	d.handlers[name] = handler
+}
+
+// Runs the commands of the packet in order and sends their responses with reply
+func (d *CommandDispatcher) run(ctx context.Context, packet *server.AcPacket, reply func(*client.PcPacket) error) {
+	for _, command := range packet.Commands {
+		if !d.start(command.CommandId) {
+			log.Printf("Skipping duplicated command %d\n", command.CommandId)
+			continue
+		}
+
+		name := ""
+		if command.CommandName != nil {
+			name = *command.CommandName
+		}
+
+		message := d.execute(ctx, name, CommandArgs(command.Args))
+		response := &client.PcPacket{
+			Timestamp: time.Now(),
+			CommandId: command.CommandId,
+			Message:   &message,
+		}
+		err := reply(response)
+		if err != nil {
+			log.Printf("Error sending the response of command %d: %s\n", command.CommandId, err)
+		}
+		d.finish(command.CommandId, err == nil)
+	}
+}
+
+// Runs the handler of a command, returns the message of the response
+func (d *CommandDispatcher) execute(ctx context.Context, name string, args CommandArgs) string {
+	d.mu.Lock()
+	handler := d.handlers[name]
+	timeout := d.Timeout
+	d.mu.Unlock()
+
+	if handler == nil {
+		return fmt.Sprintf("unsupported command %s", name)
+	}
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		message string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		message, err := handler(ctx, args)
+		done <- result{message, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return r.err.Error()
+		}
+		return r.message
+	case <-ctx.Done():
+		return fmt.Sprintf("command %s timed out", name)
+	}
+}
+
+// Marks a command id as running, returns false if it was already answered or is running
+func (d *CommandDispatcher) start(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.seen[id] || d.running[id] {
+		return false
+	}
+	if d.running == nil {
+		d.running = make(map[int]bool)
+	}
+	d.running[id] = true
+	return true
+}
+
+// Marks a command id as done, it is remembered as seen only if its response was sent
+func (d *CommandDispatcher) finish(id int, sent bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.running, id)
+	if !sent {
+		return
+	}
+	if d.seen == nil {
+		d.seen = make(map[int]bool)
+	}
+
+	d.seen[id] = true
+	d.order = append(d.order, id)
+	if len(d.order) > seenCommandsSize {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+}
@@ -0,0 +1,332 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+func command(id int, name string, args map[string]any) definitions.CommandDefinition {
+	return definitions.CommandDefinition{CommandId: id, CommandName: &name, Args: args}
+}
+
+func TestCommandArgs(t *testing.T) {
+	args := CommandArgs{"name": "relay", "count": 3, "ratio": 0.5, "on": true}
+
+	if s, err := args.String("name"); err != nil || s != "relay" {
+		t.Errorf("String = %q, %v", s, err)
+	}
+	if s, err := args.String("count"); err != nil || s != "3" {
+		t.Errorf("String of an int = %q, %v", s, err)
+	}
+	if i, err := args.Int("count"); err != nil || i != 3 {
+		t.Errorf("Int = %d, %v", i, err)
+	}
+	if f, err := args.Float("count"); err != nil || f != 3 {
+		t.Errorf("Float of an int = %f, %v", f, err)
+	}
+	if f, err := args.Float("ratio"); err != nil || f != 0.5 {
+		t.Errorf("Float = %f, %v", f, err)
+	}
+	if b, err := args.Bool("on"); err != nil || !b {
+		t.Errorf("Bool = %t, %v", b, err)
+	}
+
+	if _, err := args.Int("name"); err == nil {
+		t.Error("Int: expected error for a string")
+	}
+	if _, err := args.Bool("missing"); err == nil {
+		t.Error("Bool: expected error for a missing argument")
+	}
+}
+
+func TestCommandDispatcher_Run(t *testing.T) {
+	dispatcher := &CommandDispatcher{Timeout: 50 * time.Millisecond}
+	dispatcher.Handle("relay", func(ctx context.Context, args CommandArgs) (string, error) {
+		state, err := args.Bool("on")
+		return fmt.Sprintf("relay %t", state), err
+	})
+	dispatcher.Handle("fail", func(ctx context.Context, args CommandArgs) (string, error) {
+		return "", errors.New("device busy")
+	})
+	dispatcher.Handle("slow", func(ctx context.Context, args CommandArgs) (string, error) {
+		<-ctx.Done()
+		return "done", nil
+	})
+
+	packet := &server.AcPacket{Commands: []definitions.CommandDefinition{
+		command(1, "relay", map[string]any{"on": true}),
+		command(2, "fail", nil),
+		command(3, "slow", nil),
+		command(4, "unknown", nil),
+		command(1, "relay", map[string]any{"on": true}),
+	}}
+
+	var responses []*client.PcPacket
+	dispatcher.run(context.Background(), packet, func(response *client.PcPacket) error {
+		responses = append(responses, response)
+		return nil
+	})
+
+	want := map[int]string{
+		1: "relay true",
+		2: "device busy",
+		3: "command slow timed out",
+		4: "unsupported command unknown",
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("expected %d responses, the duplicate skipped, got %d", len(want), len(responses))
+	}
+	for _, response := range responses {
+		if *response.Message != want[response.CommandId] {
+			t.Errorf("command %d: message = %q, want %q", response.CommandId, *response.Message, want[response.CommandId])
+		}
+	}
+
+	// A command sent again in a later packet is skipped too
+	responses = nil
+	dispatcher.run(context.Background(), &server.AcPacket{Commands: packet.Commands[:1]}, func(response *client.PcPacket) error {
+		responses = append(responses, response)
+		return nil
+	})
+	if len(responses) != 0 {
+		t.Errorf("expected the duplicate to be skipped, got %d responses", len(responses))
+	}
+}
+
+func TestCommandDispatcher_ForgetsOldIds(t *testing.T) {
+	var dispatcher CommandDispatcher
+	for id := range seenCommandsSize + 1 {
+		dispatcher.start(id)
+		dispatcher.finish(id, true)
+	}
+	if !dispatcher.start(0) {
+		t.Error("expected the oldest id to be forgotten")
+	}
+	if dispatcher.start(seenCommandsSize) {
+		t.Error("expected a recent id to be remembered")
+	}
+}
+
+func TestCommandDispatcher_RunsAgainWhenReplyFails(t *testing.T) {
+	runs := 0
+	dispatcher := &CommandDispatcher{}
+	dispatcher.Handle("relay", func(ctx context.Context, args CommandArgs) (string, error) {
+		runs++
+		return "ok", nil
+	})
+
+	packet := &server.AcPacket{Commands: []definitions.CommandDefinition{command(1, "relay", nil)}}
+	dispatcher.run(context.Background(), packet, func(*client.PcPacket) error {
+		return errors.New("connection lost")
+	})
+
+	// The server sends the command again since it got no <Pc>
+	sent := 0
+	for range 2 {
+		dispatcher.run(context.Background(), packet, func(*client.PcPacket) error {
+			sent++
+			return nil
+		})
+	}
+	if runs != 2 || sent != 1 {
+		t.Errorf("expected 2 runs and 1 response sent, got %d runs and %d sent", runs, sent)
+	}
+}
+
+func TestCommandDispatcher_SkipsRunningDuplicate(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	dispatcher := &CommandDispatcher{}
+	dispatcher.Handle("slow", func(ctx context.Context, args CommandArgs) (string, error) {
+		close(entered)
+		<-release
+		return "done", nil
+	})
+
+	packet := &server.AcPacket{Commands: []definitions.CommandDefinition{command(1, "slow", nil)}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.run(context.Background(), packet, func(*client.PcPacket) error { return nil })
+	}()
+	<-entered
+
+	sent := 0
+	dispatcher.run(context.Background(), packet, func(*client.PcPacket) error {
+		sent++
+		return nil
+	})
+	close(release)
+	<-done
+
+	if sent != 0 {
+		t.Errorf("expected the running duplicate to be skipped, got %d responses", sent)
+	}
+}
+
+func TestTcpComm_Commands(t *testing.T) {
+	responses := make(chan string, 1)
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+		_, _ = reader.ReadString('\n')
+		_, _ = fmt.Fprint(conn, *(&server.AsPacket{}).ToPacket()+"\r\n")
+
+		ac := &server.AcPacket{Commands: []definitions.CommandDefinition{command(7, "ping", nil)}}
+		_, _ = fmt.Fprint(conn, *ac.ToPacket()+"\r\n")
+
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			responses <- strings.TrimSpace(line)
+		}
+		<-time.After(time.Second)
+	})
+
+	c := newTestComm(port)
+	c.Commands = &CommandDispatcher{}
+	c.Commands.Handle("ping", func(ctx context.Context, args CommandArgs) (string, error) {
+		return "pong", nil
+	})
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	select {
+	case raw := <-responses:
+		var response client.PcPacket
+		if err := response.FromPacket(&raw); err != nil {
+			t.Fatalf("invalid response %q: %v", raw, err)
+		}
+		if response.CommandId != 7 || *response.Message != "pong" {
+			t.Errorf("response = %d %q", response.CommandId, *response.Message)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no <Pc> response")
+	}
+}
+
+func TestHttpComm_PollCommands(t *testing.T) {
+	responses := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/commands":
+			ac := &server.AcPacket{Commands: []definitions.CommandDefinition{command(9, "ping", nil)}}
+			_, _ = fmt.Fprint(w, *ac.ToPacket())
+		case "/v2/message":
+			body, _ := io.ReadAll(r.Body)
+			responses <- string(body)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	var c HttpComm
+	c.New(HTTP, srv.Listener.Addr().String(), "ident", "pass")
+	if err := c.PollCommands(context.Background(), time.Millisecond); err == nil {
+		t.Error("expected error without a dispatcher")
+	}
+
+	c.Commands = &CommandDispatcher{}
+	c.Commands.Handle("ping", func(ctx context.Context, args CommandArgs) (string, error) {
+		return "pong", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.PollCommands(ctx, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+
+	// The command is polled again and again but only answered once
+	if got := len(responses); got != 1 {
+		t.Fatalf("expected 1 response, got %d", got)
+	}
+	raw := <-responses
+	var response client.PcPacket
+	if err := response.FromPacket(&raw); err != nil {
+		t.Fatalf("invalid response %q: %v", raw, err)
+	}
+	if response.CommandId != 9 || *response.Message != "pong" {
+		t.Errorf("response = %d %q", response.CommandId, *response.Message)
+	}
+}
+
+func TestTcpComm_CommandReplyWaitsForOutboxAck(t *testing.T) {
+	replies := make(chan string, 1)
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+		_, _ = reader.ReadString('\n')
+		_, _ = fmt.Fprint(conn, *(&server.AsPacket{}).ToPacket()+"\r\n")
+
+		// The <Pd> of the outbox is in flight when the command arrives
+		_, _ = reader.ReadString('\n')
+		ac := &server.AcPacket{Commands: []definitions.CommandDefinition{command(7, "ping", nil)}}
+		_, _ = fmt.Fprint(conn, *ac.ToPacket()+"\r\n")
+
+		// A <Pc> written meanwhile is rejected, its <Ar> would be taken as the answer of the <Pd>
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if line, err := reader.ReadString('\n'); err == nil {
+			replies <- strings.TrimSpace(line)
+			_, _ = fmt.Fprint(conn, *(&server.ArPacket{Reason: "unexpected"}).ToPacket()+"\r\n")
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+		_, _ = fmt.Fprint(conn, *(&server.AoPacket{Timestamp: time.Now()}).ToPacket()+"\r\n")
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			replies <- strings.TrimSpace(line)
+			_, _ = fmt.Fprint(conn, *(&server.AoPacket{Timestamp: time.Now()}).ToPacket()+"\r\n")
+		}
+	})
+
+	c := newTestComm(port)
+	c.Outbox = newTestOutbox(t, OutboxConfig{})
+	c.Commands = &CommandDispatcher{}
+	c.Commands.Handle("ping", func(ctx context.Context, args CommandArgs) (string, error) {
+		return "pong", nil
+	})
+	if err := c.Send(pdAt(time.Minute)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	select {
+	case raw := <-replies:
+		if !strings.HasPrefix(raw, "<Pc>") {
+			t.Errorf("expected the <Pc>, got %q", raw)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no <Pc> response")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for c.Outbox.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := c.Outbox.Len(); got != 0 {
+		t.Errorf("expected the <Pd> to be acknowledged, %d packets pending", got)
+	}
+}
@@ -0,0 +1,121 @@
+package clients
+
+import (
+	"log"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/ai"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/trips"
+)
+
+// tcpHandlers holds the typed handlers of TcpComm, a nil handler drops its packets
+type tcpHandlers struct {
+	commands     func(*server.AcPacket)
+	bleWhitelist func(*server.AbPacket)
+	ack          func(*server.AoPacket)
+	errorPacket  func(*server.ArPacket)
+	tripStart    func(*trips.TsPacket)
+	tripEnd      func(*trips.TePacket)
+	aiMessage    func(*ai.ImPacket)
+	stateChange  func(ConnState, error)
+}
+
+// OnCommands sets the handler of the <Ac> commands sent by the server
+func (p *TcpComm) OnCommands(handler func(*server.AcPacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.commands = handler
+}
+
+// OnBleWhitelist sets the handler of the <Ab> BLE whitelist sent by the server
+func (p *TcpComm) OnBleWhitelist(handler func(*server.AbPacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.bleWhitelist = handler
+}
+
+// OnAck sets the handler of the <Ao> acknowledgements sent by the server
+func (p *TcpComm) OnAck(handler func(*server.AoPacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.ack = handler
+}
+
+// OnError sets the handler of the <Ar> errors sent by the server once authenticated,
+// a rejected <Pa> handshake is reported by Connect and OnStateChange instead
+func (p *TcpComm) OnError(handler func(*server.ArPacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.errorPacket = handler
+}
+
+// OnTripStart sets the handler of the <Ts> trip starts sent by the server
+func (p *TcpComm) OnTripStart(handler func(*trips.TsPacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.tripStart = handler
+}
+
+// OnTripEnd sets the handler of the <Te> trip ends sent by the server
+func (p *TcpComm) OnTripEnd(handler func(*trips.TePacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.tripEnd = handler
+}
+
+// OnAiMessage sets the handler of the <Im> AI messages sent by the server
+func (p *TcpComm) OnAiMessage(handler func(*ai.ImPacket)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.aiMessage = handler
+}
+
+// OnStateChange sets the handler of the connection state changes,
+// err is the cause of a StateDisconnected or StateClosed state and nil otherwise
+func (p *TcpComm) OnStateChange(handler func(state ConnState, err error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers.stateChange = handler
+}
+
+// Calls the handler of the packet, if any
+func (p *TcpComm) dispatch(packet server.ServerPackets) {
+	p.mu.Lock()
+	h := p.handlers
+	p.mu.Unlock()
+
+	var handled bool
+	switch output := packet.(type) {
+	case *server.AcPacket:
+		// The Commands dispatcher, if any, already got the packet from listen
+		handled = handle(h.commands, output) || p.Commands != nil
+	case *server.AbPacket:
+		handled = handle(h.bleWhitelist, output)
+	case *server.AoPacket:
+		handled = handle(h.ack, output)
+	case *server.ArPacket:
+		handled = handle(h.errorPacket, output)
+	case *trips.TsPacket:
+		handled = handle(h.tripStart, output)
+	case *trips.TePacket:
+		handled = handle(h.tripEnd, output)
+	case *ai.ImPacket:
+		handled = handle(h.aiMessage, output)
+	case *server.AuPacket: //nolint:staticcheck
+		log.Println("Deprecated AuPacket...")
+		return
+	}
+
+	if !handled {
+		log.Printf("No handler set for <%s>...\n", packet.Tag())
+	}
+}
+
+// Calls the handler if it is set, returns false otherwise
+func handle[T any](handler func(T), packet T) bool {
+	if handler == nil {
+		return false
+	}
+	handler(packet)
+	return true
+}
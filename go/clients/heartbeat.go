@@ -0,0 +1,118 @@
+package clients
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+)
+
+// DefaultMaxMissedHeartbeats is the limit used when TcpComm.MaxMissedHeartbeats is not set
+const DefaultMaxMissedHeartbeats = 3
+
+// ErrHeartbeatTimeout is the cause of a connection closed because the server did not answer
+// TcpComm.MaxMissedHeartbeats heartbeats in a row
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// link is a single authenticated connection of TcpComm
+type link struct {
+	conn net.Conn
+	// Closed once the connection is closed
+	closed chan struct{}
+	// Held while a packet waits for its <Ao> or <Ar>, so every answer is matched with the
+	// packet that caused it
+	exchange chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newLink(conn net.Conn) *link {
+	return &link{
+		conn:     conn,
+		closed:   make(chan struct{}),
+		exchange: make(chan struct{}, 1),
+	}
+}
+
+// Takes the exchange, returns false if the connection was closed meanwhile
+func (l *link) acquire() bool {
+	select {
+	case l.exchange <- struct{}{}:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+// Takes the exchange if it is free
+func (l *link) tryAcquire() bool {
+	select {
+	case l.exchange <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *link) release() {
+	<-l.exchange
+}
+
+// Closes the connection with the given cause
+func (l *link) fail(err error) {
+	l.mu.Lock()
+	if l.err == nil {
+		l.err = err
+	}
+	l.mu.Unlock()
+	_ = l.conn.Close()
+}
+
+// Returns the cause given to fail, nil if the connection was closed otherwise
+func (l *link) cause() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Sends a <Pr> heartbeat every interval until the connection is closed, the connection is
+// closed with ErrHeartbeatTimeout once MaxMissedHeartbeats heartbeats in a row are not
+// answered with <Ao>. A tick is skipped while a packet of the outbox waits for its answer
+func (p *TcpComm) heartbeat(l *link) {
+	ticker := time.NewTicker(p.HeartbeatInterval)
+	defer ticker.Stop()
+
+	frame := *(&client.PrPacket{}).ToPacket()
+	missed := 0
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if !l.tryAcquire() {
+			continue
+		}
+		acked, err := p.request(l, frame, p.HeartbeatInterval)
+		l.release()
+
+		switch {
+		case acked:
+			missed = 0
+		case err == nil || errors.Is(err, errAckTimeout):
+			missed++
+			log.Printf("Heartbeat %d of %d missed\n", missed, p.MaxMissedHeartbeats)
+			if missed >= p.MaxMissedHeartbeats {
+				l.fail(ErrHeartbeatTimeout)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+// heartbeatServer accepts the handshake and answers the <Pr> heartbeats of every
+// connection with <Ao> when answer is true, every heartbeat received is sent to heartbeats
+func heartbeatServer(t *testing.T, answer bool, heartbeats chan<- int) int {
+	t.Helper()
+	return fakeServer(t, func(conn net.Conn, n int) {
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+		_, _ = reader.ReadString('\n')
+		_, _ = fmt.Fprint(conn, *(&server.AsPacket{}).ToPacket()+"\r\n")
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(line, "<Pr>") {
+				continue
+			}
+			select {
+			case heartbeats <- n:
+			default:
+			}
+			if answer {
+				_, _ = fmt.Fprint(conn, *(&server.AoPacket{Timestamp: time.Now()}).ToPacket()+"\r\n")
+			}
+		}
+	})
+}
+
+func TestTcpComm_Heartbeat_Answered(t *testing.T) {
+	heartbeats := make(chan int, 16)
+	port := heartbeatServer(t, true, heartbeats)
+
+	c := newTestComm(port)
+	c.HeartbeatInterval = 20 * time.Millisecond
+	c.MaxMissedHeartbeats = 2
+	recorder := &stateRecorder{}
+	c.OnStateChange(recorder.record)
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-heartbeats:
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat was not sent")
+		}
+	}
+
+	if c.State() != StateConnected {
+		t.Errorf("expected StateConnected, got %s", c.State())
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for _, state := range recorder.states {
+		if state == StateDisconnected {
+			t.Error("answered heartbeats should keep the connection")
+		}
+	}
+}
+
+func TestTcpComm_Heartbeat_MissedClosesConnection(t *testing.T) {
+	heartbeats := make(chan int, 16)
+	port := heartbeatServer(t, false, heartbeats)
+
+	c := newTestComm(port)
+	c.HeartbeatInterval = 20 * time.Millisecond
+	c.MaxMissedHeartbeats = 2
+	recorder := &stateRecorder{}
+	c.OnStateChange(recorder.record)
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	recorder.waitFor(t, StateDisconnected, 1)
+	recorder.waitFor(t, StateConnected, 2)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for i, state := range recorder.states {
+		if state == StateDisconnected {
+			if !errors.Is(recorder.errs[i], ErrHeartbeatTimeout) {
+				t.Errorf("expected ErrHeartbeatTimeout, got %v", recorder.errs[i])
+			}
+			break
+		}
+	}
+}
@@ -2,11 +2,23 @@ package clients
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"maps"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
 type HttpScheme string
@@ -16,12 +28,83 @@ const (
 	HTTPS HttpScheme = "https"
 )
 
+const (
+	// DefaultHttpTimeout is the request time limit of the HTTP client created by New
+	DefaultHttpTimeout = 30 * time.Second
+	// DefaultImageContentType is the content type used by SendImage when none is given
+	DefaultImageContentType = "image/jpeg"
+)
+
+// Client of the HttpComm without a Client, http.DefaultClient has no timeout
+var defaultHttpClient = &http.Client{Timeout: DefaultHttpTimeout}
+
 type HttpComm struct {
-	Scheme      HttpScheme
-	Host        string
-	Ident       string
-	Passwd      string
+	Scheme HttpScheme
+	Host   string
+	Ident  string
+	Passwd string
+
+	// HTTP client used for every request, it defines the transport, proxy, TLS configuration
+	// and timeout. By default, and when nil, is a client with DefaultHttpTimeout
+	Client *http.Client
+
+	// Retries of the requests that fail with a network error or a 5xx status code,
+	// by default requests are not retried
+	Retry RetryPolicy
+
+	// Optional outbox that keeps the <Pd> and <Pb> packets until the server answers them with
+	// a 2xx response, like <Ao> or no content. The pending packets are sent before every new
+	// one, the packets rejected with <Ar> or a 4xx status code, other than 401, are dropped
+	Outbox *Outbox
+
+	// Optional dispatcher that runs the <Ac> commands received by PollCommands and sends
+	// their <Pc> responses
+	Commands *CommandDispatcher
+
 	initialized bool
+
+	// Serializes the deliveries of the outbox, so a packet is not posted twice
+	flushMu sync.Mutex
+}
+
+// HttpOption configures an HttpComm created by NewHttpComm
+type HttpOption func(*HttpComm)
+
+// WithHttpClient uses the given HTTP client for every request,
+// a nil client keeps a client with DefaultHttpTimeout
+func WithHttpClient(client *http.Client) HttpOption {
+	return func(p *HttpComm) {
+		if client == nil {
+			client = &http.Client{Timeout: DefaultHttpTimeout}
+		}
+		p.Client = client
+	}
+}
+
+// WithRetryPolicy retries the failed requests with the given policy
+func WithRetryPolicy(policy RetryPolicy) HttpOption {
+	return func(p *HttpComm) { p.Retry = policy }
+}
+
+// WithOutbox keeps the <Pd> and <Pb> packets in the given outbox until acknowledged
+func WithOutbox(outbox *Outbox) HttpOption {
+	return func(p *HttpComm) { p.Outbox = outbox }
+}
+
+// WithCommandDispatcher runs the polled commands with the given dispatcher
+func WithCommandDispatcher(commands *CommandDispatcher) HttpOption {
+	return func(p *HttpComm) { p.Commands = commands }
+}
+
+// NewHttpComm creates a new instance of LayrzProtocol using HTTP communication,
+// configured by the given options
+func NewHttpComm(scheme HttpScheme, host, ident, password string, opts ...HttpOption) *HttpComm {
+	var p HttpComm
+	p.New(scheme, host, ident, password)
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return &p
 }
 
 // New creates a new intance of LayrzProtocol using HTTP communication
@@ -30,85 +113,334 @@ func (p *HttpComm) New(scheme HttpScheme, host, ident, password string) {
 	p.Host = host
 	p.Ident = ident
 	p.Passwd = password
+	p.Client = &http.Client{Timeout: DefaultHttpTimeout}
 
 	p.initialized = true
 }
 
-// Send sends a packet to the server
+// Send sends a packet to the server, see SendContext
+func (p *HttpComm) Send(packet any) (server.ServerPackets, error) {
+	return p.SendContext(context.Background(), packet)
+}
+
+// SendContext sends a packet to the server
+//
+// Returns an error if the packet is invalid or the request failed, see StatusError and AuthError.
+// And, may return the packet answered by the server, nil if the server answered without content.
 //
-// Returns an error if the packet is invalid
-// And, may return an Packet stored on an any
-func (p *HttpComm) Send(packet any) (*any, error) {
+// With an Outbox, <Pd> and <Pb> packets are stored and sent after the pending ones, if the
+// delivery fails the error is returned and the packet is kept for the next Send or Flush.
+// Concurrent sends of these packets wait for each other
+func (p *HttpComm) SendContext(ctx context.Context, packet any) (server.ServerPackets, error) {
 	if !p.initialized {
 		return nil, errors.New("HttpComm not initialized")
 	}
 
+	if p.Outbox != nil && isOutboxPacket(packet) {
+		p.flushMu.Lock()
+		defer p.flushMu.Unlock()
+
+		entry, err := p.Outbox.push(packet)
+		if err != nil {
+			return nil, err
+		}
+		return p.flush(ctx, entry.seq)
+	}
+
 	data, err := EncodeClientPacket(packet)
 	if err != nil {
 		return nil, err
 	}
+	return p.do(ctx, http.MethodPost, "/v2/message", []byte(*data))
+}
 
-	headers := http.Header{}
-	headers.Add("Authorization", fmt.Sprintf("LayrzAuth %s;%s", p.Ident, p.Passwd))
+// Flush sends the pending packets of the Outbox, see FlushContext
+func (p *HttpComm) Flush() error {
+	return p.FlushContext(context.Background())
+}
 
-	request := http.Request{
-		Method: "POST",
-		URL: &url.URL{
-			Scheme: string(p.Scheme),
-			Host:   p.Host,
-			Path:   "/v2/message",
-		},
-		Header: headers,
-		Body:   io.NopCloser(bytes.NewReader([]byte(*data))),
+// FlushContext sends the pending packets of the Outbox in timestamp order, it stops on the
+// first delivery error. Packets answered with <Ar> are dropped, since the server rejected them
+func (p *HttpComm) FlushContext(ctx context.Context) error {
+	if !p.initialized {
+		return errors.New("HttpComm not initialized")
+	}
+	if p.Outbox == nil {
+		return nil
 	}
 
-	client := http.Client{}
-	response, err := client.Do(&request)
-	if err != nil {
-		return nil, err
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	_, err := p.flush(ctx, 0)
+	return err
+}
+
+// Sends the pending packets of the outbox, returns the answer to the packet with the given seq.
+// Must be called with flushMu held
+func (p *HttpComm) flush(ctx context.Context, seq uint64) (server.ServerPackets, error) {
+	var result server.ServerPackets
+	var resultErr error
+	for _, entry := range p.Outbox.pending() {
+		response, err := p.do(ctx, http.MethodPost, "/v2/message", []byte(entry.frame))
+		if rejected(err) {
+			// Sending it again would be rejected as well, it would block the next packets
+			log.Printf("Dropping packet rejected by the server: %s Error: %s\n", entry.frame, err)
+			if entry.seq == seq {
+				resultErr = err
+			}
+			if err := p.Outbox.ack(entry.seq); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.seq == seq {
+			result = response
+		}
+
+		// Every answer of a 2xx response means the server got the packet, with <Ar> it was
+		// rejected and sending it again would be rejected as well
+		if ar, ok := response.(*server.ArPacket); ok {
+			log.Printf("Dropping packet rejected by the server: %s Reason: %s\n", entry.frame, ar.Reason)
+		}
+		if err := p.Outbox.ack(entry.seq); err != nil {
+			return nil, err
+		}
 	}
+	return result, resultErr
+}
 
-	defer func() { _ = response.Body.Close() }()
-	body, err := io.ReadAll(io.Reader(response.Body))
-	if err != nil {
-		return nil, err
+// Returns true if the server refused the packet itself with a 4xx status code, like 400 for an
+// invalid packet or 413 for a too large one. 401, 408 and 429 are not about the packet,
+// it is sent again later
+func rejected(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode < 400 || statusErr.StatusCode >= 500 {
+		return false
+	}
+	switch statusErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
 	}
+	return true
+}
 
-	resp := string(body)
-	return DecodeServerOutput(resp)
+// Get new commands from the server, see GetCommandsContext
+func (p *HttpComm) GetCommands() (server.ServerPackets, error) {
+	return p.GetCommandsContext(context.Background())
 }
 
-// Get new commands from the server
-func (p *HttpComm) GetCommands() (*any, error) {
+// GetCommandsContext gets the new commands from the server, returns nil if there are
+// no pending commands
+func (p *HttpComm) GetCommandsContext(ctx context.Context) (server.ServerPackets, error) {
 	if !p.initialized {
 		return nil, errors.New("HttpComm not initialized")
 	}
+	return p.do(ctx, http.MethodGet, "/v2/commands", nil)
+}
 
-	headers := http.Header{}
-	headers.Add("Authorization", fmt.Sprintf("LayrzAuth %s;%s", p.Ident, p.Passwd))
+// GetBle gets the BLE whitelist from the server, see GetBleContext
+func (p *HttpComm) GetBle() (server.ServerPackets, error) {
+	return p.GetBleContext(context.Background())
+}
 
-	request := http.Request{
-		Method: "GET",
-		URL: &url.URL{
-			Scheme: string(p.Scheme),
-			Host:   p.Host,
-			Path:   "/v2/commands",
-		},
-		Header: headers,
+// GetBleContext gets the <Ab> BLE whitelist of the device from the server,
+// returns nil if the server answered without content
+func (p *HttpComm) GetBleContext(ctx context.Context) (server.ServerPackets, error) {
+	if !p.initialized {
+		return nil, errors.New("HttpComm not initialized")
 	}
+	return p.do(ctx, http.MethodGet, "/v2/ble", nil)
+}
+
+// SendSos sends an SOS message to the server, see SendSosContext
+func (p *HttpComm) SendSos(message *client.PdPacket) (server.ServerPackets, error) {
+	return p.SendSosContext(context.Background(), message)
+}
 
-	client := http.Client{}
-	response, err := client.Do(&request)
+// SendSosContext sends a copy of the message with the extra data alarm.event set to true,
+// if message is nil an empty <Pd> from ComposeEmptyPd is sent
+func (p *HttpComm) SendSosContext(ctx context.Context, message *client.PdPacket) (server.ServerPackets, error) {
+	if message == nil {
+		message = p.ComposeEmptyPd()
+	}
+
+	extra := maps.Clone(message.ExtraData)
+	if extra == nil {
+		extra = make(map[string]any)
+	}
+	extra["alarm.event"] = true
+
+	return p.SendContext(ctx, &client.PdPacket{
+		Timestamp: message.Timestamp,
+		Position:  message.Position,
+		ExtraData: extra,
+	})
+}
+
+// SendImage sends an image to the server, see SendImageContext
+func (p *HttpComm) SendImage(content []byte, filename, contentType string) (server.ServerPackets, error) {
+	return p.SendImageContext(context.Background(), content, filename, contentType)
+}
+
+// SendImageContext posts an image to /v2/image/{filename} as a base64 data URI.
+// Spaces, slashes and dots of the filename are replaced by underscores, and the content
+// type is DefaultImageContentType when empty
+func (p *HttpComm) SendImageContext(ctx context.Context, content []byte, filename, contentType string) (server.ServerPackets, error) {
+	if !p.initialized {
+		return nil, errors.New("HttpComm not initialized")
+	}
+	if contentType == "" {
+		contentType = DefaultImageContentType
+	}
+
+	body := fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(content))
+	return p.do(ctx, http.MethodPost, "/v2/image/"+sanitizeFilename(filename), []byte(body))
+}
+
+// ComposeEmptyPd returns a <Pd> packet with the current time, an empty position
+// and no extra data
+func (p *HttpComm) ComposeEmptyPd() *client.PdPacket {
+	return &client.PdPacket{
+		Timestamp: time.Now(),
+		Position:  &definitions.Position{},
+		ExtraData: make(map[string]any),
+	}
+}
+
+// Replaces the spaces, slashes and dots of a filename by underscores
+func sanitizeFilename(filename string) string {
+	return strings.NewReplacer(" ", "_", "/", "_", ".", "_").Replace(filename)
+}
+
+// PollCommands calls GetCommands every interval until ctx is done, the received <Ac>
+// commands are run by the Commands dispatcher, which is required. A failed poll is logged
+// and retried on the next interval. By default the interval is DefaultPollInterval
+func (p *HttpComm) PollCommands(ctx context.Context, interval time.Duration) error {
+	if !p.initialized {
+		return errors.New("HttpComm not initialized")
+	}
+	if p.Commands == nil {
+		return errors.New("HttpComm has no command dispatcher")
+	}
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		response, err := p.GetCommandsContext(ctx)
+		switch commands := response.(type) {
+		case nil:
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error polling commands: %s\n", err)
+			}
+		case *server.AcPacket:
+			p.Commands.run(ctx, commands, func(response *client.PcPacket) error {
+				_, err := p.SendContext(ctx, response)
+				return err
+			})
+		default:
+			log.Printf("Unexpected answer to the commands poll %T\n", commands)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Runs a request with the retries of the policy, the same body is sent on every attempt
+func (p *HttpComm) do(ctx context.Context, method, path string, body []byte) (server.ServerPackets, error) {
+	for attempt := 1; ; attempt++ {
+		output, retry, err := p.doOnce(ctx, method, path, body)
+		if !retry || attempt >= p.Retry.MaxAttempts || ctx.Err() != nil {
+			return output, err
+		}
+
+		delay := p.Retry.delay(attempt - 1)
+		log.Printf("Request %s %s failed, retrying in %s: %s\n", method, path, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Runs a single request, returns true if the failure may be retried
+func (p *HttpComm) doOnce(ctx context.Context, method, path string, body []byte) (server.ServerPackets, bool, error) {
+	target := url.URL{
+		Scheme: string(p.Scheme),
+		Host:   p.Host,
+		Path:   path,
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	request.Header.Add("Authorization", fmt.Sprintf("LayrzAuth %s;%s", p.Ident, p.Passwd))
+	if body != nil {
+		request.Header.Set("Content-Type", "text/plain")
 	}
 
+	httpClient := p.Client
+	if httpClient == nil {
+		httpClient = defaultHttpClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, true, err
+	}
 	defer func() { _ = response.Body.Close() }()
-	body, err := io.ReadAll(io.Reader(response.Body))
+
+	switch {
+	case response.StatusCode == http.StatusNoContent:
+		return nil, false, nil
+
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		output, err := readServerOutput(response.Body)
+		return output, false, err
+
+	case response.StatusCode == http.StatusUnauthorized:
+		return nil, false, &AuthError{Reason: readErrorBody(response.Body)}
+	}
+
+	err = &StatusError{StatusCode: response.StatusCode, Body: readErrorBody(response.Body)}
+	return nil, response.StatusCode >= 500, err
+}
+
+// Reads the first frame of a response body and decodes it
+func readServerOutput(body io.Reader) (server.ServerPackets, error) {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		// A response without content is accepted as a 204
+		return nil, nil
+	}
+
+	frame, err := helpers.NewFrameReader(bytes.NewReader(content), helpers.FrameScanner{}).Next()
 	if err != nil {
 		return nil, err
 	}
 
-	resp := string(body)
-	return DecodeServerOutput(resp)
+	return DecodeServerOutput(string(frame))
 }
@@ -1,14 +1,22 @@
 package clients_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/clients"
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/ai"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/trips"
 )
 
 func TestHttpComm_NotInitialized(t *testing.T) {
@@ -49,11 +57,39 @@ func TestHttpComm_Send(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Send failed: %v", err)
 	}
-	if result == nil || *result == nil {
+	if result == nil {
 		t.Fatal("result is nil")
 	}
 }
 
+func TestHttpComm_Send_TripsAndAiResponses(t *testing.T) {
+	responses := []server.ServerPackets{
+		&trips.TsPacket{Timestamp: time.Unix(1700000000, 0), TripId: "trip-1"},
+		&trips.TePacket{Timestamp: time.Unix(1700000000, 0), TripId: "trip-1"},
+		&ai.ImPacket{Timestamp: time.Unix(1700000000, 0), ChatId: "chat-1", Message: "hello"},
+	}
+
+	for _, response := range responses {
+		t.Run(response.Tag(), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, *response.ToPacket())
+			}))
+			defer srv.Close()
+
+			var c clients.HttpComm
+			c.New(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass")
+
+			result, err := c.Send(&client.PrPacket{})
+			if err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if result == nil || result.Tag() != response.Tag() {
+				t.Errorf("expected <%s>, got %v", response.Tag(), result)
+			}
+		})
+	}
+}
+
 func TestHttpComm_Send_TransportError(t *testing.T) {
 	var c clients.HttpComm
 	c.New(clients.HTTP, "127.0.0.1:1", "ident", "pass")
@@ -101,7 +137,7 @@ func TestHttpComm_GetCommands(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetCommands failed: %v", err)
 	}
-	if result == nil || *result == nil {
+	if result == nil {
 		t.Fatal("result is nil")
 	}
 }
@@ -115,3 +151,258 @@ func TestHttpComm_GetCommands_TransportError(t *testing.T) {
 		t.Error("expected transport error")
 	}
 }
+
+func TestHttpComm_GetCommands_NoContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var c clients.HttpComm
+	c.New(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass")
+
+	result, err := c.GetCommands()
+	if err != nil {
+		t.Fatalf("GetCommands failed: %v", err)
+	}
+	if result != nil {
+		t.Errorf("expected nil result without pending commands, got %T", result)
+	}
+}
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestNewHttpComm_Options(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	policy := clients.RetryPolicy{MaxAttempts: 3}
+	c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass",
+		clients.WithHttpClient(&http.Client{Transport: transport}),
+		clients.WithRetryPolicy(policy),
+	)
+
+	if c.Retry != policy {
+		t.Errorf("expected the retry policy to be set, got %+v", c.Retry)
+	}
+	if _, err := c.Send(&client.PrPacket{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := transport.requests.Load(); got != 1 {
+		t.Errorf("expected the request through the given client, got %d requests", got)
+	}
+}
+
+func TestHttpComm_New_DefaultTimeout(t *testing.T) {
+	var c clients.HttpComm
+	c.New(clients.HTTP, "localhost", "ident", "pass")
+
+	if c.Client == nil || c.Client.Timeout != clients.DefaultHttpTimeout {
+		t.Errorf("expected a client with DefaultHttpTimeout, got %+v", c.Client)
+	}
+}
+
+func TestNewHttpComm_NilHttpClient(t *testing.T) {
+	c := clients.NewHttpComm(clients.HTTP, "localhost", "ident", "pass", clients.WithHttpClient(nil))
+
+	if c.Client == nil || c.Client.Timeout != clients.DefaultHttpTimeout {
+		t.Errorf("expected a client with DefaultHttpTimeout, got %+v", c.Client)
+	}
+}
+
+func TestHttpComm_SendContext_Cancelled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass",
+		clients.WithRetryPolicy(clients.RetryPolicy{MaxAttempts: 5}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.SendContext(ctx, &client.PrPacket{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+}
+
+func TestHttpComm_StatusErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantAuth    bool
+		wantServer  bool
+		wantRetries int32
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, wantAuth: true, wantRetries: 1},
+		{name: "bad request", status: http.StatusBadRequest, wantRetries: 1},
+		{name: "internal server error", status: http.StatusInternalServerError, wantServer: true, wantRetries: 3},
+		{name: "service unavailable", status: http.StatusServiceUnavailable, wantServer: true, wantRetries: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				http.Error(w, "failure detail", tt.status)
+			}))
+			defer srv.Close()
+
+			c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass",
+				clients.WithRetryPolicy(clients.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}))
+
+			_, err := c.Send(&client.PrPacket{})
+
+			var authErr *clients.AuthError
+			if got := errors.As(err, &authErr); got != tt.wantAuth {
+				t.Errorf("AuthError = %v, want %v (err %v)", got, tt.wantAuth, err)
+			}
+			if got := errors.Is(err, clients.ErrServerError); got != tt.wantServer {
+				t.Errorf("ErrServerError = %v, want %v (err %v)", got, tt.wantServer, err)
+			}
+
+			var statusErr *clients.StatusError
+			if !tt.wantAuth {
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || statusErr.Body != "failure detail" {
+					t.Errorf("expected StatusError %d, got %v", tt.status, err)
+				}
+			}
+			if got := requests.Load(); got != tt.wantRetries {
+				t.Errorf("expected %d requests, got %d", tt.wantRetries, got)
+			}
+		})
+	}
+}
+
+func TestHttpComm_RetryReplaysTheRequest(t *testing.T) {
+	want := *(&client.PrPacket{}).ToPacket()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, 64)
+		n, _ := r.Body.Read(body)
+		if string(body[:n]) != want {
+			t.Errorf("attempt %d: body = %q, want %q", requests.Load()+1, body[:n], want)
+		}
+		if requests.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprint(w, *(&server.AoPacket{Timestamp: time.Now()}).ToPacket())
+	}))
+	defer srv.Close()
+
+	c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass",
+		clients.WithRetryPolicy(clients.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}))
+
+	result, err := c.Send(&client.PrPacket{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, ok := result.(*server.AoPacket); !ok {
+		t.Errorf("expected <Ao>, got %T", result)
+	}
+}
+
+func TestHttpComm_GetBle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v2/ble" {
+			t.Errorf("expected GET /v2/ble, got %s %s", r.Method, r.URL.Path)
+		}
+		mac, model := "1234567890AB", "GENERIC"
+		devices := []definitions.BleData{{MacAddress: &mac, Model: &model}}
+		_, _ = fmt.Fprint(w, *(&server.AbPacket{Devices: &devices}).ToPacket())
+	}))
+	defer srv.Close()
+
+	c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass")
+	result, err := c.GetBle()
+	if err != nil {
+		t.Fatalf("GetBle failed: %v", err)
+	}
+	if _, ok := result.(*server.AbPacket); !ok {
+		t.Errorf("expected <Ab>, got %T", result)
+	}
+}
+
+func TestHttpComm_SendSos(t *testing.T) {
+	bodies := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass")
+
+	message := c.ComposeEmptyPd()
+	message.ExtraData["engine.ignition.status"] = true
+	if _, err := c.SendSos(message); err != nil {
+		t.Fatalf("SendSos failed: %v", err)
+	}
+	if _, ok := message.ExtraData["alarm.event"]; ok {
+		t.Error("SendSos should not modify the given message")
+	}
+	if _, err := c.SendSos(nil); err != nil {
+		t.Fatalf("SendSos without message failed: %v", err)
+	}
+
+	for _, wantIgnition := range []bool{true, false} {
+		raw := <-bodies
+		var pd client.PdPacket
+		if err := pd.FromPacket(&raw); err != nil {
+			t.Fatalf("invalid <Pd>: %v", err)
+		}
+		if pd.ExtraData["alarm.event"] != true {
+			t.Errorf("expected alarm.event=true, got %v", pd.ExtraData)
+		}
+		if _, ok := pd.ExtraData["engine.ignition.status"]; ok != wantIgnition {
+			t.Errorf("unexpected extra data %v", pd.ExtraData)
+		}
+	}
+}
+
+func TestHttpComm_SendImage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v2/image/front_camera_01_jpg" {
+			t.Errorf("expected POST /v2/image/front_camera_01_jpg, got %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if want := "data:image/jpeg;base64,aW1hZ2U="; string(body) != want {
+			t.Errorf("body = %q, want %q", body, want)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := clients.NewHttpComm(clients.HTTP, srv.Listener.Addr().String(), "ident", "pass")
+	if _, err := c.SendImage([]byte("image"), "front camera/01.jpg", ""); err != nil {
+		t.Fatalf("SendImage failed: %v", err)
+	}
+}
+
+func TestHttpComm_ComposeEmptyPd(t *testing.T) {
+	var c clients.HttpComm
+	pd := c.ComposeEmptyPd()
+
+	if pd.Position == nil || pd.ExtraData == nil || len(pd.ExtraData) != 0 {
+		t.Errorf("expected an empty position and extra data, got %+v", pd)
+	}
+	if time.Since(pd.Timestamp) > time.Minute {
+		t.Errorf("expected the current time, got %s", pd.Timestamp)
+	}
+}
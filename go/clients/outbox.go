@@ -0,0 +1,365 @@
+package clients
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
+)
+
+const (
+	// DefaultOutboxMaxPackets is the packet limit used when OutboxConfig.MaxPackets is not set
+	DefaultOutboxMaxPackets = 10000
+	// DefaultOutboxMaxAge is the age limit used when OutboxConfig.MaxAge is not set
+	DefaultOutboxMaxAge = 7 * 24 * time.Hour
+	// DefaultAckTimeout is the time TcpComm waits for the <Ao> of a packet of the outbox
+	// when TcpComm.AckTimeout is not set
+	DefaultAckTimeout = 30 * time.Second
+)
+
+// Returned when the server does not acknowledge a packet of the outbox in time
+var errAckTimeout = errors.New("acknowledgement timeout")
+
+// ErrPacketExpired is returned by Send for a <Pd> packet whose timestamp is already older
+// than the MaxAge of the outbox, the packet is neither stored nor sent
+var ErrPacketExpired = errors.New("packet is older than the outbox max age")
+
+// OutboxConfig is the configuration of an Outbox
+type OutboxConfig struct {
+	// Path of the file that keeps the packets, it is created if it does not exist
+	Path string
+
+	// Maximum number of packets kept, the oldest ones are dropped first.
+	// By default is DefaultOutboxMaxPackets
+	MaxPackets int
+
+	// Maximum age of a packet, measured from its timestamp, older packets are dropped.
+	// By default is DefaultOutboxMaxAge
+	MaxAge time.Duration
+}
+
+// Outbox is a file-backed queue that keeps the <Pd> and <Pb> packets until the server
+// acknowledges them.
+//
+// Set it as the Outbox of a TcpComm or an HttpComm to store every <Pd> and <Pb> packet
+// before sending it: packets sent while the link is down stay in the outbox and are replayed
+// in timestamp order. A TcpComm waits for <Ao> and retries the packets answered with <Ar>,
+// an HttpComm accepts any 2xx response and drops the packets answered with <Ar> or a 4xx
+// status code, other than 401.
+// An Outbox must not be shared by several clients
+type Outbox struct {
+	path       string
+	maxPackets int
+	maxAge     time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	entries []outboxEntry
+	seq     uint64
+	// Number of removal records in the file since the last compaction
+	removed int
+
+	// Signaled when a packet is stored
+	notify chan struct{}
+}
+
+// A packet stored in the outbox
+type outboxEntry struct {
+	seq       uint64
+	timestamp time.Time
+	frame     string
+}
+
+// NewOutbox opens the outbox stored in cfg.Path, the packets left by a previous run are
+// kept for replay
+func NewOutbox(cfg OutboxConfig) (*Outbox, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("outbox path is required")
+	}
+
+	o := &Outbox{
+		path:       cfg.Path,
+		maxPackets: cfg.MaxPackets,
+		maxAge:     cfg.MaxAge,
+		notify:     make(chan struct{}, 1),
+	}
+	if o.maxPackets <= 0 {
+		o.maxPackets = DefaultOutboxMaxPackets
+	}
+	if o.maxAge <= 0 {
+		o.maxAge = DefaultOutboxMaxAge
+	}
+
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.compactLocked(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Len returns the number of packets waiting for acknowledgement
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Close closes the file of the outbox, the packets are kept for the next run
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+// Returns true for the packets stored in the outbox
+func isOutboxPacket(packet any) bool {
+	switch packet.(type) {
+	case *client.PdPacket, *client.PbPacket:
+		return true
+	}
+	return false
+}
+
+// Stores a packet, the file is synced before returning
+func (o *Outbox) push(packet any) (outboxEntry, error) {
+	data, err := EncodeClientPacket(packet)
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
+	timestamp := time.Now()
+	if pd, ok := packet.(*client.PdPacket); ok && !pd.Timestamp.IsZero() {
+		timestamp = pd.Timestamp
+	}
+
+	if timestamp.Before(time.Now().Add(-o.maxAge)) {
+		return outboxEntry{}, ErrPacketExpired
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return outboxEntry{}, errors.New("outbox closed")
+	}
+
+	o.seq++
+	entry := outboxEntry{seq: o.seq, timestamp: timestamp, frame: *data}
+	if _, err := fmt.Fprintf(o.file, "+ %d %d %s\n", entry.seq, entry.timestamp.UnixMilli(), entry.frame); err != nil {
+		return outboxEntry{}, err
+	}
+	if err := o.file.Sync(); err != nil {
+		return outboxEntry{}, err
+	}
+	o.entries = append(o.entries, entry)
+
+	if err := o.trimLocked(); err != nil {
+		return outboxEntry{}, err
+	}
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return entry, nil
+}
+
+// Returns the stored packets in timestamp order, dropping the expired ones
+func (o *Outbox) pending() []outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.trimLocked(); err != nil {
+		log.Printf("Error trimming the outbox: %s\n", err)
+	}
+
+	entries := slices.Clone(o.entries)
+	sortEntries(entries)
+	return entries
+}
+
+// Removes an acknowledged packet
+func (o *Outbox) ack(seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.removeLocked(func(entry outboxEntry) bool { return entry.seq == seq })
+}
+
+// Drops the packets over the age and size limits
+func (o *Outbox) trimLocked() error {
+	expiry := time.Now().Add(-o.maxAge)
+	if err := o.removeLocked(func(entry outboxEntry) bool {
+		if entry.timestamp.Before(expiry) {
+			log.Printf("Dropping expired packet %s\n", entry.frame)
+			return true
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+
+	excess := len(o.entries) - o.maxPackets
+	if excess <= 0 {
+		return nil
+	}
+
+	oldest := slices.Clone(o.entries)
+	sortEntries(oldest)
+	dropped := make(map[uint64]bool, excess)
+	for _, entry := range oldest[:excess] {
+		log.Printf("Outbox full, dropping packet %s\n", entry.frame)
+		dropped[entry.seq] = true
+	}
+	return o.removeLocked(func(entry outboxEntry) bool { return dropped[entry.seq] })
+}
+
+// Removes the packets that match and records the removal in the file, which is compacted
+// once the removal records outnumber the stored packets
+func (o *Outbox) removeLocked(match func(outboxEntry) bool) error {
+	kept := o.entries[:0]
+	var records strings.Builder
+	for _, entry := range o.entries {
+		if match(entry) {
+			fmt.Fprintf(&records, "- %d\n", entry.seq)
+			o.removed++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	clear(o.entries[len(kept):])
+	o.entries = kept
+
+	if records.Len() == 0 {
+		return nil
+	}
+	if o.file == nil {
+		return errors.New("outbox closed")
+	}
+	if _, err := o.file.WriteString(records.String()); err != nil {
+		return err
+	}
+
+	if o.removed > max(len(o.entries), 64) {
+		return o.compactLocked()
+	}
+	return nil
+}
+
+// Reads the packets stored in the file, lines that cannot be parsed, like one left
+// half-written by a crash, are skipped
+func (o *Outbox) load() error {
+	file, err := os.Open(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), helpers.DefaultMaxFrameSize+64)
+
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 4)
+		if len(parts) < 2 {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		o.seq = max(o.seq, seq)
+
+		switch {
+		case parts[0] == "+" && len(parts) == 4 && isCompleteFrame(parts[3]):
+			millis, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			o.entries = append(o.entries, outboxEntry{seq: seq, timestamp: time.UnixMilli(millis), frame: parts[3]})
+
+		case parts[0] == "-":
+			o.entries = slices.DeleteFunc(o.entries, func(entry outboxEntry) bool { return entry.seq == seq })
+		}
+	}
+	return scanner.Err()
+}
+
+// Rewrites the file with the stored packets only and reopens it for appending
+func (o *Outbox) compactLocked() error {
+	tmpPath := o.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range o.entries {
+		_, _ = fmt.Fprintf(writer, "+ %d %d %s\n", entry.seq, entry.timestamp.UnixMilli(), entry.frame)
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if o.file != nil {
+		_ = o.file.Close()
+		o.file = nil
+	}
+	if err := os.Rename(tmpPath, o.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	o.file = file
+	o.removed = 0
+	return nil
+}
+
+// Sorts the packets by timestamp, packets with the same timestamp keep their order
+func sortEntries(entries []outboxEntry) {
+	slices.SortStableFunc(entries, func(a, b outboxEntry) int {
+		if c := a.timestamp.Compare(b.timestamp); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+}
+
+// Returns true if the frame has its opening and closing tags
+func isCompleteFrame(frame string) bool {
+	return len(frame) >= 9 && frame[0] == '<' && frame[3] == '>' && strings.HasSuffix(frame, "</"+frame[1:4])
+}
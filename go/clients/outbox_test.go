@@ -0,0 +1,418 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+func newTestOutbox(t *testing.T, cfg OutboxConfig) *Outbox {
+	t.Helper()
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "outbox")
+	}
+	outbox, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatalf("NewOutbox failed: %v", err)
+	}
+	t.Cleanup(func() { _ = outbox.Close() })
+	return outbox
+}
+
+// pdAt returns a <Pd> packet with the given age
+func pdAt(age time.Duration) *client.PdPacket {
+	return &client.PdPacket{Timestamp: time.Now().Add(-age).Truncate(time.Second)}
+}
+
+func pendingFrames(o *Outbox) []string {
+	var frames []string
+	for _, entry := range o.pending() {
+		frames = append(frames, entry.frame)
+	}
+	return frames
+}
+
+func TestNewOutbox_RequiresPath(t *testing.T) {
+	if _, err := NewOutbox(OutboxConfig{}); err == nil {
+		t.Error("expected error without path")
+	}
+}
+
+func TestOutbox_TimestampOrder(t *testing.T) {
+	outbox := newTestOutbox(t, OutboxConfig{})
+
+	newer, older := pdAt(time.Minute), pdAt(time.Hour)
+	for _, packet := range []any{newer, older} {
+		if _, err := outbox.push(packet); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+
+	frames := pendingFrames(outbox)
+	want := []string{*older.ToPacket(), *newer.ToPacket()}
+	if strings.Join(frames, ",") != strings.Join(want, ",") {
+		t.Errorf("pending = %q, want %q", frames, want)
+	}
+}
+
+func TestOutbox_IgnoresOtherPackets(t *testing.T) {
+	if !isOutboxPacket(&client.PdPacket{}) || !isOutboxPacket(&client.PbPacket{}) {
+		t.Error("expected <Pd> and <Pb> to be stored")
+	}
+	if isOutboxPacket(&client.PrPacket{}) || isOutboxPacket(&client.PaPacket{}) {
+		t.Error("expected other packets to be sent directly")
+	}
+}
+
+func TestOutbox_PersistsAcrossRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox")
+	outbox := newTestOutbox(t, OutboxConfig{Path: path})
+
+	first, err := outbox.push(pdAt(3 * time.Minute))
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	for _, age := range []time.Duration{2 * time.Minute, time.Minute} {
+		if _, err := outbox.push(pdAt(age)); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+	if err := outbox.ack(first.seq); err != nil {
+		t.Fatalf("ack failed: %v", err)
+	}
+	want := pendingFrames(outbox)
+	_ = outbox.Close()
+
+	reopened := newTestOutbox(t, OutboxConfig{Path: path})
+	if got := pendingFrames(reopened); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("pending after reopen = %q, want %q", got, want)
+	}
+
+	// Sequence numbers are not reused after a restart
+	entry, err := reopened.push(pdAt(0))
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if entry.seq != 4 {
+		t.Errorf("expected seq 4, got %d", entry.seq)
+	}
+}
+
+func TestOutbox_SkipsTruncatedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox")
+	frame := *pdAt(0).ToPacket()
+	content := fmt.Sprintf("+ 1 %d %s\n+ 2 %d %s", time.Now().UnixMilli(), frame, time.Now().UnixMilli(), frame[:10])
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := newTestOutbox(t, OutboxConfig{Path: path})
+	if got := pendingFrames(outbox); len(got) != 1 || got[0] != frame {
+		t.Errorf("pending = %q, want only the complete frame", got)
+	}
+}
+
+func TestOutbox_MaxPackets(t *testing.T) {
+	outbox := newTestOutbox(t, OutboxConfig{MaxPackets: 2})
+
+	oldest := pdAt(3 * time.Minute)
+	for _, packet := range []*client.PdPacket{pdAt(time.Minute), oldest, pdAt(2 * time.Minute)} {
+		if _, err := outbox.push(packet); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+
+	frames := pendingFrames(outbox)
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(frames))
+	}
+	for _, frame := range frames {
+		if frame == *oldest.ToPacket() {
+			t.Error("expected the oldest packet to be dropped")
+		}
+	}
+}
+
+func TestOutbox_MaxAge(t *testing.T) {
+	outbox := newTestOutbox(t, OutboxConfig{MaxAge: time.Hour})
+
+	if _, err := outbox.push(pdAt(2 * time.Hour)); !errors.Is(err, ErrPacketExpired) {
+		t.Fatalf("expected ErrPacketExpired, got %v", err)
+	}
+	if _, err := outbox.push(pdAt(time.Minute)); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	if got := outbox.Len(); got != 1 {
+		t.Errorf("expected the expired packet not to be stored, got %d packets", got)
+	}
+}
+
+func TestOutbox_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox")
+	outbox := newTestOutbox(t, OutboxConfig{Path: path})
+
+	for range 200 {
+		entry, err := outbox.push(pdAt(0))
+		if err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+		if err := outbox.ack(entry.seq); err != nil {
+			t.Fatalf("ack failed: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 64*100 {
+		t.Errorf("expected the file to be compacted, size is %d", info.Size())
+	}
+}
+
+func TestHttpComm_Outbox(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	reject := true
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(body))
+
+		if reject {
+			reject = false
+			_, _ = fmt.Fprint(w, *(&server.ArPacket{Reason: "busy"}).ToPacket())
+			return
+		}
+		_, _ = fmt.Fprint(w, *(&server.AoPacket{Timestamp: time.Now()}).ToPacket())
+	}))
+	defer srv.Close()
+
+	var c HttpComm
+	c.New(HTTP, "127.0.0.1:1", "ident", "pass")
+	c.Outbox = newTestOutbox(t, OutboxConfig{})
+
+	older, newer := pdAt(time.Hour), pdAt(time.Minute)
+	if _, err := c.Send(newer); err == nil {
+		t.Fatal("expected transport error")
+	}
+	if _, err := c.Send(older); err == nil {
+		t.Fatal("expected transport error")
+	}
+	if got := c.Outbox.Len(); got != 2 {
+		t.Fatalf("expected the packets to be kept, got %d", got)
+	}
+
+	// The older packet is rejected and dropped, the newer one is acknowledged
+	c.Host = srv.Listener.Addr().String()
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := c.Outbox.Len(); got != 0 {
+		t.Errorf("expected an empty outbox, got %d", got)
+	}
+
+	response, err := c.Send(&client.PbPacket{Advertisements: &[]definitions.BleAdvertisement{}})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, ok := response.(*server.AoPacket); !ok {
+		t.Errorf("expected the <Ao> of the sent packet, got %T", response)
+	}
+	if got := c.Outbox.Len(); got != 0 {
+		t.Errorf("expected an empty outbox, got %d", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{*older.ToPacket(), *newer.ToPacket()}
+	if len(received) != 3 || strings.Join(received[:2], ",") != strings.Join(want, ",") {
+		t.Errorf("received = %q, want %q and the <Pb>", received, want)
+	}
+}
+
+func TestHttpComm_Outbox_BadRequestDropped(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	reject := 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(body))
+
+		if reject > 0 {
+			reject--
+			http.Error(w, "invalid packet", http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprint(w, *(&server.AoPacket{Timestamp: time.Now()}).ToPacket())
+	}))
+	defer srv.Close()
+
+	var c HttpComm
+	c.New(HTTP, "127.0.0.1:1", "ident", "pass")
+	c.Outbox = newTestOutbox(t, OutboxConfig{})
+
+	older, newer := pdAt(time.Hour), pdAt(time.Minute)
+	_, _ = c.Send(older)
+	_, _ = c.Send(newer)
+
+	// The older packet is rejected with 400 and dropped, the newer one is still delivered
+	c.Host = srv.Listener.Addr().String()
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := c.Outbox.Len(); got != 0 {
+		t.Errorf("expected an empty outbox, got %d", got)
+	}
+
+	// A sent packet rejected with 400 returns the error and does not block the next ones
+	mu.Lock()
+	reject = 1
+	mu.Unlock()
+	var statusErr *StatusError
+	if _, err := c.Send(pdAt(time.Second)); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the 400 StatusError, got %v", err)
+	}
+	last := pdAt(0)
+	if response, err := c.Send(last); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	} else if _, ok := response.(*server.AoPacket); !ok {
+		t.Errorf("expected the <Ao> of the sent packet, got %T", response)
+	}
+	if got := c.Outbox.Len(); got != 0 {
+		t.Errorf("expected an empty outbox, got %d", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 4 || received[1] != *newer.ToPacket() || received[3] != *last.ToPacket() {
+		t.Errorf("received = %q", received)
+	}
+}
+
+func TestHttpComm_Outbox_NoContentAcks(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received[string(body)]++
+		mu.Unlock()
+
+		// Like HttpServer when OnNewPacket returns nil
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var c HttpComm
+	c.New(HTTP, srv.Listener.Addr().String(), "ident", "pass")
+	c.Outbox = newTestOutbox(t, OutboxConfig{})
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			if response, err := c.Send(pdAt(time.Duration(i) * time.Minute)); err != nil || response != nil {
+				t.Errorf("Send = %v, %v", response, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := c.Outbox.Len(); got != 0 {
+		t.Errorf("expected an empty outbox, got %d", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 10 {
+		t.Errorf("expected 10 packets, got %d", len(received))
+	}
+	for frame, count := range received {
+		if count != 1 {
+			t.Errorf("%s was posted %d times", frame, count)
+		}
+	}
+}
+
+func TestTcpComm_OutboxReplay(t *testing.T) {
+	received := make(chan string, 8)
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+		_, _ = reader.ReadString('\n')
+		_, _ = fmt.Fprint(conn, *(&server.AsPacket{}).ToPacket()+"\r\n")
+
+		for i := 0; ; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- strings.TrimSpace(line)
+
+			var answer string
+			if i == 0 {
+				answer = *(&server.ArPacket{Reason: "retry"}).ToPacket()
+			} else {
+				answer = *(&server.AoPacket{Timestamp: time.Now()}).ToPacket()
+			}
+			_, _ = fmt.Fprint(conn, answer+"\r\n")
+		}
+	})
+
+	c := newTestComm(port)
+	c.Outbox = newTestOutbox(t, OutboxConfig{})
+
+	// Packets sent while disconnected are stored
+	older, newer := pdAt(time.Hour), pdAt(time.Minute)
+	for _, packet := range []*client.PdPacket{newer, older} {
+		if err := c.Send(packet); err != nil {
+			t.Fatalf("Send while disconnected failed: %v", err)
+		}
+	}
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	for _, want := range []string{*older.ToPacket(), *newer.ToPacket()} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("received %q, want %q", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("packet was not replayed")
+		}
+	}
+
+	// The rejected packet is kept for the next connection
+	deadline := time.Now().Add(3 * time.Second)
+	for c.Outbox.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := pendingFrames(c.Outbox); len(got) != 1 || got[0] != *older.ToPacket() {
+		t.Errorf("pending = %q, want the rejected packet", got)
+	}
+}
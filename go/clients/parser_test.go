@@ -63,7 +63,7 @@ func TestDecodeServerOutput(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-			if result == nil || *result == nil {
+			if result == nil {
 				t.Fatal("result is nil")
 			}
 		})
@@ -0,0 +1,75 @@
+package clients
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// ConnState is the state of the TcpComm connection
+type ConnState int
+
+const (
+	// StateDisconnected means the connection was lost, TcpComm is waiting to reconnect
+	StateDisconnected ConnState = iota
+	// StateConnecting means TcpComm is dialing the server and running the <Pa> handshake
+	StateConnecting
+	// StateConnected means the session is authenticated and packets can be sent
+	StateConnected
+	// StateClosed means TcpComm stopped and will not reconnect
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultMinBackoff is the first reconnection delay used when TcpComm.MinBackoff is not set
+	DefaultMinBackoff = time.Second
+	// DefaultMaxBackoff is the reconnection delay limit used when TcpComm.MaxBackoff is not set
+	DefaultMaxBackoff = time.Minute
+	// DefaultAuthTimeout is the handshake limit used when TcpComm.AuthTimeout is not set
+	DefaultAuthTimeout = time.Minute
+)
+
+var (
+	// ErrNotConnected is returned by Send while there is no authenticated session
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrAuthTimeout is returned when the server does not answer the <Pa> handshake in time
+	ErrAuthTimeout = errors.New("authentication timeout")
+)
+
+// AuthError is returned when the server rejects the <Pa> handshake with an <Ar> packet,
+// TcpComm does not reconnect after it. HttpComm returns it for 401 responses
+type AuthError struct {
+	// Reason sent by the server
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	return "authentication rejected: " + e.Reason
+}
+
+// Returns the delay before the given reconnection attempt, it doubles on every attempt up to
+// maxDelay and a random jitter of up to half the delay avoids every device reconnecting at once
+func backoff(attempt int, minDelay, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempt < 32 && minDelay<<attempt > 0 && minDelay<<attempt < maxDelay {
+		delay = minDelay << attempt
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
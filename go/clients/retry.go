@@ -0,0 +1,64 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrServerError matches the StatusError of a 5xx response, use errors.Is
+var ErrServerError = errors.New("server error")
+
+// StatusError is returned by HttpComm when the server answers with an unexpected status code,
+// a 401 is returned as an *AuthError instead
+type StatusError struct {
+	// Status code of the response
+	StatusCode int
+	// Body of the response, truncated
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("unexpected status %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Is reports 5xx status codes as ErrServerError
+func (e *StatusError) Is(target error) bool {
+	return target == ErrServerError && e.StatusCode >= 500
+}
+
+// RetryPolicy defines how HttpComm retries the requests that fail with a network error
+// or a 5xx status code. The request is replayed as is, so the server may receive a packet
+// twice if only its answer was lost
+type RetryPolicy struct {
+	// Number of attempts of a request, including the first one. Zero or one disables the retries
+	MaxAttempts int
+	// Delay before the first retry, doubled on every retry. By default is DefaultMinBackoff
+	MinBackoff time.Duration
+	// Maximum delay between retries, by default is DefaultMaxBackoff
+	MaxBackoff time.Duration
+}
+
+// Returns the delay before the given retry
+func (r RetryPolicy) delay(retry int) time.Duration {
+	minDelay, maxDelay := r.MinBackoff, r.MaxBackoff
+	if minDelay <= 0 {
+		minDelay = DefaultMinBackoff
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxBackoff
+	}
+	return backoff(retry, minDelay, maxDelay)
+}
+
+// Reads the start of an error response body
+func readErrorBody(body io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(body, 512))
+	return strings.TrimSpace(string(data))
+}
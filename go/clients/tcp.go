@@ -1,14 +1,16 @@
 package clients
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
-	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
@@ -18,12 +20,47 @@ type TcpComm struct {
 	Ident  string
 	Passwd string
 
-	initialized   bool
-	callback      *func(*any)
-	conn          *net.Conn
-	authenticated bool
-	splitRegExp   *regexp.Regexp
-	endRegExp     *regexp.Regexp
+	// Delay before the first reconnection attempt, doubled on every failed attempt.
+	// By default is DefaultMinBackoff
+	MinBackoff time.Duration
+	// Maximum delay between reconnection attempts, by default is DefaultMaxBackoff
+	MaxBackoff time.Duration
+	// Time to wait for the server to answer the <Pa> handshake, by default is DefaultAuthTimeout
+	AuthTimeout time.Duration
+
+	// Optional outbox that keeps the <Pd> and <Pb> packets until the server answers them
+	// with <Ao>. The packets are sent one at a time, after each handshake and whenever a new
+	// one is stored, so every <Ao> or <Ar> received meanwhile is taken as the answer of the
+	// packet in flight
+	Outbox *Outbox
+	// Time to wait for the <Ao> of a packet of the outbox, by default is DefaultAckTimeout
+	AckTimeout time.Duration
+
+	// Interval between <Pr> heartbeats, each one must be answered with <Ao>. By default is
+	// zero, heartbeats are disabled
+	HeartbeatInterval time.Duration
+	// Number of heartbeats in a row without <Ao> after which the connection is considered dead
+	// and closed, it is reconnected as any lost connection. By default is DefaultMaxMissedHeartbeats
+	MaxMissedHeartbeats int
+
+	// Optional dispatcher that runs the <Ac> commands and sends their <Pc> responses,
+	// the OnCommands handler is still called. Like the packets of the outbox, each <Pc>
+	// waits up to AckTimeout for its <Ao> before the next packet is sent
+	Commands *CommandDispatcher
+
+	initialized bool
+
+	mu       sync.Mutex
+	handlers tcpHandlers
+	conn     net.Conn
+	state    ConnState
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	// Receives the answer to the packet of the outbox in flight, true for <Ao>
+	pendingAck chan bool
+
+	writeMu sync.Mutex
 }
 
 // New creates a new intance of LayrzProtocol using TCP communication
@@ -33,129 +70,436 @@ func (p *TcpComm) New(host string, port int, ident, password string) {
 	p.Ident = ident
 	p.Passwd = password
 
+	p.MinBackoff = DefaultMinBackoff
+	p.MaxBackoff = DefaultMaxBackoff
+	p.AuthTimeout = DefaultAuthTimeout
+	p.AckTimeout = DefaultAckTimeout
+	p.MaxMissedHeartbeats = DefaultMaxMissedHeartbeats
+
 	p.initialized = true
-	p.callback = nil
-	p.authenticated = false
+	p.state = StateDisconnected
+}
 
-	p.splitRegExp = regexp.MustCompile(`(<[A-Z][a-z]>[\s\S]*?</[A-Z][a-z]>)`)
-	p.endRegExp = regexp.MustCompile(`</[A-Z][a-z]>`)
+// State returns the current state of the connection
+func (p *TcpComm) State() ConnState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
 }
 
-// SetCallback sets the callback function to be called when a packet is received
-func (p *TcpComm) SetCallback(callback func(*any)) error {
+// Send sends a packet to the server, returns ErrNotConnected while reconnecting.
+//
+// With an Outbox, <Pd> and <Pb> packets are stored instead and sent once connected,
+// the error is only returned when the packet cannot be stored
+func (p *TcpComm) Send(packet any) error {
 	if !p.initialized {
 		return errors.New("tcp comm not initialized")
 	}
 
-	p.callback = &callback
-	return nil
+	if p.Outbox != nil && isOutboxPacket(packet) {
+		_, err := p.Outbox.push(packet)
+		return err
+	}
+
+	p.mu.Lock()
+	conn := p.conn
+	connected := p.state == StateConnected
+	p.mu.Unlock()
+
+	if conn == nil || !connected {
+		return ErrNotConnected
+	}
+	return p.write(conn, packet)
 }
 
-// Send sends a packet to the server
-func (p *TcpComm) Send(packet any) error {
+// Connect connects to the server and runs the <Pa> handshake.
+//
+// If the first connection fails its error is returned. Once connected, the connection
+// is kept alive until ctx is done or Close is called: when it drops, TcpComm reconnects
+// with exponential backoff and runs the handshake again. It only gives up if the server
+// rejects the handshake, reporting StateClosed with an *AuthError
+func (p *TcpComm) Connect(ctx context.Context) error {
 	if !p.initialized {
 		return errors.New("tcp comm not initialized")
 	}
 
-	data, err := EncodeClientPacket(packet)
-	if err != nil {
-		return err
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.mu.Unlock()
+		return errors.New("tcp comm already connected")
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	p.ctx = ctx
+	p.cancel = cancel
+	p.done = done
+	p.mu.Unlock()
 
-	*data += "\r\n"
-
-	log.Printf("Sending %s\n", *data)
-	if _, err := (*p.conn).Write([]byte(*data)); err != nil {
+	lost, err := p.connect(ctx)
+	if err != nil {
+		cancel()
+		p.mu.Lock()
+		p.cancel = nil
+		p.mu.Unlock()
+		close(done)
+		p.setState(StateClosed, err)
 		return err
 	}
 
+	go p.supervise(ctx, lost, done)
 	return nil
 }
 
-// Connect connects to the server
-func (p *TcpComm) Connect() error {
-	if !p.initialized {
-		return errors.New("tcp comm not initialized")
-	}
-	p.authenticated = false
+// Dials the server and runs the <Pa> handshake, returns the channel that receives
+// the error that ends the connection
+func (p *TcpComm) connect(ctx context.Context) (<-chan error, error) {
+	p.setState(StateConnecting, nil)
 
-	conn, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	p.conn = &conn
-	go p.listen()
+	auth := make(chan error, 1)
+	lost := make(chan error, 1)
+	l := newLink(conn)
+	go func() {
+		err := p.listen(l, auth)
+		_ = conn.Close()
+		close(l.closed)
+		if cause := l.cause(); cause != nil {
+			err = cause
+		}
+		lost <- err
+	}()
 
-	if err := p.Send(&client.PaPacket{
-		Ident:    &p.Ident,
-		Password: &p.Passwd,
-	}); err != nil {
-		return err
+	if err := p.write(conn, &client.PaPacket{Ident: &p.Ident, Password: &p.Passwd}); err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
 
-	for attempts := 0; !p.authenticated; attempts++ {
-		if attempts > 60 {
-			return errors.New("authentication timeout")
+	timer := time.NewTimer(p.AuthTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-auth:
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
 		}
-		log.Println("Waiting for authentication...")
-		time.Sleep(time.Second)
+	case err := <-lost:
+		return nil, err
+	case <-timer.C:
+		_ = conn.Close()
+		return nil, ErrAuthTimeout
+	case <-ctx.Done():
+		_ = conn.Close()
+		return nil, ctx.Err()
 	}
-	return nil
+
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+	p.setState(StateConnected, nil)
+
+	if p.Outbox != nil {
+		go p.replay(l)
+	}
+	if p.HeartbeatInterval > 0 {
+		go p.heartbeat(l)
+	}
+	return lost, nil
 }
 
-// Listen for incoming data
-func (p *TcpComm) listen() {
-	buffer := make([]byte, 4096)
-	messages := []byte{}
+// Keeps the connection alive until ctx is done
+func (p *TcpComm) supervise(ctx context.Context, lost <-chan error, done chan struct{}) {
+	defer close(done)
 
 	for {
-		n, err := (*p.conn).Read(buffer)
-		if err != nil {
+		select {
+		case <-ctx.Done():
+			p.disconnect()
+			p.setState(StateClosed, nil)
+			return
+
+		case err := <-lost:
 			log.Println("Connection closed:", err)
-			panic(err)
-		}
+			p.disconnect()
+			p.setState(StateDisconnected, err)
 
-		messages = append(messages, buffer[:n]...)
-		if p.endRegExp.Match(messages) {
-			// Split messages
-			messagesSplit := p.splitRegExp.FindAll(messages, -1)
-			for _, message := range messagesSplit {
-				log.Printf("Received message %s\n", message)
-				packet, err := DecodeServerOutput(string(message))
-				if err != nil {
-					log.Printf("Error handling server output %s\n", err)
-					break
+			lost, err = p.reconnect(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					err = nil
 				}
+				p.setState(StateClosed, err)
+				return
+			}
+		}
+	}
+}
 
-				switch (*packet).(type) {
-				case *server.AsPacket:
-					p.authenticated = true
+// Retries the connection with exponential backoff until it succeeds, ctx is done
+// or the server rejects the handshake
+func (p *TcpComm) reconnect(ctx context.Context) (<-chan error, error) {
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(backoff(attempt, p.MinBackoff, p.MaxBackoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
 
-				case *server.AuPacket: //nolint:staticcheck
-					log.Println("Deprecated AuPacket...")
+		lost, err := p.connect(ctx)
+		if err == nil {
+			return lost, nil
+		}
+
+		var authErr *AuthError
+		if errors.As(err, &authErr) || ctx.Err() != nil {
+			return nil, err
+		}
 
-				default:
-					if p.callback != nil {
-						log.Println("Calling callback function...")
-						(*p.callback)(packet)
-					} else {
-						log.Println("No callback function set...")
-					}
+		log.Printf("Reconnection attempt %d failed: %s\n", attempt+1, err)
+		p.setState(StateDisconnected, err)
+	}
+}
+
+// Reads the frames of the connection until it fails, the result of the <Pa> handshake
+// is sent to auth and every other packet to its handler
+func (p *TcpComm) listen(l *link, auth chan<- error) error {
+	frames := helpers.NewFrameReader(l.conn, helpers.FrameScanner{
+		OnGarbage: func(data []byte) {
+			log.Printf("Discarding garbage %s\n", data)
+		},
+	})
+
+	authenticated := false
+	for {
+		message, err := frames.Next()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Received message %s\n", message)
+		packet, err := DecodeServerOutput(string(message))
+		if err != nil {
+			log.Printf("Error handling server output %s\n", err)
+			continue
+		}
+
+		if !authenticated {
+			switch output := packet.(type) {
+			case *server.AsPacket:
+				authenticated = true
+				auth <- nil
+				continue
+
+			case *server.ArPacket:
+				err := &AuthError{Reason: output.Reason}
+				auth <- err
+				return err
+			}
+		}
+
+		switch output := packet.(type) {
+		case *server.AoPacket:
+			p.answer(true)
+		case *server.ArPacket:
+			p.answer(false)
+		case *server.AcPacket:
+			if p.Commands != nil {
+				// The responses are written to this connection, it may not be the current one yet
+				go p.Commands.run(p.context(), output, func(response *client.PcPacket) error {
+					return p.reply(l, response)
+				})
+			}
+		}
+		p.dispatch(packet)
+	}
+}
+
+// Returns the context of the connection, the background context before Connect
+func (p *TcpComm) context() context.Context {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// Updates the state and calls the OnStateChange handler, if any
+func (p *TcpComm) setState(state ConnState, err error) {
+	p.mu.Lock()
+	p.state = state
+	handler := p.handlers.stateChange
+	p.mu.Unlock()
+
+	if handler != nil {
+		handler(state, err)
+	}
+}
+
+// Closes the current connection, if any
+func (p *TcpComm) disconnect() {
+	p.mu.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+// Sends the packets of the outbox in timestamp order until the connection is closed,
+// waiting for the answer of each one before sending the next. Packets answered with <Ar>
+// are kept and retried on the next connection
+func (p *TcpComm) replay(l *link) {
+	rejected := make(map[uint64]bool)
+	for {
+	pass:
+		for _, entry := range p.Outbox.pending() {
+			if rejected[entry.seq] {
+				continue
+			}
+
+			acked, err := p.deliver(l, entry)
+			switch {
+			case errors.Is(err, errAckTimeout):
+				log.Printf("Packet %s was not acknowledged, retrying later\n", entry.frame)
+				break pass
+			case err != nil:
+				return
+			case acked:
+				if err := p.Outbox.ack(entry.seq); err != nil {
+					log.Printf("Error removing packet from the outbox: %s\n", err)
 				}
+			default:
+				rejected[entry.seq] = true
 			}
+		}
+
+		select {
+		case <-l.closed:
+			return
+		case <-p.Outbox.notify:
+		}
+	}
+}
+
+// Sends a packet of the outbox and waits for its answer, returns true for <Ao>
+func (p *TcpComm) deliver(l *link, entry outboxEntry) (bool, error) {
+	if !l.acquire() {
+		return false, net.ErrClosed
+	}
+	defer l.release()
+	return p.request(l, entry.frame, p.AckTimeout)
+}
+
+// Sends the <Pc> response of a command and waits for its answer, so the answer is not
+// taken as the one of a packet of the outbox
+func (p *TcpComm) reply(l *link, response *client.PcPacket) error {
+	if !l.acquire() {
+		return net.ErrClosed
+	}
+	defer l.release()
+
+	acked, err := p.request(l, *response.ToPacket(), p.AckTimeout)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("response rejected by the server")
+	}
+	return nil
+}
 
-			messages = []byte{}
+// Writes a packet answered with <Ao> or <Ar> and waits for the answer, returns true for <Ao>.
+// The caller must hold the exchange of the link
+func (p *TcpComm) request(l *link, frame string, timeout time.Duration) (bool, error) {
+	answer := make(chan bool, 1)
+	p.mu.Lock()
+	p.pendingAck = answer
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		if p.pendingAck == answer {
+			p.pendingAck = nil
 		}
+		p.mu.Unlock()
+	}()
+
+	if err := p.writeFrame(l.conn, frame); err != nil {
+		return false, err
 	}
 
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case acked := <-answer:
+		return acked, nil
+	case <-timer.C:
+		return false, errAckTimeout
+	case <-l.closed:
+		return false, net.ErrClosed
+	}
 }
 
-// Close closes the connection
+// Hands an <Ao> or <Ar> to the packet of the outbox in flight, if any
+func (p *TcpComm) answer(acked bool) {
+	p.mu.Lock()
+	answer := p.pendingAck
+	p.pendingAck = nil
+	p.mu.Unlock()
+
+	if answer != nil {
+		answer <- acked
+	}
+}
+
+// Encodes and writes a packet
+func (p *TcpComm) write(conn net.Conn, packet any) error {
+	data, err := EncodeClientPacket(packet)
+	if err != nil {
+		return err
+	}
+	return p.writeFrame(conn, *data)
+}
+
+// Writes an encoded packet, the writes of the handshake, Send and the outbox are serialized
+func (p *TcpComm) writeFrame(conn net.Conn, frame string) error {
+	frame += "\r\n"
+
+	log.Printf("Sending %s\n", frame)
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err := conn.Write([]byte(frame))
+	return err
+}
+
+// Close closes the connection and stops the reconnections
 func (p *TcpComm) Close() error {
 	if !p.initialized {
 		return errors.New("tcp comm not initialized")
 	}
 
-	return (*p.conn).Close()
+	p.mu.Lock()
+	cancel := p.cancel
+	done := p.done
+	p.cancel = nil
+	p.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	<-done
+	return nil
 }
@@ -1,16 +1,92 @@
 package clients
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/ai"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/trips"
 )
 
+// fakeServer accepts connections and hands each one to handle with its index
+func fakeServer(t *testing.T, handle func(conn net.Conn, n int)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn, n)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// acceptHandshake reads the <Pa> packet and answers with <As>
+func acceptHandshake(conn net.Conn) {
+	_, _ = bufio.NewReader(conn).ReadString('\n')
+	_, _ = fmt.Fprint(conn, *(&server.AsPacket{}).ToPacket()+"\r\n")
+}
+
+// stateRecorder collects the state changes of a TcpComm
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnState
+	errs   []error
+}
+
+func (r *stateRecorder) record(state ConnState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, state)
+	r.errs = append(r.errs, err)
+}
+
+func (r *stateRecorder) waitFor(t *testing.T, state ConnState, count int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		seen := 0
+		for _, s := range r.states {
+			if s == state {
+				seen++
+			}
+		}
+		r.mu.Unlock()
+		if seen >= count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("state %s was not reached %d times, got %v", state, count, r.states)
+}
+
+func newTestComm(port int) *TcpComm {
+	var c TcpComm
+	c.New("127.0.0.1", port, "ident", "pass")
+	c.MinBackoff = 10 * time.Millisecond
+	c.MaxBackoff = 50 * time.Millisecond
+	c.AuthTimeout = time.Second
+	return &c
+}
+
 func TestTcpComm_New(t *testing.T) {
 	var c TcpComm
 	c.New("localhost", 5000, "ident", "pass")
@@ -30,52 +106,69 @@ func TestTcpComm_New(t *testing.T) {
 	if !c.initialized {
 		t.Error("expected initialized=true")
 	}
-	if c.splitRegExp == nil {
-		t.Error("expected splitRegExp to be set")
+	if c.MinBackoff != DefaultMinBackoff || c.MaxBackoff != DefaultMaxBackoff || c.AuthTimeout != DefaultAuthTimeout {
+		t.Error("expected default backoff and auth timeout")
 	}
-	if c.endRegExp == nil {
-		t.Error("expected endRegExp to be set")
+	if c.State() != StateDisconnected {
+		t.Errorf("expected StateDisconnected, got %s", c.State())
 	}
 }
 
 func TestTcpComm_NotInitialized(t *testing.T) {
 	var c TcpComm
 
-	if err := c.SetCallback(func(*any) {}); err == nil {
-		t.Error("SetCallback: expected error when not initialized")
-	}
 	if err := c.Send(&client.PrPacket{}); err == nil {
 		t.Error("Send: expected error when not initialized")
 	}
+	if err := c.Connect(context.Background()); err == nil {
+		t.Error("Connect: expected error when not initialized")
+	}
 	if err := c.Close(); err == nil {
 		t.Error("Close: expected error when not initialized")
 	}
 }
 
-func TestTcpComm_SetCallback(t *testing.T) {
+func TestTcpComm_Dispatch_TypedHandlers(t *testing.T) {
 	var c TcpComm
 	c.New("localhost", 5000, "ident", "pass")
 
-	called := false
-	if err := c.SetCallback(func(*any) { called = true }); err != nil {
-		t.Fatalf("SetCallback failed: %v", err)
+	got := make(map[string]bool)
+	c.OnCommands(func(*server.AcPacket) { got["Ac"] = true })
+	c.OnBleWhitelist(func(*server.AbPacket) { got["Ab"] = true })
+	c.OnAck(func(*server.AoPacket) { got["Ao"] = true })
+	c.OnError(func(*server.ArPacket) { got["Ar"] = true })
+	c.OnTripStart(func(*trips.TsPacket) { got["Ts"] = true })
+	c.OnTripEnd(func(*trips.TePacket) { got["Te"] = true })
+	c.OnAiMessage(func(*ai.ImPacket) { got["Im"] = true })
+
+	all := []server.ServerPackets{
+		&server.AcPacket{}, &server.AbPacket{}, &server.AoPacket{}, &server.ArPacket{},
+		&trips.TsPacket{}, &trips.TePacket{}, &ai.ImPacket{},
 	}
-	if c.callback == nil {
-		t.Error("expected callback to be set")
-	}
-	(*c.callback)(nil)
-	if !called {
-		t.Error("callback was not called")
+	for _, packet := range all {
+		c.dispatch(packet)
+		if !got[packet.Tag()] {
+			t.Errorf("handler of <%s> was not called", packet.Tag())
+		}
 	}
 }
 
+func TestTcpComm_Dispatch_WithoutHandler(t *testing.T) {
+	var c TcpComm
+	c.New("localhost", 5000, "ident", "pass")
+
+	// Packets without handler are dropped
+	c.dispatch(&server.AcPacket{})
+}
+
 func TestTcpComm_Send_HappyPath(t *testing.T) {
 	clientConn, serverConn := net.Pipe()
 	defer func() { _ = serverConn.Close() }()
 
 	var c TcpComm
 	c.New("localhost", 5000, "ident", "pass")
-	c.conn = &clientConn
+	c.conn = clientConn
+	c.state = StateConnected
 
 	done := make(chan []byte, 1)
 	go func() {
@@ -98,12 +191,18 @@ func TestTcpComm_Send_HappyPath(t *testing.T) {
 	}
 }
 
-func TestTcpComm_Close_HappyPath(t *testing.T) {
-	clientConn, _ := net.Pipe()
+func TestTcpComm_Send_NotConnected(t *testing.T) {
+	var c TcpComm
+	c.New("localhost", 5000, "ident", "pass")
 
+	if err := c.Send(&client.PrPacket{}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestTcpComm_Close_NotConnected(t *testing.T) {
 	var c TcpComm
 	c.New("localhost", 5000, "ident", "pass")
-	c.conn = &clientConn
 
 	if err := c.Close(); err != nil {
 		t.Fatalf("Close failed: %v", err)
@@ -111,44 +210,54 @@ func TestTcpComm_Close_HappyPath(t *testing.T) {
 }
 
 func TestTcpComm_Listen_AuthenticatesOnAsPacket(t *testing.T) {
-	asPacket := server.AsPacket{}
-	encoded := *asPacket.ToPacket() + "\r\n"
-
 	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
 
 	var c TcpComm
 	c.New("localhost", 5000, "ident", "pass")
-	c.conn = &clientConn
 
-	// listen() panics on read error; wrap in goroutine with recover so test doesn't crash
-	go func() {
-		defer func() { _ = recover() }()
-		c.listen()
-	}()
+	auth := make(chan error, 1)
+	go func() { _ = c.listen(newLink(clientConn), auth) }()
 
-	if _, err := fmt.Fprint(serverConn, encoded); err != nil {
-		_ = serverConn.Close()
+	if _, err := fmt.Fprint(serverConn, *(&server.AsPacket{}).ToPacket()+"\r\n"); err != nil {
 		t.Fatalf("failed to write to pipe: %v", err)
 	}
 
-	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
-		if c.authenticated {
-			break
+	select {
+	case err := <-auth:
+		if err != nil {
+			t.Errorf("expected successful authentication, got %v", err)
 		}
-		time.Sleep(10 * time.Millisecond)
+	case <-time.After(2 * time.Second):
+		t.Error("authentication result not reported")
 	}
-
 	_ = serverConn.Close()
-	_ = clientConn.Close()
+}
+
+func TestTcpComm_Listen_RejectedOnArPacket(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+
+	var c TcpComm
+	c.New("localhost", 5000, "ident", "pass")
 
-	if !c.authenticated {
-		t.Error("expected authenticated=true after receiving AsPacket")
+	auth := make(chan error, 1)
+	result := make(chan error, 1)
+	go func() { result <- c.listen(newLink(clientConn), auth) }()
+
+	_, _ = fmt.Fprint(serverConn, *(&server.ArPacket{Reason: "bad password"}).ToPacket()+"\r\n")
+
+	var authErr *AuthError
+	if err := <-auth; !errors.As(err, &authErr) || authErr.Reason != "bad password" {
+		t.Errorf("expected AuthError, got %v", err)
+	}
+	if err := <-result; !errors.As(err, &authErr) {
+		t.Errorf("expected listen to stop with AuthError, got %v", err)
 	}
+	_ = serverConn.Close()
 }
 
-func TestTcpComm_Listen_CallsCallbackForOtherPackets(t *testing.T) {
-	// Use a server packet other than As/Au so the callback branch fires
+func TestTcpComm_Listen_CallsHandlerForOtherPackets(t *testing.T) {
 	aoPacket := server.AoPacket{Timestamp: time.Unix(1700000000, 0)}
 	encoded := *aoPacket.ToPacket() + "\r\n"
 
@@ -156,81 +265,203 @@ func TestTcpComm_Listen_CallsCallbackForOtherPackets(t *testing.T) {
 
 	var c TcpComm
 	c.New("localhost", 5000, "ident", "pass")
-	c.conn = &clientConn
 
 	called := make(chan struct{}, 1)
-	_ = c.SetCallback(func(*any) {
+	c.OnAck(func(*server.AoPacket) {
 		called <- struct{}{}
 	})
 
-	go func() {
-		defer func() { _ = recover() }()
-		c.listen()
-	}()
+	go func() { _ = c.listen(newLink(clientConn), make(chan error, 1)) }()
 
 	_, _ = fmt.Fprint(serverConn, encoded)
 
 	select {
 	case <-called:
 	case <-time.After(2 * time.Second):
-		_ = serverConn.Close()
-		_ = clientConn.Close()
-		t.Error("callback was not called")
-		return
+		t.Error("handler was not called")
 	}
 
 	_ = serverConn.Close()
 	_ = clientConn.Close()
 }
 
-func TestTcpComm_Connect(t *testing.T) {
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("listen failed: %v", err)
+func TestTcpComm_Listen_FragmentedFrames(t *testing.T) {
+	aoPacket := server.AoPacket{Timestamp: time.Unix(1700000000, 0)}
+	encoded := *aoPacket.ToPacket()
+
+	clientConn, serverConn := net.Pipe()
+
+	var c TcpComm
+	c.New("localhost", 5000, "ident", "pass")
+
+	called := make(chan struct{}, 1)
+	c.OnAck(func(*server.AoPacket) {
+		called <- struct{}{}
+	})
+
+	go func() { _ = c.listen(newLink(clientConn), make(chan error, 1)) }()
+
+	// Garbage first, then the frame split in two writes
+	_, _ = fmt.Fprint(serverConn, "noise\r\n"+encoded[:5])
+	_, _ = fmt.Fprint(serverConn, encoded[5:]+"\r\n")
+
+	select {
+	case <-called:
+	case <-time.After(2 * time.Second):
+		t.Error("handler was not called")
 	}
 
-	asPacket := server.AsPacket{}
-	asEncoded := *asPacket.ToPacket() + "\r\n"
+	_ = serverConn.Close()
+	_ = clientConn.Close()
+}
 
-	// Hold the server-side connection open for the lifetime of the test process
-	// so that listen()'s Read never returns an error and never panics.
-	go func() {
-		conn, err := ln.Accept()
-		if err != nil {
-			return
-		}
-		// intentionally never Close — connection stays alive while the test binary runs
-		buf := make([]byte, 4096)
-		_, _ = conn.Read(buf)
-		_, _ = fmt.Fprint(conn, asEncoded)
-		// block forever so the client-side Read in listen() never gets EOF
-		select {}
-	}()
+func TestTcpComm_Listen_ReturnsErrorOnClose(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
 
-	addr := ln.Addr().(*net.TCPAddr)
 	var c TcpComm
-	c.New("127.0.0.1", addr.Port, "ident", "pass")
+	c.New("localhost", 5000, "ident", "pass")
 
-	if err := c.Connect(); err != nil {
-		t.Fatalf("Connect failed: %v", err)
-	}
+	result := make(chan error, 1)
+	go func() { result <- c.listen(newLink(clientConn), make(chan error, 1)) }()
+	_ = serverConn.Close()
 
-	if !c.authenticated {
-		t.Error("expected authenticated=true after Connect")
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("expected an error when the connection closes")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("listen did not return")
 	}
 }
 
-func TestTcpComm_Connect_NotInitialized(t *testing.T) {
-	var c TcpComm
-	if err := c.Connect(); err == nil {
-		t.Error("expected error when not initialized")
+func TestTcpComm_Connect(t *testing.T) {
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		acceptHandshake(conn)
+		<-time.After(5 * time.Second)
+		_ = conn.Close()
+	})
+
+	c := newTestComm(port)
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if c.State() != StateConnected {
+		t.Errorf("expected StateConnected after Connect, got %s", c.State())
+	}
+	if err := c.Send(&client.PrPacket{}); err != nil {
+		t.Errorf("Send failed: %v", err)
 	}
 }
 
 func TestTcpComm_Connect_DialError(t *testing.T) {
 	var c TcpComm
 	c.New("127.0.0.1", 1, "ident", "pass")
-	if err := c.Connect(); err == nil {
+	if err := c.Connect(context.Background()); err == nil {
 		t.Error("expected dial error")
 	}
+	if c.State() != StateClosed {
+		t.Errorf("expected StateClosed, got %s", c.State())
+	}
+}
+
+func TestTcpComm_Connect_AuthRejected(t *testing.T) {
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		_, _ = fmt.Fprint(conn, *(&server.ArPacket{Reason: "authentication failed"}).ToPacket()+"\r\n")
+	})
+
+	c := newTestComm(port)
+	var authErr *AuthError
+	if err := c.Connect(context.Background()); !errors.As(err, &authErr) {
+		t.Errorf("expected AuthError, got %v", err)
+	}
+}
+
+func TestTcpComm_Connect_AuthTimeout(t *testing.T) {
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		<-time.After(time.Second)
+		_ = conn.Close()
+	})
+
+	c := newTestComm(port)
+	c.AuthTimeout = 50 * time.Millisecond
+	if err := c.Connect(context.Background()); !errors.Is(err, ErrAuthTimeout) {
+		t.Errorf("expected ErrAuthTimeout, got %v", err)
+	}
+}
+
+func TestTcpComm_ReconnectsAfterDrop(t *testing.T) {
+	handshakes := make(chan int, 4)
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		acceptHandshake(conn)
+		handshakes <- n
+		if n == 0 {
+			// The first connection drops right after the handshake
+			_ = conn.Close()
+			return
+		}
+		<-time.After(5 * time.Second)
+		_ = conn.Close()
+	})
+
+	c := newTestComm(port)
+	recorder := &stateRecorder{}
+	c.OnStateChange(recorder.record)
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	recorder.waitFor(t, StateDisconnected, 1)
+	recorder.waitFor(t, StateConnected, 2)
+
+	if got := len(handshakes); got != 2 {
+		t.Errorf("expected the handshake on every connection, got %d", got)
+	}
+	if err := c.Send(&client.PrPacket{}); err != nil {
+		t.Errorf("Send after reconnect failed: %v", err)
+	}
+}
+
+func TestTcpComm_StopsOnContextCancel(t *testing.T) {
+	port := fakeServer(t, func(conn net.Conn, n int) {
+		acceptHandshake(conn)
+		<-time.After(5 * time.Second)
+		_ = conn.Close()
+	})
+
+	c := newTestComm(port)
+	recorder := &stateRecorder{}
+	c.OnStateChange(recorder.record)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	cancel()
+	recorder.waitFor(t, StateClosed, 1)
+	if err := c.Send(&client.PrPacket{}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected after cancel, got %v", err)
+	}
+	_ = c.Close()
+}
+
+func TestBackoff(t *testing.T) {
+	minDelay, maxDelay := 100*time.Millisecond, time.Second
+	for attempt := 0; attempt < 100; attempt++ {
+		delay := backoff(attempt, minDelay, maxDelay)
+
+		want := maxDelay
+		if attempt < 4 {
+			want = minDelay << attempt
+		}
+		if delay < want/2 || delay > want {
+			t.Errorf("attempt %d: delay %s out of [%s, %s]", attempt, delay, want/2, want)
+		}
+	}
 }
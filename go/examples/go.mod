@@ -12,7 +12,6 @@ require (
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
-	github.com/iancoleman/orderedmap v0.3.0 // indirect
 	github.com/pires/go-proxyproto v0.12.0 // indirect
 	github.com/stretchr/testify v1.11.1 // indirect
 	github.com/tklauser/go-sysconf v0.3.14 // indirect
@@ -6,27 +6,11 @@ import (
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
-func handleOutput(response *any) {
-	var data *string
-	var packet = *response
-
-	switch p := packet.(type) {
-	case *server.AbPacket:
-		data = p.ToPacket()
-	case *server.AcPacket:
-		data = p.ToPacket()
-	case *server.AoPacket:
-		data = p.ToPacket()
-	case *server.ArPacket:
-		data = p.ToPacket()
-	case *server.AsPacket:
-		data = p.ToPacket()
-	case *server.AuPacket:
-		data = p.ToPacket()
-	default:
-		fmt.Printf("Unknown packet type received: %T\n", response)
+func handleOutput(response server.ServerPackets) {
+	if response == nil {
+		fmt.Println("No packet received")
 		return
 	}
 
-	fmt.Printf("Packet received: %s\n", *data)
+	fmt.Printf("Packet received: %s\n", *response.ToPacket())
 }
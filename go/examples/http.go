@@ -11,12 +11,12 @@ import (
 
 func TestHttp() {
 	fmt.Printf("Testing HTTP comm...\n\n")
-	http := clients.HttpComm{}
-	http.New(
+	http := clients.NewHttpComm(
 		clients.HTTPS,
 		"<server>",
 		"link_test",
 		"",
+		clients.WithRetryPolicy(clients.RetryPolicy{MaxAttempts: 3}),
 	)
 
 	fmt.Printf("Getting commands from the server...\n\n")
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -20,11 +19,12 @@ func main() {
 
 	s, err := servers.New(&servers.TcpConfig{
 		Port: 12345,
-		OnNewPacket: func(packet client.ClientPackets, conn net.Conn) (server.ServerPackets, error) {
+		OnAuthenticate: func(ident, passwd string, session *servers.Session) bool {
+			fmt.Printf("Pa received from %s\n", ident)
+			return true
+		},
+		OnNewPacket: func(packet client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
 			switch packet.(type) {
-			case *client.PaPacket:
-				fmt.Printf("Pa received\n")
-				return &server.AsPacket{}, nil
 			case *client.PbPacket:
 				fmt.Printf("Pb received\n")
 			case *client.PcPacket:
@@ -43,7 +43,7 @@ func main() {
 
 			return &server.AoPacket{Timestamp: time.Now()}, nil
 		},
-		OnDecodeError: func(err error, data []byte, conn net.Conn) {
+		OnDecodeError: func(err error, data []byte, session *servers.Session) {
 			fmt.Printf("Error decoding packet: %s\n", err.Error())
 		},
 	})
@@ -65,7 +65,13 @@ func main() {
 	case err := <-errChan:
 		fmt.Printf("Error starting server: %s\n", err.Error())
 	case <-ctx.Done():
-		fmt.Println("Server closed")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		result, err := s.Shutdown(shutdownCtx)
+		if err != nil {
+			fmt.Printf("Error shutting down server: %s\n", err.Error())
+		}
+		fmt.Printf("Server closed, drained %d connections, force closed %d\n", result.Drained, result.ForceClosed)
 		return
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"github.com/goldenm-software/layrz-protocol/go/v3/clients"
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 	"github.com/matishsiao/goInfo"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
@@ -19,15 +21,22 @@ import (
 
 func TestTcp() {
 	fmt.Printf("Testing Tcp comm...\n\n")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	tcp := clients.TcpComm{}
 	ident := "link_server_pruebas"
 	tcp.New("<server>", 1234, ident, "")
 	// tcp.New("127.0.0.1", 5000, ident, "")
-	err := tcp.Connect()
+	tcp.OnCommands(tcpCommands)
+	tcp.OnStateChange(func(state clients.ConnState, err error) {
+		log.Printf("Connection %s (%v)\n", state, err)
+	})
+
+	err := tcp.Connect(ctx)
 	if err != nil {
 		log.Fatalf("Failed to connect to server: %s\n", err)
 	}
-	tcp.SetCallback(tcpCallback)
 	log.Println("Conection stablished, sending Pi packet...")
 
 	err = tcp.Send(&client.PiPacket{
@@ -45,34 +54,30 @@ func TestTcp() {
 		log.Fatalf("Failed to send packet: %s\n", err)
 	}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
 
-	go func() {
-		for {
-			select {
-			case <-stop:
-				fmt.Println("Stop signal received...")
-				return
-			default:
-				pd := extractData()
-				log.Printf("Sending %s\n", *pd.ToPacket())
-				err = tcp.Send(&pd)
-				if err != nil {
-					log.Fatalf("Failed to send packet: %s\n", err)
-				}
-				time.Sleep(time.Second * 5)
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopping...")
+			tcp.Close()
+			return
+		case <-ticker.C:
+			pd := extractData()
+			log.Printf("Sending %s\n", *pd.ToPacket())
+			// While reconnecting the packet is dropped, the connection heals by itself
+			if err := tcp.Send(&pd); err != nil {
+				log.Printf("Failed to send packet: %s\n", err)
 			}
 		}
-	}()
-
-	<-stop
-	fmt.Println("Stopping...")
-	tcp.Close()
+	}
 }
 
-func tcpCallback(data *any) {
-	log.Printf("Received: %v\n", data)
+func tcpCommands(packet *server.AcPacket) {
+	for _, command := range packet.Commands {
+		log.Printf("Received command %d\n", command.CommandId)
+	}
 }
 
 func extractData() client.PdPacket {
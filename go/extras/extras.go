@@ -0,0 +1,231 @@
+package extras
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrMissingKey is returned when the key is not in the extras
+	ErrMissingKey = errors.New("missing key")
+	// ErrWrongType is returned when a value cannot be read or converted as the requested type
+	ErrWrongType = errors.New("wrong type")
+	// ErrOutOfRange is returned when a value is outside the range declared by its Field
+	ErrOutOfRange = errors.New("out of range")
+)
+
+// Extras are the extra arguments of a packet indexed by their canonical keys, like the
+// ExtraData of a <Pd> packet or the Params of a <Ps> packet.
+//
+// The accessors convert the values when they can: integers are read as floats,
+// and numeric or boolean strings are parsed, so "12" is read as the int 12
+type Extras map[string]any
+
+// Has returns true if the key is in the extras
+func (e Extras) Has(key string) bool {
+	_, ok := e[key]
+	return ok
+}
+
+// String returns the value as a string, numbers and booleans are formatted
+func (e Extras) String(key string) (string, error) {
+	return get[string](e, key, TypeString)
+}
+
+// Int returns the value as an int
+func (e Extras) Int(key string) (int, error) {
+	return get[int](e, key, TypeInt)
+}
+
+// Float returns the value as a float64
+func (e Extras) Float(key string) (float64, error) {
+	return get[float64](e, key, TypeFloat)
+}
+
+// Bool returns the value as a bool
+func (e Extras) Bool(key string) (bool, error) {
+	return get[bool](e, key, TypeBool)
+}
+
+// GPIO returns the values of the GPIO n, gpio.<n>.*
+func (e Extras) GPIO(n int) GPIO {
+	return GPIO{extras: e, prefix: "gpio." + strconv.Itoa(n) + "."}
+}
+
+// BLE returns the values of the BLE sensor i, ble.<i>.*
+func (e Extras) BLE(i int) BLE {
+	return BLE{extras: e, prefix: "ble." + strconv.Itoa(i) + "."}
+}
+
+// GPIO gives access to the values of a GPIO of the device
+type GPIO struct {
+	extras Extras
+	prefix string
+}
+
+// DigitalInput returns gpio.<n>.digital.input
+func (g GPIO) DigitalInput() (bool, error) { return g.extras.Bool(g.prefix + "digital.input") }
+
+// DigitalOutput returns gpio.<n>.digital.output
+func (g GPIO) DigitalOutput() (bool, error) { return g.extras.Bool(g.prefix + "digital.output") }
+
+// AnalogInput returns gpio.<n>.analog.input
+func (g GPIO) AnalogInput() (float64, error) { return g.extras.Float(g.prefix + "analog.input") }
+
+// AnalogOutput returns gpio.<n>.analog.output
+func (g GPIO) AnalogOutput() (float64, error) { return g.extras.Float(g.prefix + "analog.output") }
+
+// EventCount returns gpio.<n>.event.count
+func (g GPIO) EventCount() (int, error) { return g.extras.Int(g.prefix + "event.count") }
+
+// BLE gives access to the values of a BLE sensor read by the device
+type BLE struct {
+	extras Extras
+	prefix string
+}
+
+// MacAddress returns ble.<i>.mac.address
+func (b BLE) MacAddress() (string, error) { return b.extras.String(b.prefix + "mac.address") }
+
+// ModelId returns ble.<i>.model.id
+func (b BLE) ModelId() (string, error) { return b.extras.String(b.prefix + "model.id") }
+
+// TemperatureC returns ble.<i>.temperature.celsius
+func (b BLE) TemperatureC() (float64, error) {
+	return b.extras.Float(b.prefix + "temperature.celsius")
+}
+
+// TemperatureF returns ble.<i>.temperature.fahrenheit
+func (b BLE) TemperatureF() (float64, error) {
+	return b.extras.Float(b.prefix + "temperature.fahrenheit")
+}
+
+// Humidity returns ble.<i>.humidity
+func (b BLE) Humidity() (float64, error) { return b.extras.Float(b.prefix + "humidity") }
+
+// BatteryLevel returns ble.<i>.battery.level
+func (b BLE) BatteryLevel() (float64, error) { return b.extras.Float(b.prefix + "battery.level") }
+
+// Voltage returns ble.<i>.voltage
+func (b BLE) Voltage() (float64, error) { return b.extras.Float(b.prefix + "voltage") }
+
+// LightLevel returns ble.<i>.light.level.lux
+func (b BLE) LightLevel() (float64, error) { return b.extras.Float(b.prefix + "light.level.lux") }
+
+// Pressure returns ble.<i>.pressure
+func (b BLE) Pressure() (float64, error) { return b.extras.Float(b.prefix + "pressure") }
+
+// Rssi returns ble.<i>.rssi.dbm
+func (b BLE) Rssi() (int, error) { return b.extras.Int(b.prefix + "rssi.dbm") }
+
+// EventCount returns ble.<i>.event.count
+func (b BLE) EventCount() (int, error) { return b.extras.Int(b.prefix + "event.count") }
+
+// Reads a value converted to the type
+func get[T any](e Extras, key string, typ Type) (T, error) {
+	var zero T
+	value, ok := e[key]
+	if !ok {
+		return zero, fmt.Errorf("%w %s", ErrMissingKey, key)
+	}
+
+	converted, err := convert(value, typ)
+	if err != nil {
+		return zero, fmt.Errorf("%s: %w", key, err)
+	}
+	return converted.(T), nil
+}
+
+// Converts a value to the Go type of typ: string, int, float64 or bool
+func convert(value any, typ Type) (any, error) {
+	switch typ {
+	case TypeAny:
+		return value, nil
+
+	case TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+
+	case TypeInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && v >= math.MinInt && v < math.MaxInt+1.0 {
+				return int(v), nil
+			}
+		case string:
+			if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return i, nil
+			}
+		default:
+			if i, ok := toInt64(v); ok {
+				return int(i), nil
+			}
+		}
+
+	case TypeFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		default:
+			if i, ok := toInt64(v); ok {
+				return float64(i), nil
+			}
+		}
+
+	case TypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		case int:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w, %v (%T) is not %s", ErrWrongType, value, value, typ)
+}
+
+// Returns the value of the other integer types, uint64 values above the int64 range are not converted
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	return 0, false
+}
@@ -0,0 +1,97 @@
+package extras_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
+)
+
+func TestExtras_Accessors(t *testing.T) {
+	e := extras.Extras{
+		"speed":   10,
+		"ident":   "007",
+		"ratio":   "1e3",
+		"battery": 3.5,
+		"moving":  "true",
+		"count":   uint8(4),
+		"huge":    float64(1 << 63),
+	}
+
+	if i, err := e.Int("speed"); err != nil || i != 10 {
+		t.Errorf("Int = %d, %v", i, err)
+	}
+	if i, err := e.Int("ident"); err != nil || i != 7 {
+		t.Errorf("Int of a numeric string = %d, %v", i, err)
+	}
+	if i, err := e.Int("count"); err != nil || i != 4 {
+		t.Errorf("Int of an uint8 = %d, %v", i, err)
+	}
+	if s, err := e.String("ident"); err != nil || s != "007" {
+		t.Errorf("String = %q, %v", s, err)
+	}
+	if f, err := e.Float("ratio"); err != nil || f != 1000 {
+		t.Errorf("Float of 1e3 = %f, %v", f, err)
+	}
+	if f, err := e.Float("speed"); err != nil || f != 10 {
+		t.Errorf("Float of an int = %f, %v", f, err)
+	}
+	if b, err := e.Bool("moving"); err != nil || !b {
+		t.Errorf("Bool = %t, %v", b, err)
+	}
+
+	if _, err := e.Int("battery"); !errors.Is(err, extras.ErrWrongType) {
+		t.Errorf("Int of 3.5: expected ErrWrongType, got %v", err)
+	}
+	if _, err := e.Int("huge"); !errors.Is(err, extras.ErrWrongType) {
+		t.Errorf("Int of 2^63: expected ErrWrongType, got %v", err)
+	}
+	if _, err := e.Bool("speed"); !errors.Is(err, extras.ErrWrongType) {
+		t.Errorf("Bool of 10: expected ErrWrongType, got %v", err)
+	}
+	if _, err := e.Float("missing"); !errors.Is(err, extras.ErrMissingKey) {
+		t.Errorf("expected ErrMissingKey, got %v", err)
+	}
+	if !e.Has("speed") || e.Has("missing") {
+		t.Error("Has mismatch")
+	}
+}
+
+func TestExtras_GPIOAndBLE(t *testing.T) {
+	e := extras.Extras{
+		"gpio.3.digital.input":         true,
+		"gpio.3.analog.input":          12,
+		"gpio.3.event.count":           42,
+		"ble.0.temperature.celsius":    21.5,
+		"ble.0.mac.address":            "a4:c1:38:00:00:01",
+		"ble.0.rssi.dbm":               -70,
+		"ble.1.temperature.celsius":    -3.0,
+		"ble.1.temperature.fahrenheit": 26.6,
+	}
+
+	if v, err := e.GPIO(3).DigitalInput(); err != nil || !v {
+		t.Errorf("DigitalInput = %t, %v", v, err)
+	}
+	if v, err := e.GPIO(3).AnalogInput(); err != nil || v != 12 {
+		t.Errorf("AnalogInput = %f, %v", v, err)
+	}
+	if v, err := e.GPIO(3).EventCount(); err != nil || v != 42 {
+		t.Errorf("EventCount = %d, %v", v, err)
+	}
+	if _, err := e.GPIO(1).DigitalOutput(); !errors.Is(err, extras.ErrMissingKey) {
+		t.Errorf("expected ErrMissingKey, got %v", err)
+	}
+
+	if v, err := e.BLE(0).TemperatureC(); err != nil || v != 21.5 {
+		t.Errorf("TemperatureC = %f, %v", v, err)
+	}
+	if v, err := e.BLE(0).MacAddress(); err != nil || v != "a4:c1:38:00:00:01" {
+		t.Errorf("MacAddress = %q, %v", v, err)
+	}
+	if v, err := e.BLE(0).Rssi(); err != nil || v != -70 {
+		t.Errorf("Rssi = %d, %v", v, err)
+	}
+	if v, err := e.BLE(1).TemperatureF(); err != nil || v != 26.6 {
+		t.Errorf("TemperatureF = %f, %v", v, err)
+	}
+}
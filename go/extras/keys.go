@@ -0,0 +1,220 @@
+// Package extras maps the keys of the extra arguments of a packet, like the ExtraData of a
+// <Pd> packet, between the short keys sent by the devices and their canonical names
+package extras
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// KeyStyle defines the keys written by the encoders
+type KeyStyle int
+
+const (
+	// KeepKeys writes the keys as they are, without looking them up in the dictionary
+	KeepKeys KeyStyle = iota
+	// CanonicalKeys writes the canonical keys, like gpio.3.digital.input for io3.di
+	CanonicalKeys
+	// WireKeys writes the short keys sent by the devices, like io3.di for gpio.3.digital.input
+	WireKeys
+)
+
+// Placeholder of the index in the templates of a KeyRule
+const indexPlaceholder = "{n}"
+
+// KeyRule maps a wire key to its canonical key. The keys are templates that may contain
+// one {n} placeholder, which matches an index of digits, like io{n}.di and gpio.{n}.digital.input.
+// When one of the keys has the placeholder, the other one should have it too
+type KeyRule struct {
+	// Is the key sent by the devices
+	Wire string
+	// Is the canonical key
+	Canonical string
+}
+
+// DefaultRules are the rules of DefaultDictionary
+var DefaultRules = []KeyRule{
+	{Wire: "io{n}.di", Canonical: "gpio.{n}.digital.input"},
+	{Wire: "io{n}.do", Canonical: "gpio.{n}.digital.output"},
+	{Wire: "io{n}.ai", Canonical: "gpio.{n}.analog.input"},
+	{Wire: "io{n}.ao", Canonical: "gpio.{n}.analog.output"},
+	{Wire: "io{n}.counter", Canonical: "gpio.{n}.event.count"},
+	{Wire: "ble.{n}.id", Canonical: "ble.{n}.mac.address"},
+	{Wire: "ble.{n}.hum", Canonical: "ble.{n}.humidity"},
+	{Wire: "ble.{n}.tempc", Canonical: "ble.{n}.temperature.celsius"},
+	{Wire: "ble.{n}.tempf", Canonical: "ble.{n}.temperature.fahrenheit"},
+	{Wire: "ble.{n}.model_id", Canonical: "ble.{n}.model.id"},
+	{Wire: "ble.{n}.batt", Canonical: "ble.{n}.battery.level"},
+	{Wire: "ble.{n}.lux", Canonical: "ble.{n}.light.level.lux"},
+	{Wire: "ble.{n}.volt", Canonical: "ble.{n}.voltage"},
+	{Wire: "ble.{n}.press", Canonical: "ble.{n}.pressure"},
+	{Wire: "ble.{n}.counter", Canonical: "ble.{n}.event.count"},
+	{Wire: "ble.{n}.x_acc", Canonical: "ble.{n}.acceleration.x"},
+	{Wire: "ble.{n}.y_acc", Canonical: "ble.{n}.acceleration.y"},
+	{Wire: "ble.{n}.z_acc", Canonical: "ble.{n}.acceleration.z"},
+	{Wire: "ble.{n}.msg_count", Canonical: "ble.{n}.message.count"},
+	{Wire: "ble.{n}.msg", Canonical: "ble.{n}.message"},
+	{Wire: "ble.{n}.mag_counter", Canonical: "ble.{n}.magnetic.event.count"},
+	{Wire: "ble.{n}.mag_data", Canonical: "ble.{n}.magnetic.data"},
+	{Wire: "ble.{n}.rssi", Canonical: "ble.{n}.rssi.dbm"},
+	{Wire: "report", Canonical: "report.code"},
+	{Wire: "confiot_ble", Canonical: "ble.confiot.connection.status"},
+	{Wire: "confiot_serial", Canonical: "serial.confiot.connection.status"},
+}
+
+// DefaultDictionary is the dictionary used by the packets to decode and encode their keys.
+// Register rules on it to support the keys of other devices
+var DefaultDictionary = mustDictionary(DefaultRules...)
+
+// Dictionary maps keys between their wire and canonical forms in both directions.
+// Keys without a rule are kept as they are
+type Dictionary struct {
+	mu    sync.RWMutex
+	rules []keyRule
+}
+
+// A KeyRule with its templates split around the placeholder
+type keyRule struct {
+	rule      KeyRule
+	wire      template
+	canonical template
+}
+
+// A key template, prefix{n}suffix, or a fixed key when it has no placeholder
+type template struct {
+	prefix, suffix string
+	indexed        bool
+}
+
+// NewDictionary creates a dictionary with the given rules
+func NewDictionary(rules ...KeyRule) (*Dictionary, error) {
+	d := &Dictionary{}
+	for _, rule := range rules {
+		if err := d.Register(rule); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func mustDictionary(rules ...KeyRule) *Dictionary {
+	d, err := NewDictionary(rules...)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Register adds a rule to the dictionary. The rules registered last take precedence,
+// so a rule can replace the mapping of a previous one
+func (d *Dictionary) Register(rule KeyRule) error {
+	wire, err := parseTemplate(rule.Wire)
+	if err != nil {
+		return fmt.Errorf("invalid wire key %q: %w", rule.Wire, err)
+	}
+	canonical, err := parseTemplate(rule.Canonical)
+	if err != nil {
+		return fmt.Errorf("invalid canonical key %q: %w", rule.Canonical, err)
+	}
+	if wire.indexed != canonical.indexed {
+		return fmt.Errorf("keys %q and %q should both have the %s placeholder or none", rule.Wire, rule.Canonical, indexPlaceholder)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rules = append(d.rules, keyRule{rule: rule, wire: wire, canonical: canonical})
+	return nil
+}
+
+// Rules returns the rules of the dictionary in registration order
+func (d *Dictionary) Rules() []KeyRule {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rules := make([]KeyRule, 0, len(d.rules))
+	for _, rule := range d.rules {
+		rules = append(rules, rule.rule)
+	}
+	return rules
+}
+
+// Canonical returns the canonical key of a wire key, like gpio.3.digital.input for io3.di
+func (d *Dictionary) Canonical(key string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// Indexed backwards without copying the rules, it runs for every decoded argument
+	for i := len(d.rules) - 1; i >= 0; i-- {
+		if index, ok := d.rules[i].wire.match(key); ok {
+			return d.rules[i].canonical.format(index)
+		}
+	}
+	return key
+}
+
+// Wire returns the wire key of a canonical key, like io3.di for gpio.3.digital.input
+func (d *Dictionary) Wire(key string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for i := len(d.rules) - 1; i >= 0; i-- {
+		if index, ok := d.rules[i].canonical.match(key); ok {
+			return d.rules[i].wire.format(index)
+		}
+	}
+	return key
+}
+
+// Key returns the key written for the given style
+func (d *Dictionary) Key(key string, style KeyStyle) string {
+	switch style {
+	case CanonicalKeys:
+		return d.Canonical(key)
+	case WireKeys:
+		return d.Wire(key)
+	}
+	return key
+}
+
+func parseTemplate(key string) (template, error) {
+	if key == "" {
+		return template{}, errors.New("key is empty")
+	}
+	if strings.ContainsAny(key, ",;: ") {
+		return template{}, errors.New("key should not contain separators")
+	}
+
+	prefix, suffix, indexed := strings.Cut(key, indexPlaceholder)
+	if strings.Contains(suffix, indexPlaceholder) {
+		return template{}, fmt.Errorf("key should have one %s placeholder at most", indexPlaceholder)
+	}
+	return template{prefix: prefix, suffix: suffix, indexed: indexed}, nil
+}
+
+// Returns the index of the key if it matches the template
+func (t template) match(key string) (string, bool) {
+	if !t.indexed {
+		return "", key == t.prefix
+	}
+
+	if len(key) <= len(t.prefix)+len(t.suffix) || !strings.HasPrefix(key, t.prefix) || !strings.HasSuffix(key, t.suffix) {
+		return "", false
+	}
+	index := key[len(t.prefix) : len(key)-len(t.suffix)]
+	for i := 0; i < len(index); i++ {
+		if index[i] < '0' || index[i] > '9' {
+			return "", false
+		}
+	}
+	return index, true
+}
+
+// Returns the key of the template for the given index
+func (t template) format(index string) string {
+	if !t.indexed {
+		return t.prefix
+	}
+	return t.prefix + index + t.suffix
+}
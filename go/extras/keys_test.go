@@ -0,0 +1,96 @@
+package extras_test
+
+import (
+	"testing"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
+)
+
+func TestDefaultDictionary(t *testing.T) {
+	tests := []struct {
+		wire, canonical string
+	}{
+		{"io3.di", "gpio.3.digital.input"},
+		{"io12.do", "gpio.12.digital.output"},
+		{"io1.counter", "gpio.1.event.count"},
+		{"ble.0.tempc", "ble.0.temperature.celsius"},
+		{"ble.2.msg", "ble.2.message"},
+		{"ble.2.msg_count", "ble.2.message.count"},
+		{"ble.1.counter", "ble.1.event.count"},
+		{"report", "report.code"},
+		{"confiot_ble", "ble.confiot.connection.status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wire, func(t *testing.T) {
+			if got := extras.DefaultDictionary.Canonical(tt.wire); got != tt.canonical {
+				t.Errorf("Canonical(%q) = %q, want %q", tt.wire, got, tt.canonical)
+			}
+			if got := extras.DefaultDictionary.Wire(tt.canonical); got != tt.wire {
+				t.Errorf("Wire(%q) = %q, want %q", tt.canonical, got, tt.wire)
+			}
+		})
+	}
+}
+
+func TestDictionary_UnknownKeys(t *testing.T) {
+	for _, key := range []string{"speed", "ioX.di", "io.di", "ble.0.rpm", "gpio.3.digital.input.extra", "report.code.x"} {
+		if got := extras.DefaultDictionary.Canonical(key); got != key {
+			t.Errorf("Canonical(%q) = %q, want the key unchanged", key, got)
+		}
+		if got := extras.DefaultDictionary.Wire(key); got != key {
+			t.Errorf("Wire(%q) = %q, want the key unchanged", key, got)
+		}
+	}
+}
+
+func TestDictionary_Key(t *testing.T) {
+	if got := extras.DefaultDictionary.Key("io3.di", extras.CanonicalKeys); got != "gpio.3.digital.input" {
+		t.Errorf("Key canonical = %q", got)
+	}
+	if got := extras.DefaultDictionary.Key("gpio.3.digital.input", extras.WireKeys); got != "io3.di" {
+		t.Errorf("Key wire = %q", got)
+	}
+	if got := extras.DefaultDictionary.Key("io3.di", extras.KeepKeys); got != "io3.di" {
+		t.Errorf("Key keep = %q", got)
+	}
+}
+
+func TestDictionary_Register(t *testing.T) {
+	d, err := extras.NewDictionary(extras.KeyRule{Wire: "t{n}", Canonical: "sensor.{n}.temperature"})
+	if err != nil {
+		t.Fatalf("NewDictionary failed: %v", err)
+	}
+	if got := d.Canonical("t4"); got != "sensor.4.temperature" {
+		t.Errorf("Canonical = %q", got)
+	}
+
+	// The rules registered last take precedence
+	if err := d.Register(extras.KeyRule{Wire: "t{n}", Canonical: "probe.{n}.temperature"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if got := d.Canonical("t4"); got != "probe.4.temperature" {
+		t.Errorf("Canonical after override = %q", got)
+	}
+	if got := len(d.Rules()); got != 2 {
+		t.Errorf("expected 2 rules, got %d", got)
+	}
+}
+
+func TestDictionary_RegisterErrors(t *testing.T) {
+	invalid := []extras.KeyRule{
+		{Wire: "", Canonical: "a"},
+		{Wire: "a", Canonical: ""},
+		{Wire: "a:b", Canonical: "c"},
+		{Wire: "a;b", Canonical: "c"},
+		{Wire: "t{n}", Canonical: "temperature"},
+		{Wire: "t{n}.{n}", Canonical: "a.{n}.{n}"},
+	}
+
+	var d extras.Dictionary
+	for _, rule := range invalid {
+		if err := d.Register(rule); err == nil {
+			t.Errorf("Register(%+v): expected error", rule)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package extras
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// Type is the type of the value of a key
+type Type int
+
+const (
+	// TypeAny accepts any value as it is
+	TypeAny Type = iota
+	// TypeString is a string value. The packets keep these values as they were sent,
+	// so an identifier like 007 keeps its leading zeros
+	TypeString
+	// TypeInt is an int value
+	TypeInt
+	// TypeFloat is a float64 value
+	TypeFloat
+	// TypeBool is a bool value
+	TypeBool
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "a string"
+	case TypeInt:
+		return "an integer"
+	case TypeFloat:
+		return "a number"
+	case TypeBool:
+		return "a boolean"
+	}
+	return "any value"
+}
+
+// Policy defines what a Schema does with a value of another type
+type Policy int
+
+const (
+	// Coerce converts the values to the type of their Field, like the string "12" to the
+	// int 12 or the int 1 to the bool true, and rejects the values that cannot be converted
+	Coerce Policy = iota
+	// Reject rejects any value that is not already of the type of its Field
+	Reject
+)
+
+// Field declares the type, unit and range of the value of a key
+type Field struct {
+	// Is the type of the value
+	Type Type
+
+	// Is the unit of the value, like celsius or V. It is informative only
+	Unit string
+
+	// Are the inclusive limits of a numeric value, if nil the value is not limited
+	Min, Max *float64
+}
+
+// FieldError is returned for a value that does not match its Field
+type FieldError struct {
+	// Is the key of the value
+	Key string
+	// Is the value found
+	Value any
+	// Is ErrWrongType or ErrOutOfRange
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultSchema is the schema applied by the packets when decoding their extra arguments,
+// unless the decoder has its own. It has no fields, so the values are kept as decoded.
+// Register fields on it to check the values of every packet, like DefaultDictionary
+var DefaultSchema = &Schema{}
+
+// Schema declares the fields of the known keys. Keys may be templates with a {n}
+// placeholder, like gpio.{n}.analog.input. Keys without a field are not checked
+type Schema struct {
+	// Defines how the values of another type are handled, by default Coerce.
+	// It cannot be changed once the schema is in use, unlike its fields
+	Policy Policy
+
+	mu        sync.RWMutex
+	fields    map[string]Field
+	templates []schemaTemplate
+}
+
+// A field declared for a key template
+type schemaTemplate struct {
+	key   template
+	field Field
+}
+
+// NewSchema creates a schema with the given fields
+func NewSchema(policy Policy, fields map[string]Field) (*Schema, error) {
+	s := &Schema{Policy: policy}
+	// Sorted so a template declared twice always resolves the same way
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		if err := s.Register(key, fields[key]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Register declares the field of a key, replacing the previous declaration
+func (s *Schema) Register(key string, field Field) error {
+	t, err := parseTemplate(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %q: %w", key, err)
+	}
+	if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+		return fmt.Errorf("invalid range of %q, min is greater than max", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !t.indexed {
+		if s.fields == nil {
+			s.fields = make(map[string]Field)
+		}
+		s.fields[key] = field
+		return nil
+	}
+
+	s.templates = slices.DeleteFunc(s.templates, func(st schemaTemplate) bool { return st.key == t })
+	s.templates = append(s.templates, schemaTemplate{key: t, field: field})
+	return nil
+}
+
+// Field returns the field declared for the key
+func (s *Schema) Field(key string) (Field, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if field, ok := s.fields[key]; ok {
+		return field, true
+	}
+	for _, st := range slices.Backward(s.templates) {
+		if _, ok := st.key.match(key); ok {
+			return st.field, true
+		}
+	}
+	return Field{}, false
+}
+
+// Apply checks the values against their fields and returns the extras with the values
+// converted by the policy, the given extras are not modified.
+// Returns the joined FieldError of every value rejected
+func (s *Schema) Apply(e Extras) (Extras, error) {
+	if s.empty() {
+		return e, nil
+	}
+
+	var errs []error
+	var result Extras
+
+	for _, key := range slices.Sorted(maps.Keys(e)) {
+		value := e[key]
+		field, ok := s.Field(key)
+		if !ok {
+			continue
+		}
+
+		converted, err := s.check(value, field)
+		if err != nil {
+			errs = append(errs, &FieldError{Key: key, Value: value, Err: err})
+			continue
+		}
+		if field.Type != TypeAny && converted != value {
+			if result == nil {
+				result = maps.Clone(e)
+			}
+			result[key] = converted
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if result == nil {
+		return e, nil
+	}
+	return result, nil
+}
+
+// Returns true if the schema has no fields, it is checked on every decode
+func (s *Schema) empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.fields) == 0 && len(s.templates) == 0
+}
+
+// Returns the value converted to the type of the field, within its range
+func (s *Schema) check(value any, field Field) (any, error) {
+	converted, err := convert(value, field.Type)
+	if err != nil {
+		return nil, ErrWrongType
+	}
+	if s.Policy == Reject && reflect.TypeOf(value) != reflect.TypeOf(converted) {
+		return nil, ErrWrongType
+	}
+
+	var number float64
+	switch v := converted.(type) {
+	case int:
+		number = float64(v)
+	case float64:
+		number = v
+	default:
+		return converted, nil
+	}
+	if (field.Min != nil && number < *field.Min) || (field.Max != nil && number > *field.Max) {
+		return nil, ErrOutOfRange
+	}
+	return converted, nil
+}
@@ -0,0 +1,107 @@
+package extras_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
+)
+
+func limit(v float64) *float64 { return &v }
+
+func testSchema(t *testing.T, policy extras.Policy) *extras.Schema {
+	t.Helper()
+	schema, err := extras.NewSchema(policy, map[string]extras.Field{
+		"ident":                       {Type: extras.TypeString},
+		"speed":                       {Type: extras.TypeFloat, Unit: "km/h", Min: limit(0), Max: limit(300)},
+		"gpio.{n}.digital.input":      {Type: extras.TypeBool},
+		"ble.{n}.temperature.celsius": {Type: extras.TypeFloat, Unit: "celsius", Min: limit(-40), Max: limit(85)},
+	})
+	if err != nil {
+		t.Fatalf("NewSchema failed: %v", err)
+	}
+	return schema
+}
+
+func TestSchema_Field(t *testing.T) {
+	schema := testSchema(t, extras.Coerce)
+
+	field, ok := schema.Field("ble.2.temperature.celsius")
+	if !ok || field.Unit != "celsius" || field.Type != extras.TypeFloat {
+		t.Errorf("Field = %+v, %t", field, ok)
+	}
+	if _, ok := schema.Field("ble.x.temperature.celsius"); ok {
+		t.Error("expected no field for an invalid index")
+	}
+}
+
+func TestSchema_Coerce(t *testing.T) {
+	schema := testSchema(t, extras.Coerce)
+
+	input := extras.Extras{
+		"ident":                     7,
+		"speed":                     "1e2",
+		"gpio.1.digital.input":      "false",
+		"ble.0.temperature.celsius": 21,
+		"other":                     "untouched",
+	}
+	result, err := schema.Apply(input)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	want := extras.Extras{
+		"ident":                     "7",
+		"speed":                     100.0,
+		"gpio.1.digital.input":      false,
+		"ble.0.temperature.celsius": 21.0,
+		"other":                     "untouched",
+	}
+	for key, value := range want {
+		if result[key] != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", key, result[key], result[key], value, value)
+		}
+	}
+	if input["speed"] != "1e2" {
+		t.Error("expected the input to be left untouched")
+	}
+}
+
+func TestSchema_Errors(t *testing.T) {
+	schema := testSchema(t, extras.Coerce)
+
+	_, err := schema.Apply(extras.Extras{
+		"speed":                     "fast",
+		"ble.0.temperature.celsius": 120.0,
+		"gpio.1.digital.input":      true,
+	})
+	if !errors.Is(err, extras.ErrWrongType) || !errors.Is(err, extras.ErrOutOfRange) {
+		t.Fatalf("expected ErrWrongType and ErrOutOfRange, got %v", err)
+	}
+
+	var fieldErr *extras.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "ble.0.temperature.celsius" {
+		t.Errorf("expected the FieldError of the first key in order, got %v", fieldErr)
+	}
+}
+
+func TestSchema_Reject(t *testing.T) {
+	schema := testSchema(t, extras.Reject)
+
+	if _, err := schema.Apply(extras.Extras{"speed": 10.0, "ident": "007"}); err != nil {
+		t.Errorf("expected values of the declared type to pass, got %v", err)
+	}
+	if _, err := schema.Apply(extras.Extras{"speed": 10}); !errors.Is(err, extras.ErrWrongType) {
+		t.Errorf("expected an int speed to be rejected, got %v", err)
+	}
+}
+
+func TestSchema_RegisterErrors(t *testing.T) {
+	var schema extras.Schema
+	if err := schema.Register("a:b", extras.Field{}); err == nil {
+		t.Error("expected error for an invalid key")
+	}
+	if err := schema.Register("speed", extras.Field{Min: limit(10), Max: limit(1)}); err == nil {
+		t.Error("expected error for an invalid range")
+	}
+}
@@ -2,6 +2,4 @@ module github.com/goldenm-software/layrz-protocol/go/v3
 
 go 1.26.2
 
-require github.com/iancoleman/orderedmap v0.3.0
-
 require github.com/pires/go-proxyproto v0.12.0
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+)
+
+// Packet is implemented by every packet that can be decoded by its tag
+type Packet interface {
+	// Tag returns the two letters of the packet tag, like "Pa" for <Pa>
+	Tag() string
+	ToPacket() *string
+	FromPacket(raw *string) error
+}
+
+var (
+	mu           sync.RWMutex
+	constructors = make(map[string]func() Packet)
+)
+
+// Register maps the tag to the constructor of its packet, the tag must be an uppercase
+// letter followed by a lowercase letter and cannot be registered twice
+func Register(tag string, constructor func() Packet) error {
+	if !validTag(tag) {
+		return fmt.Errorf("invalid tag %q, should be an uppercase letter followed by a lowercase letter", tag)
+	}
+	if constructor == nil {
+		return fmt.Errorf("constructor of tag %s is nil", tag)
+	}
+	if got := constructor().Tag(); got != tag {
+		return fmt.Errorf("constructor of tag %s returns a packet with tag %s", tag, got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := constructors[tag]; ok {
+		return fmt.Errorf("tag %s is already registered", tag)
+	}
+	constructors[tag] = constructor
+	return nil
+}
+
+// MustRegister is like Register but panics on error, used by the built-in packets
+func MustRegister(tag string, constructor func() Packet) {
+	if err := Register(tag, constructor); err != nil {
+		panic(err)
+	}
+}
+
+// Decode builds the packet registered for the tag of the frame and decodes the frame into it.
+// If accept is not nil, packets it rejects are reported as invalid packets without being decoded
+func Decode(data []byte, accept func(Packet) bool) (Packet, error) {
+	return DecodeWith(data, accept, nil)
+}
+
+// DecodeWith is like Decode with the mode of the decoder. Packets that do not implement
+// FromFrame, like most custom packets, are decoded in strict mode
+func DecodeWith(data []byte, accept func(Packet) bool, d *wire.Decoder) (Packet, error) {
+	n := len(data)
+	if n < 9 || data[0] != '<' || data[3] != '>' || !validTag(string(data[1:3])) ||
+		data[n-5] != '<' || data[n-4] != '/' || data[n-3] != data[1] || data[n-2] != data[2] || data[n-1] != '>' {
+		return nil, invalidPacket("", data, "should be <Xx>...</Xx>")
+	}
+
+	mu.RLock()
+	constructor, ok := constructors[string(data[1:3])]
+	mu.RUnlock()
+	if !ok {
+		return nil, &wire.DecodeError{Tag: string(data[1:3]), Field: -1, Value: string(data), Offset: -1,
+			Err: wire.ErrInvalidPacket, Cause: wire.ErrUnknownTag}
+	}
+
+	packet := constructor()
+	if accept != nil && !accept(packet) {
+		return nil, invalidPacket(packet.Tag(), data, "not accepted by this family")
+	}
+
+	if framer, ok := packet.(interface {
+		FromFrame(data []byte, d *wire.Decoder) error
+	}); ok {
+		if err := framer.FromFrame(data, d); err != nil {
+			return nil, err
+		}
+		return packet, nil
+	}
+
+	// Custom packets get a copy, FromPacket may modify it
+	raw := string(data)
+	if err := packet.FromPacket(&raw); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// Returns the DecodeError of a frame that cannot be decoded by its tag
+func invalidPacket(tag string, data []byte, reason string) error {
+	return &wire.DecodeError{Tag: tag, Field: -1, Value: string(data), Offset: -1, Err: wire.ErrInvalidPacket,
+		Cause: errors.New(reason)}
+}
+
+func validTag(tag string) bool {
+	return len(tag) == 2 &&
+		tag[0] >= 'A' && tag[0] <= 'Z' &&
+		tag[1] >= 'a' && tag[1] <= 'z'
+}
@@ -1,141 +1,109 @@
 package wire
 
 import (
-	"regexp"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
 
-	"github.com/iancoleman/orderedmap"
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
 )
 
 // ParseArgs parses raw arguments and returns a map of string to any
 func ParseArgs(rawArgs string) map[string]any {
-	args := orderedmap.New()
+	return parseArgs(rawArgs, nil)
+}
+
+// DecodeArgs parses raw arguments and checks them against the schema of the decoder, or
+// extras.DefaultSchema. The values of the TypeString fields are kept as they were sent,
+// so an identifier like 007 is not read as the number 7
+func DecodeArgs(rawArgs string, d *Decoder) (map[string]any, error) {
+	schema := d.schema()
+	return schema.Apply(parseArgs(rawArgs, schema))
+}
 
+// Parses the arguments, the values of the TypeString fields of the schema are not converted
+func parseArgs(rawArgs string, schema *extras.Schema) map[string]any {
 	if rawArgs == "" {
 		return nil
 	}
 
-	parts := strings.Split(rawArgs, ",")
-	for _, part := range parts {
-		subparts := strings.Split(part, ":")
-		if len(subparts) < 2 {
+	args := make(map[string]any, strings.Count(rawArgs, ",")+1)
+	for rest := rawArgs; rest != ""; {
+		var part string
+		part, rest, _ = strings.Cut(rest, ",")
+
+		rawKey, rawValue, ok := strings.Cut(part, ":")
+		if !ok {
 			continue
 		}
 
 		// Keys and values may contain a colon (e.g. a MAC-like identifier); the serializer escapes it
-		// as `___` so the `key:value` split stays unambiguous. Reverse the key before pattern matching.
-		key := strings.ReplaceAll(subparts[0], "___", ":")
-
-		patterns := map[string]*regexp.Regexp{
-			"digitalInput":    regexp.MustCompile(`^io[0-9]+\.di$`),
-			"digitalOutput":   regexp.MustCompile(`^io[0-9]+\.do$`),
-			"analogInput":     regexp.MustCompile(`^io[0-9]+\.ai$`),
-			"analogOutput":    regexp.MustCompile(`^io[0-9]+\.ao$`),
-			"counter":         regexp.MustCompile(`^io[0-9]+\.counter$`),
-			"bleId":           regexp.MustCompile(`^ble\.[0-9]+\.id$`),
-			"bleHumidity":     regexp.MustCompile(`^ble\.[0-9]+\.hum$`),
-			"bleTempC":        regexp.MustCompile(`^ble\.[0-9]+\.tempc$`),
-			"bleTempF":        regexp.MustCompile(`^ble\.[0-9]+\.tempf$`),
-			"bleModelId":      regexp.MustCompile(`^ble\.[0-9]+\.model_id$`),
-			"bleBatteryLevel": regexp.MustCompile(`^ble\.[0-9]+\.batt$`),
-			"bleLuxLevel":     regexp.MustCompile(`^ble\.[0-9]+\.lux$`),
-			"bleVoltageLevel": regexp.MustCompile(`^ble\.[0-9]+\.volt$`),
-			"bleRpm":          regexp.MustCompile(`^ble\.[0-9]+\.rpm$`),
-			"blePressure":     regexp.MustCompile(`^ble\.[0-9]+\.press$`),
-			"bleEventCount":   regexp.MustCompile(`^ble\.[0-9]+\.counter$`),
-			"bleXAccel":       regexp.MustCompile(`^ble\.[0-9]+\.x_acc$`),
-			"bleYAccel":       regexp.MustCompile(`^ble\.[0-9]+\.y_acc$`),
-			"bleZAccel":       regexp.MustCompile(`^ble\.[0-9]+\.z_acc$`),
-			"bleMsgCount":     regexp.MustCompile(`^ble\.[0-9]+\.msg_count$`),
-			"bleMsg":          regexp.MustCompile(`^ble\.[0-9]+\.msg$`),
-			"bleMagCount":     regexp.MustCompile(`^ble\.[0-9]+\.mag_counter$`),
-			"bleMagData":      regexp.MustCompile(`^ble\.[0-9]+\.mag_data$`),
-			"bleRssi":         regexp.MustCompile(`^ble\.[0-9]+\.rssi$`),
-		}
-
-		switch {
-		case patterns["digitalInput"].MatchString(key):
-			key = "gpio." + ExtractGpio(key, ".di") + ".digital.input"
-		case patterns["digitalOutput"].MatchString(key):
-			key = "gpio." + ExtractGpio(key, ".do") + ".digital.output"
-		case patterns["analogInput"].MatchString(key):
-			key = "gpio." + ExtractGpio(key, ".ai") + ".analog.input"
-		case patterns["analogOutput"].MatchString(key):
-			key = "gpio." + ExtractGpio(key, ".ao") + ".analog.output"
-		case patterns["counter"].MatchString(key):
-			key = "gpio." + ExtractGpio(key, ".counter") + ".event.count"
-		case patterns["bleId"].MatchString(key):
-			key = strings.Replace(key, ".id", ".mac.address", 1)
-		case patterns["bleHumidity"].MatchString(key):
-			key = strings.Replace(key, ".hum", ".humidity", 1)
-		case patterns["bleTempC"].MatchString(key):
-			key = strings.Replace(key, ".tempc", ".temperature.celsius", 1)
-		case patterns["bleTempF"].MatchString(key):
-			key = strings.Replace(key, ".tempf", ".temperature.fahrenheit", 1)
-		case patterns["bleModelId"].MatchString(key):
-			key = strings.Replace(key, ".model_id", ".model.id", 1)
-		case patterns["bleBatteryLevel"].MatchString(key):
-			key = strings.Replace(key, ".batt", ".battery.level", 1)
-		case patterns["bleLuxLevel"].MatchString(key):
-			key = strings.Replace(key, ".lux", ".light.level.lux", 1)
-		case patterns["bleVoltageLevel"].MatchString(key):
-			key = strings.Replace(key, ".volt", ".voltage", 1)
-		case patterns["bleRpm"].MatchString(key):
-			// rpm stays as-is
-		case patterns["blePressure"].MatchString(key):
-			key = strings.Replace(key, ".press", ".pressure", 1)
-		case patterns["bleEventCount"].MatchString(key):
-			key = strings.Replace(key, ".counter", ".event.count", 1)
-		case patterns["bleXAccel"].MatchString(key):
-			key = strings.Replace(key, ".x_acc", ".acceleration.x", 1)
-		case patterns["bleYAccel"].MatchString(key):
-			key = strings.Replace(key, ".y_acc", ".acceleration.y", 1)
-		case patterns["bleZAccel"].MatchString(key):
-			key = strings.Replace(key, ".z_acc", ".acceleration.z", 1)
-		case patterns["bleMsgCount"].MatchString(key):
-			key = strings.Replace(key, ".msg_count", ".message.count", 1)
-		case patterns["bleMsg"].MatchString(key):
-			key = strings.Replace(key, ".msg", ".message", 1)
-		case patterns["bleMagCount"].MatchString(key):
-			key = strings.Replace(key, ".mag_counter", ".magnetic.event.count", 1)
-		case patterns["bleMagData"].MatchString(key):
-			key = strings.Replace(key, ".mag_data", ".magnetic.data", 1)
-		case patterns["bleRssi"].MatchString(key):
-			key = strings.Replace(key, ".rssi", ".rssi.dbm", 1)
-		case key == "report":
-			key = "report.code"
-		case key == "confiot_ble":
-			key = "ble.confiot.connection.status"
-		case key == "confiot_serial":
-			key = "serial.confiot.connection.status"
-		}
+		// as `___` so the `key:value` split stays unambiguous. Reverse the key before mapping it.
+		key := extras.DefaultDictionary.Canonical(strings.ReplaceAll(rawKey, "___", ":"))
 
 		// Values may contain a colon (e.g. a MAC-like identifier); the serializer escapes it as `___`
 		// so the `key:value` split stays unambiguous. Reverse that before any type coercion.
-		value := strings.ReplaceAll(strings.Join(subparts[1:], ":"), "___", ":")
+		value := strings.ReplaceAll(rawValue, "___", ":")
 
-		intRegexp := regexp.MustCompile(`^-?\d+$`)
-		floatRegexp := regexp.MustCompile(`^-?\d+\.\d+$`)
+		if schema != nil {
+			if field, ok := schema.Field(key); ok && field.Type == extras.TypeString {
+				args[key] = value
+				continue
+			}
+		}
 
-		if intRegexp.MatchString(value) {
-			intVal, err := strconv.Atoi(value)
-			if err == nil {
-				args.Set(key, intVal)
+		switch {
+		case isInteger(value):
+			// Integers out of the int range are dropped
+			if intVal, err := strconv.Atoi(value); err == nil {
+				args[key] = intVal
 			}
-		} else if floatRegexp.MatchString(value) {
-			floatVal, err := strconv.ParseFloat(value, 64)
-			if err == nil {
-				args.Set(key, floatVal)
+		case isDecimal(value):
+			if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+				args[key] = floatVal
 			}
-		} else if (value == "true") || (value == "false") {
-			args.Set(key, value == "true")
-		} else {
-			args.Set(key, value)
+		case value == "true" || value == "false":
+			args[key] = value == "true"
+		default:
+			args[key] = value
 		}
 	}
 
-	return args.Values()
+	return args
+}
+
+// Returns true for an optional minus sign followed by digits, like -12
+func isInteger(value string) bool {
+	value = strings.TrimPrefix(value, "-")
+	return value != "" && countDigits(value) == len(value)
+}
+
+// Returns true for an optional minus sign followed by digits, a dot and digits, like -1.5
+func isDecimal(value string) bool {
+	value = strings.TrimPrefix(value, "-")
+	whole := countDigits(value)
+	if whole == 0 || whole == len(value) || value[whole] != '.' {
+		return false
+	}
+	fraction := value[whole+1:]
+	return fraction != "" && countDigits(fraction) == len(fraction)
+}
+
+// Returns the number of leading ASCII digits of the value
+func countDigits(value string) int {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return i
+		}
+	}
+	return len(value)
+}
+
+// SortedKeys returns the keys of the arguments in lexical order. Encoders write the
+// arguments in this order, so the same packet always encodes to the same frame and CRC
+func SortedKeys(args map[string]any) []string {
+	return slices.Sorted(maps.Keys(args))
 }
 
 // ExtractGpio extracts GPIO number from input string
@@ -3,6 +3,8 @@ package wire
 import (
 	"fmt"
 	"testing"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
 )
 
 func TestParseArgs(t *testing.T) {
@@ -454,3 +456,91 @@ func TestParseArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestSortedKeys(t *testing.T) {
+	keys := SortedKeys(map[string]any{"speed": 1, "alarm.event": true, "gpio.1.digital.input": false})
+	want := []string{"alarm.event", "gpio.1.digital.input", "speed"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("SortedKeys = %v, want %v", keys, want)
+	}
+
+	if keys := SortedKeys(nil); len(keys) != 0 {
+		t.Errorf("SortedKeys(nil) = %v, want no keys", keys)
+	}
+}
+
+func TestParseArgs_LeadingZeros(t *testing.T) {
+	args := ParseArgs("ident:007,zero:0,ratio:0.5,code:-01,padded:00.5")
+	want := map[string]any{"ident": 7, "zero": 0, "ratio": 0.5, "code": -1, "padded": 0.5}
+	for key, value := range want {
+		if args[key] != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", key, args[key], args[key], value, value)
+		}
+	}
+}
+
+func TestDecodeArgs_Schema(t *testing.T) {
+	schema, err := extras.NewSchema(extras.Coerce, map[string]extras.Field{
+		"ident":                 {Type: extras.TypeString},
+		"gpio.{n}.analog.input": {Type: extras.TypeFloat},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := DecodeArgs("ident:007,io1.ai:3,code:01", &Decoder{Schema: schema})
+	if err != nil {
+		t.Fatalf("DecodeArgs failed: %v", err)
+	}
+	want := map[string]any{"ident": "007", "gpio.1.analog.input": 3.0, "code": 1}
+	for key, value := range want {
+		if args[key] != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", key, args[key], args[key], value, value)
+		}
+	}
+
+	// Without a schema of its own, the decoder uses the empty DefaultSchema
+	args, err = DecodeArgs("ident:007", nil)
+	if err != nil || args["ident"] != 7 {
+		t.Errorf("DecodeArgs without schema = %v, %v", args, err)
+	}
+}
+
+func TestParseArgs_Numbers(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected any
+	}{
+		{"12", 12},
+		{"-12", -12},
+		{"0", 0},
+		{"1.5", 1.5},
+		{"-0.25", -0.25},
+		{"1.", "1."},
+		{".5", ".5"},
+		{"-", "-"},
+		{"+1", "+1"},
+		{"1e5", "1e5"},
+		{"1.2.3", "1.2.3"},
+		{"--1", "--1"},
+		{"12a", "12a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			args := ParseArgs("value:" + tt.value)
+			if args["value"] != tt.expected {
+				t.Errorf("expected %v (%T), got %v (%T)", tt.expected, tt.expected, args["value"], args["value"])
+			}
+		})
+	}
+}
+
+func BenchmarkParseArgs(b *testing.B) {
+	raw := "io1.di:true,io2.di:false,io1.ai:12.5,io1.ec:42,ble.0.id:AA___BB___CC___DD___EE___FF," +
+		"ble.0.tc:23.4,ble.0.hum:55,report.code:1,power.voltage:12.8,ident:007"
+	b.ReportAllocs()
+	for b.Loop() {
+		ParseArgs(raw)
+	}
+}
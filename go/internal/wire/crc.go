@@ -36,11 +36,11 @@ var crcTab = []uint16{
 	0x7BC7, 0x6A4E, 0x58D5, 0x495C, 0x3DE3, 0x2C6A, 0x1EF1, 0x0F78,
 }
 
-// Calculate returns the CRC checksum for the given data
-func Calculate(data []byte) uint16 {
+// Calculate returns the CRC checksum for the given data, a string is not copied
+func Calculate[T ~string | ~[]byte](data T) uint16 {
 	fcs := uint16(0xffff)
-	for _, b := range data {
-		index := (fcs ^ uint16(b)) & 0xff
+	for i := 0; i < len(data); i++ {
+		index := (fcs ^ uint16(data[i])) & 0xff
 		fcs = (fcs >> 8) ^ crcTab[index]
 	}
 	return fcs ^ 0xffff
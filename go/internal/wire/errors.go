@@ -0,0 +1,85 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidCrc is wrapped by the errors of frames whose CRC does not match the content
+	ErrInvalidCrc = errors.New("invalid CRC")
+
+	// ErrInvalidPacket is wrapped by the errors of frames with an unknown tag,
+	// or that are not enclosed by the tags of the packet
+	ErrInvalidPacket = errors.New("invalid packet")
+
+	// ErrUnknownTag is the cause of the errors of frames whose tag is not registered,
+	// these errors also wrap ErrInvalidPacket
+	ErrUnknownTag = errors.New("unknown tag")
+
+	// ErrFieldCount is wrapped by the errors of frames with missing or extra fields
+	ErrFieldCount = errors.New("wrong number of fields")
+
+	// ErrInvalidField is wrapped by the errors of fields whose value cannot be parsed
+	ErrInvalidField = errors.New("invalid field")
+)
+
+// DecodeError describes why a frame could not be decoded. It wraps one of the sentinel
+// errors, like ErrInvalidCrc, and the error that caused it, if any
+type DecodeError struct {
+	// Is the tag of the packet, like "Pd"
+	Tag string
+
+	// Is the index of the field in the frame, -1 when the error is not about a field
+	Field int
+
+	// Is the name of the field, like "latitude" or "crc"
+	Name string
+
+	// Is the raw value of the field
+	Value string
+
+	// Is the byte offset of the value in the frame, -1 when unknown
+	Offset int
+
+	// Is the sentinel error, like ErrInvalidCrc or ErrInvalidField
+	Err error
+
+	// Is the error that caused it, like the error of strconv, or nil
+	Cause error
+}
+
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	if e.Tag != "" {
+		b.WriteString("<" + e.Tag + ">")
+	} else {
+		b.WriteString("frame")
+	}
+	if e.Name != "" {
+		b.WriteString(" " + e.Name)
+	}
+	if e.Field >= 0 {
+		fmt.Fprintf(&b, " (field %d)", e.Field)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&b, " at offset %d", e.Offset)
+	}
+	b.WriteString(": " + e.Err.Error())
+	if e.Value != "" {
+		fmt.Fprintf(&b, " %q", e.Value)
+	}
+	if e.Cause != nil {
+		b.WriteString(": " + e.Cause.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the sentinel error and the cause
+func (e *DecodeError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
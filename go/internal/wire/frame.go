@@ -0,0 +1,271 @@
+package wire
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
+)
+
+// Decoder holds the mode of a decode. A nil Decoder decodes in strict mode
+type Decoder struct {
+	// Accepts frames with a bad CRC or extra trailing fields, each problem is
+	// added to Warnings instead of failing the decode
+	Lenient bool
+
+	// Are the problems accepted in lenient mode
+	Warnings []*DecodeError
+
+	// Checks the extra arguments of the packets, like the ExtraData of a <Pd> packet.
+	// If nil, extras.DefaultSchema is used
+	Schema *extras.Schema
+}
+
+// Returns the schema of the extra arguments
+func (d *Decoder) schema() *extras.Schema {
+	if d != nil && d.Schema != nil {
+		return d.Schema
+	}
+	return extras.DefaultSchema
+}
+
+// Returns the error in strict mode, in lenient mode it is recorded as a warning
+func (d *Decoder) tolerate(err *DecodeError) error {
+	if d == nil || !d.Lenient {
+		return err
+	}
+	d.Warnings = append(d.Warnings, err)
+	return nil
+}
+
+// Frame is a raw frame being decoded, <Xx>...</Xx>
+type Frame struct {
+	raw     string
+	tag     string
+	decoder *Decoder
+}
+
+// OpenFrame checks that raw is enclosed by the tags of the packet, tag has two letters.
+// The fields are substrings of raw
+func OpenFrame(raw, tag string, d *Decoder) (*Frame, error) {
+	n := len(raw)
+	if n < 9 || raw[0] != '<' || raw[1:3] != tag || raw[3] != '>' ||
+		raw[n-5:n-3] != "</" || raw[n-3:n-1] != tag || raw[n-1] != '>' {
+		return nil, &DecodeError{Tag: tag, Field: -1, Offset: -1, Err: ErrInvalidPacket,
+			Cause: fmt.Errorf("should be <%s>...</%s>", tag, tag)}
+	}
+	return &Frame{raw: raw, tag: tag, decoder: d}, nil
+}
+
+// Body returns the content between the tags and its offset in the frame
+func (f *Frame) Body() (string, int) {
+	return f.raw[4 : len(f.raw)-5], 4
+}
+
+// Checksum verifies the CRC after the last separator of the body, computed over the content
+// before it. Returns the content without its trailing separator and the offset of the content
+func (f *Frame) Checksum() (string, int, error) {
+	body, offset := f.Body()
+	end := strings.LastIndexByte(body, ';')
+	if end < 0 {
+		return "", 0, &DecodeError{Tag: f.tag, Field: -1, Name: "crc", Value: body, Offset: offset,
+			Err: ErrFieldCount, Cause: fmt.Errorf("missing CRC")}
+	}
+
+	if err := f.verify(body[:end+1], body[end+1:], -1, offset+end+1); err != nil {
+		return "", 0, err
+	}
+	return body[:end], offset, nil
+}
+
+// Verifies the CRC of content, rawCrc is at the given field index and offset
+func (f *Frame) verify(content, rawCrc string, field, offset int) error {
+	received, err := strconv.ParseUint(rawCrc, 16, 16)
+	if err != nil {
+		return f.decoder.tolerate(&DecodeError{Tag: f.tag, Field: field, Name: "crc", Value: rawCrc, Offset: offset,
+			Err: ErrInvalidCrc, Cause: err})
+	}
+
+	if calculated := Calculate(content); calculated != uint16(received) {
+		return f.decoder.tolerate(&DecodeError{Tag: f.tag, Field: field, Name: "crc", Value: rawCrc, Offset: offset,
+			Err: ErrInvalidCrc, Cause: fmt.Errorf("received: %04X, calculated: %04X", received, calculated)})
+	}
+	return nil
+}
+
+// Fields splits the content into its fields, one per name. Missing fields are an error,
+// extra trailing fields are accepted in lenient mode
+func (f *Frame) Fields(content string, offset int, names ...string) (*Fields, error) {
+	if content == "" && len(names) == 0 {
+		return &Fields{frame: f}, nil
+	}
+
+	fields := f.split(content, offset, names)
+	if len(fields.values) == len(names) {
+		return fields, nil
+	}
+
+	err := &DecodeError{Tag: f.tag, Field: -1, Offset: -1, Err: ErrFieldCount,
+		Cause: fmt.Errorf("should contain %d fields, got %d", len(names), len(fields.values))}
+	if len(fields.values) < len(names) {
+		return nil, err
+	}
+	if err := f.decoder.tolerate(err); err != nil {
+		return nil, err
+	}
+
+	fields.values = fields.values[:len(names)]
+	return fields, nil
+}
+
+// Groups splits the content into groups of fields, like the commands of an <Ac> packet.
+// The content should have a multiple of len(names) fields, the last field of every group
+// is its CRC, which is verified
+func (f *Frame) Groups(content string, offset int, names ...string) ([]*Fields, error) {
+	fields := f.split(content, offset, names)
+	if len(fields.values)%len(names) != 0 {
+		return nil, &DecodeError{Tag: f.tag, Field: -1, Offset: -1, Err: ErrFieldCount,
+			Cause: fmt.Errorf("should contain a multiple of %d fields, got %d", len(names), len(fields.values))}
+	}
+
+	groups := make([]*Fields, 0, len(fields.values)/len(names))
+	for start := 0; start < len(fields.values); start += len(names) {
+		group := &Fields{
+			frame:  f,
+			names:  names,
+			first:  start,
+			values: fields.values[start : start+len(names)],
+			offset: offset,
+		}
+
+		last := len(names) - 1
+		crcOffset := group.Offset(last)
+		if err := f.verify(f.raw[offset:crcOffset], group.values[last], start+last, crcOffset); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+		offset = crcOffset + len(group.values[last]) + 1
+	}
+	return groups, nil
+}
+
+// List splits the content into a variable number of fields with the same name
+func (f *Frame) List(content string, offset int, name string) *Fields {
+	return f.split(content, offset, []string{name})
+}
+
+// Splits the content by the separator
+func (f *Frame) split(content string, offset int, names []string) *Fields {
+	return &Fields{frame: f, names: names, values: strings.Split(content, ";"), offset: offset}
+}
+
+// Fields are the fields of a frame, or of a group of fields, with their names
+type Fields struct {
+	frame *Frame
+	// Names of the fields, repeated when there are more fields than names
+	names  []string
+	values []string
+	// Offset of the first field in the frame
+	offset int
+	// Index of the first field in the frame
+	first int
+}
+
+// Len returns the number of fields
+func (f *Fields) Len() int {
+	return len(f.values)
+}
+
+// Offset returns the byte offset of the field in the frame. It is computed on demand,
+// since it is only needed to report errors
+func (f *Fields) Offset(i int) int {
+	offset := f.offset
+	for _, value := range f.values[:i] {
+		offset += len(value) + 1
+	}
+	return offset
+}
+
+// String returns the raw value of the field
+func (f *Fields) String(i int) string {
+	return f.values[i]
+}
+
+// Error returns the DecodeError of a field whose value cannot be parsed
+func (f *Fields) Error(i int, cause error) *DecodeError {
+	name := ""
+	if len(f.names) > 0 {
+		name = f.names[i%len(f.names)]
+	}
+	return &DecodeError{
+		Tag:    f.frame.tag,
+		Field:  f.first + i,
+		Name:   name,
+		Value:  f.values[i],
+		Offset: f.Offset(i),
+		Err:    ErrInvalidField,
+		Cause:  cause,
+	}
+}
+
+// Int parses the field as an int
+func (f *Fields) Int(i int) (int, error) {
+	v, err := strconv.Atoi(f.values[i])
+	if err != nil {
+		return 0, f.Error(i, err)
+	}
+	return v, nil
+}
+
+// Float parses the field as a float64
+func (f *Fields) Float(i int) (float64, error) {
+	v, err := strconv.ParseFloat(f.values[i], 64)
+	if err != nil {
+		return 0, f.Error(i, err)
+	}
+	return v, nil
+}
+
+// OptionalInt parses the field as an int, an empty field is nil
+func (f *Fields) OptionalInt(i int) (*int, error) {
+	if f.values[i] == "" {
+		return nil, nil
+	}
+	v, err := f.Int(i)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// OptionalFloat parses the field as a float64, an empty field is nil
+func (f *Fields) OptionalFloat(i int) (*float64, error) {
+	if f.values[i] == "" {
+		return nil, nil
+	}
+	v, err := f.Float(i)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Unix parses the field as a Unix timestamp in seconds
+func (f *Fields) Unix(i int) (time.Time, error) {
+	v, err := strconv.ParseInt(f.values[i], 10, 64)
+	if err != nil {
+		return time.Time{}, f.Error(i, err)
+	}
+	return time.Unix(v, 0), nil
+}
+
+// Seconds parses the field as a duration in seconds
+func (f *Fields) Seconds(i int) (time.Duration, error) {
+	v, err := strconv.ParseInt(f.values[i], 10, 64)
+	if err != nil {
+		return 0, f.Error(i, err)
+	}
+	return time.Duration(v) * time.Second, nil
+}
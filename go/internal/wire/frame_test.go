@@ -0,0 +1,169 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// Builds a frame with the CRC of the content
+func frame(tag, content string) string {
+	return fmt.Sprintf("<%s>%s%04X</%s>", tag, content, Calculate([]byte(content)), tag)
+}
+
+func TestFrame_Fields(t *testing.T) {
+	f, err := OpenFrame(frame("Pc", "1700000000;12;done;"), "Pc", nil)
+	if err != nil {
+		t.Fatalf("OpenFrame: %v", err)
+	}
+	content, offset, err := f.Checksum()
+	if err != nil {
+		t.Fatalf("Checksum: %v", err)
+	}
+	fields, err := f.Fields(content, offset, "timestamp", "command id", "message")
+	if err != nil {
+		t.Fatalf("Fields: %v", err)
+	}
+
+	if fields.Len() != 3 || fields.String(2) != "done" {
+		t.Errorf("expected 3 fields ending with done, got %d", fields.Len())
+	}
+	if id, err := fields.Int(1); err != nil || id != 12 {
+		t.Errorf("expected command id 12, got %d (%v)", id, err)
+	}
+}
+
+func TestFrame_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		sentinel error
+		field    int
+		fname    string
+		offset   int
+	}{
+		{name: "wrong tag", raw: frame("Pd", "1;2;3;"), sentinel: ErrInvalidPacket, field: -1, offset: -1},
+		{name: "bad crc", raw: "<Pc>1700000000;12;done;0000</Pc>", sentinel: ErrInvalidCrc, field: -1, fname: "crc", offset: 23},
+		{name: "missing crc", raw: "<Pc>1700000000</Pc>", sentinel: ErrFieldCount, field: -1, fname: "crc", offset: 4},
+		{name: "missing field", raw: frame("Pc", "1700000000;12;"), sentinel: ErrFieldCount, field: -1, offset: -1},
+		{name: "extra field", raw: frame("Pc", "1700000000;12;done;extra;"), sentinel: ErrFieldCount, field: -1, offset: -1},
+		{name: "invalid field", raw: frame("Pc", "1700000000;x;done;"), sentinel: ErrInvalidField, field: 1, fname: "command id", offset: 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodePc(tt.raw, nil)
+
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("expected a *DecodeError, got %v", err)
+			}
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("expected %v, got %v", tt.sentinel, err)
+			}
+			if decodeErr.Field != tt.field || decodeErr.Name != tt.fname || decodeErr.Offset != tt.offset {
+				t.Errorf("expected field %d %q at %d, got %d %q at %d",
+					tt.field, tt.fname, tt.offset, decodeErr.Field, decodeErr.Name, decodeErr.Offset)
+			}
+		})
+	}
+}
+
+func TestFrame_InvalidFieldCause(t *testing.T) {
+	err := decodePc(frame("Pc", "1700000000;x;done;"), nil)
+
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("expected the strconv error as cause, got %v", err)
+	}
+	expected := `<Pc> command id (field 1) at offset 15: invalid field "x": strconv.Atoi: parsing "x": invalid syntax`
+	if err.Error() != expected {
+		t.Errorf("expected %s, got %s", expected, err.Error())
+	}
+}
+
+func TestFrame_Lenient(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		sentinel error
+	}{
+		{name: "bad crc", raw: "<Pc>1700000000;12;done;0000</Pc>", sentinel: ErrInvalidCrc},
+		{name: "extra field", raw: frame("Pc", "1700000000;12;done;extra;"), sentinel: ErrFieldCount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decoder{Lenient: true}
+			if err := decodePc(tt.raw, d); err != nil {
+				t.Fatalf("expected the frame to be accepted, got %v", err)
+			}
+			if len(d.Warnings) != 1 || !errors.Is(d.Warnings[0], tt.sentinel) {
+				t.Errorf("expected a %v warning, got %v", tt.sentinel, d.Warnings)
+			}
+		})
+	}
+}
+
+func TestFrame_LenientStillRejects(t *testing.T) {
+	tests := []string{
+		frame("Pc", "1700000000;12;"),
+		frame("Pc", "1700000000;x;done;"),
+		"<Pc>1700000000;12;done;0000",
+	}
+
+	for _, raw := range tests {
+		d := &Decoder{Lenient: true}
+		if err := decodePc(raw, d); err == nil {
+			t.Errorf("%q: expected an error in lenient mode", raw)
+		}
+	}
+}
+
+func TestFrame_Groups(t *testing.T) {
+	first := "1;reboot;;"
+	second := "2;set;a:1;"
+	content := fmt.Sprintf("%s%04X;%s%04X;", first, Calculate([]byte(first)), second, Calculate([]byte(second)))
+
+	f, err := OpenFrame(frame("Ac", content), "Ac", nil)
+	if err != nil {
+		t.Fatalf("OpenFrame: %v", err)
+	}
+	body, offset, err := f.Checksum()
+	if err != nil {
+		t.Fatalf("Checksum: %v", err)
+	}
+	groups, err := f.Groups(body, offset, "command id", "command name", "arguments", "crc")
+	if err != nil {
+		t.Fatalf("Groups: %v", err)
+	}
+	if len(groups) != 2 || groups[1].String(1) != "set" {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+
+	decodeErr := groups[1].Error(0, nil)
+	if decodeErr.Field != 4 || decodeErr.Name != "command id" || decodeErr.Offset != 4+len(first)+5 {
+		t.Errorf("unexpected error of the second group: %+v", decodeErr)
+	}
+}
+
+// Decodes a <Pc> frame with the helpers, like the packets do
+func decodePc(raw string, d *Decoder) error {
+	f, err := OpenFrame(raw, "Pc", d)
+	if err != nil {
+		return err
+	}
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
+	}
+	fields, err := f.Fields(content, offset, "timestamp", "command id", "message")
+	if err != nil {
+		return err
+	}
+	if _, err := fields.Unix(0); err != nil {
+		return err
+	}
+	_, err = fields.Int(1)
+	return err
+}
@@ -1,19 +1,34 @@
 package ai
 
 import (
-	"fmt"
-	"strings"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/registry"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
 
+func init() {
+	registry.MustRegister("Im", func() registry.Packet { return &ImPacket{} })
+}
+
+// Decode decodes a frame of any AI packet, frames of other families are rejected as invalid packets
 func Decode(dataBytes []byte) (AiPackets, error) {
-	data := string(dataBytes)
-	if strings.HasPrefix(data, "<Im>") && strings.HasSuffix(data, "</Im>") {
-		packet := &ImPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+	packet, err := registry.Decode(dataBytes, accepts)
+	if err != nil {
+		return nil, err
+	}
+	return packet.(AiPackets), nil
+}
+
+// DecodeWith is like Decode with the mode of the decoder, see packets.Decoder
+func DecodeWith(dataBytes []byte, d *wire.Decoder) (AiPackets, error) {
+	packet, err := registry.DecodeWith(dataBytes, accepts, d)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(AiPackets), nil
+}
 
-	return nil, fmt.Errorf("invalid packet: %s", data)
+// Returns true if the registered packet belongs to this family
+func accepts(packet registry.Packet) bool {
+	_, ok := packet.(AiPackets)
+	return ok
 }
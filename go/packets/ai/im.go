@@ -1,17 +1,19 @@
 package ai
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
 // ImPacket is the AI message packet.
 type ImPacket struct {
+	// Makes it a server.ServerPackets, the servers send it to the devices
+	server.Extension
+
 	// Timestamp of the packet
 	Timestamp time.Time
 
@@ -23,40 +25,41 @@ type ImPacket struct {
 }
 
 // FromPacket converts a raw <Im>...</Im> string to an ImPacket.
+// Returns a DecodeError if the packet is invalid, raw is not modified.
 func (p *ImPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Im>") || !strings.HasSuffix(*raw, "</Im>") {
-		return errors.New("invalid packet, should be <Im>...</Im>")
-	}
-
-	body := (*raw)[4 : len(*raw)-5]
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := body[len(body)-4:]
-	body = body[:len(body)-4]
+// FromFrame converts a frame to an ImPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained.
+func (p *ImPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *ImPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Im", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(body))
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	body = strings.TrimSuffix(body, ";")
-	parts := strings.Split(body, ";")
-	if len(parts) != 3 {
-		return errors.New("invalid packet, should have 3 parts")
+	fields, err := f.Fields(content, offset, "timestamp", "chat id", "message")
+	if err != nil {
+		return err
 	}
 
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := fields.Unix(0)
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
+		return err
 	}
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-	p.ChatId = parts[1]
-	p.Message = strings.ReplaceAll(parts[2], "|||", ";")
 
+	p.Timestamp = timestamp
+	p.ChatId = fields.String(1)
+	p.Message = strings.ReplaceAll(fields.String(2), "|||", ";")
 	return nil
 }
 
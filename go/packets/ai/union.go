@@ -2,6 +2,8 @@ package ai
 
 func (ImPacket) isAiPacket() {}
 
+func (ImPacket) Tag() string { return "Im" }
+
 type AiPackets interface {
 	isAiPacket()
 	ToPacket() *string
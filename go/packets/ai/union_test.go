@@ -5,3 +5,14 @@ import "testing"
 func TestAiPackets_MarkerMethods(t *testing.T) {
 	ImPacket{}.isAiPacket()
 }
+
+func TestAiPackets_Tags(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{ImPacket{}.Tag(), "Im"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Tag() = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -1,75 +1,41 @@
 package client
 
 import (
-	"fmt"
-	"strings"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/registry"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
 
-func Decode(dataBytes []byte) (ClientPackets, error) {
-	data := string(dataBytes)
-	if strings.HasPrefix(data, "<Pa>") && strings.HasSuffix(data, "</Pa>") {
-		packet := &PaPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Pb>") && strings.HasSuffix(data, "</Pb>") {
-		packet := &PbPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Pc>") && strings.HasSuffix(data, "</Pc>") {
-		packet := &PcPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Pd>") && strings.HasSuffix(data, "</Pd>") {
-		packet := &PdPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Pi>") && strings.HasSuffix(data, "</Pi>") {
-		packet := &PiPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Pm>") && strings.HasSuffix(data, "</Pm>") {
-		packet := &PmPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
+func init() {
+	registry.MustRegister("Pa", func() registry.Packet { return &PaPacket{} })
+	registry.MustRegister("Pb", func() registry.Packet { return &PbPacket{} })
+	registry.MustRegister("Pc", func() registry.Packet { return &PcPacket{} })
+	registry.MustRegister("Pd", func() registry.Packet { return &PdPacket{} })
+	registry.MustRegister("Pi", func() registry.Packet { return &PiPacket{} })
+	registry.MustRegister("Pm", func() registry.Packet { return &PmPacket{} })
+	registry.MustRegister("Pr", func() registry.Packet { return &PrPacket{} })
+	registry.MustRegister("Ps", func() registry.Packet { return &PsPacket{} })
+}
 
-	if strings.HasPrefix(data, "<Pr>") && strings.HasSuffix(data, "</Pr>") {
-		packet := &PrPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+// Decode decodes a frame of any client packet, frames of other families are rejected as invalid packets
+func Decode(dataBytes []byte) (ClientPackets, error) {
+	packet, err := registry.Decode(dataBytes, accepts)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(ClientPackets), nil
+}
 
-	if strings.HasPrefix(data, "<Ps>") && strings.HasSuffix(data, "</Ps>") {
-		packet := &PsPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+// DecodeWith is like Decode with the mode of the decoder, see packets.Decoder
+func DecodeWith(dataBytes []byte, d *wire.Decoder) (ClientPackets, error) {
+	packet, err := registry.DecodeWith(dataBytes, accepts, d)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(ClientPackets), nil
+}
 
-	return nil, fmt.Errorf("invalid packet: %s", data)
+// Returns true if the registered packet belongs to this family
+func accepts(packet registry.Packet) bool {
+	_, ok := packet.(ClientPackets)
+	return ok
 }
@@ -1,9 +1,11 @@
 package client_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 )
 
@@ -69,8 +71,15 @@ func TestDecode_AllClientPackets(t *testing.T) {
 
 func TestDecode_UnknownClientTag(t *testing.T) {
 	_, err := client.Decode([]byte("<Xx>garbage</Xx>"))
-	if err == nil {
-		t.Error("expected error for unknown tag")
+	if !errors.Is(err, wire.ErrInvalidPacket) {
+		t.Errorf("expected ErrInvalidPacket for unknown tag, got %v", err)
+	}
+}
+
+func TestDecode_CrcMismatch(t *testing.T) {
+	_, err := client.Decode([]byte("<Pr>;0000</Pr>"))
+	if !errors.Is(err, wire.ErrInvalidCrc) {
+		t.Errorf("expected ErrInvalidCrc, got %v", err)
 	}
 }
 
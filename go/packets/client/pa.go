@@ -1,10 +1,7 @@
 package client
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
@@ -22,37 +19,36 @@ type PaPacket struct {
 // FromPacket is a method that converts a raw packet to a PaPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PaPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Pa>") || !strings.HasSuffix(*raw, "</Pa>") {
-		return errors.New("invalid package, should be <Pa>...</Pa>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Pa>")
-	*raw = strings.TrimSuffix(*raw, "</Pa>")
+	return p.decode(*raw, nil)
+}
 
-	parts := strings.Split(*raw, ";")
+// FromFrame converts a frame to a PaPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PaPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	if len(parts) != 3 {
-		return errors.New("invalid package, should contain 3 parts")
+func (p *PaPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Pa", d)
+	if err != nil {
+		return err
 	}
 
-	receivedCrc, err := strconv.ParseUint(parts[2], 16, 16)
-
+	content, offset, err := f.Checksum()
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	content := fmt.Sprintf("%s;%s;", parts[0], parts[1])
-	calculatedCrc := wire.Calculate([]byte(content))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	fields, err := f.Fields(content, offset, "ident", "password")
+	if err != nil {
+		return err
 	}
 
-	p.Ident = &parts[0]
-	p.Password = &parts[1]
-
+	ident, password := fields.String(0), fields.String(1)
+	p.Ident = &ident
+	p.Password = &password
 	return nil
 }
 
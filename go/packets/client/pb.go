@@ -1,11 +1,9 @@
 package client
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
@@ -21,184 +19,136 @@ type PbPacket struct {
 // FromPacket is a method that converts a raw packet to a PbPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PbPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Pb>") || !strings.HasSuffix(*raw, "</Pb>") {
-		return errors.New("invalid package, should be <Pb>...</Pb>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Pb>")
-	*raw = strings.TrimSuffix(*raw, "</Pb>")
-
-	// Get the last 4 characters
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+	return p.decode(*raw, nil)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+// FromFrame converts a frame to a PbPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PbPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
+func (p *PbPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Pb", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
-
-	parts := strings.Split(*raw, ";")
-	if len(parts)%12 != 0 {
-		return errors.New("invalid advertisement definition")
+	groups, err := f.Groups(content, offset, "mac address", "timestamp", "latitude", "longitude", "altitude",
+		"model", "device name", "rssi", "tx power", "manufacturer data", "service data", "crc")
+	if err != nil {
+		return err
 	}
 
-	var advertisements []definitions.BleAdvertisement
-	for i := 0; i < len(parts); i += 12 {
-		rawMacAddress := parts[i]
-		rawTimestamp := parts[i+1]
-		rawLatitude := parts[i+2]
-		rawLongitude := parts[i+3]
-		rawAltitude := parts[i+4]
-		rawModel := parts[i+5]
-		rawDeviceName := parts[i+6]
-		rawRssi := parts[i+7]
-		rawTxPower := parts[i+8]
-		rawManufacturerData := parts[i+9]
-		rawServiceData := parts[i+10]
-		rawCrc := parts[i+11]
-
-		receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+	advertisements := make([]definitions.BleAdvertisement, 0, len(groups))
+	for _, fields := range groups {
+		advertisement, err := decodeAdvertisement(fields)
 		if err != nil {
-			return errors.New("cannot convert CRC to integer")
-		}
-
-		content := fmt.Sprintf("%s;%s;%s;%s;%s;%s;%s;%s;%s;%s;%s;", rawMacAddress, rawTimestamp, rawLatitude, rawLongitude, rawAltitude, rawModel, rawDeviceName, rawRssi, rawTxPower, rawManufacturerData, rawServiceData)
-		calculatedCrc := wire.Calculate([]byte(content))
-
-		if calculatedCrc != uint16(receivedCrc) {
-			return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+			return err
 		}
+		advertisements = append(advertisements, advertisement)
+	}
 
-		timestampInt, err := strconv.ParseInt(rawTimestamp, 10, 64)
-		if err != nil {
-			return errors.New("cannot convert timestamp to integer")
-		}
+	p.Advertisements = &advertisements
+	return nil
+}
 
-		timestamp := time.Unix(timestampInt, 0)
+// Decodes the fields of an advertisement
+func decodeAdvertisement(fields *wire.Fields) (definitions.BleAdvertisement, error) {
+	advertisement := definitions.BleAdvertisement{
+		MacAddress: normalizeMac(fields.String(0)),
+		Model:      fields.String(5),
+		DeviceName: fields.String(6),
+		TxPower:    -999,
+	}
 
-		var latitude *float64
-		if rawLatitude != "" {
-			v, err := strconv.ParseFloat(rawLatitude, 64)
-			if err != nil {
-				return errors.New("cannot convert latitude to float")
-			}
-			latitude = &v
+	var err error
+	if advertisement.Timestamp, err = fields.Unix(1); err != nil {
+		return advertisement, err
+	}
+	if advertisement.Latitude, err = fields.OptionalFloat(2); err != nil {
+		return advertisement, err
+	}
+	if advertisement.Longitude, err = fields.OptionalFloat(3); err != nil {
+		return advertisement, err
+	}
+	if advertisement.Altitude, err = fields.OptionalFloat(4); err != nil {
+		return advertisement, err
+	}
+	if advertisement.Rssi, err = fields.Int(7); err != nil {
+		return advertisement, err
+	}
+	if fields.String(8) != "" {
+		if advertisement.TxPower, err = fields.Int(8); err != nil {
+			return advertisement, err
 		}
+	}
 
-		var longitude *float64
-		if rawLongitude != "" {
-			v, err := strconv.ParseFloat(rawLongitude, 64)
-			if err != nil {
-				return errors.New("cannot convert longitude to float")
-			}
-			longitude = &v
+	advertisement.ManufacturerData = make([]definitions.BleManufacturerData, 0)
+	for _, entry := range strings.Split(fields.String(9), ",") {
+		if entry == "" {
+			continue
 		}
+		rawCompanyId, rawData, _ := strings.Cut(entry, ":")
 
-		var altitude *float64
-		if rawAltitude != "" {
-			v, err := strconv.ParseFloat(rawAltitude, 64)
-			if err != nil {
-				return errors.New("cannot convert altitude to float")
-			}
-			altitude = &v
+		companyId, err := strconv.ParseUint(rawCompanyId, 16, 16)
+		if err != nil {
+			return advertisement, fields.Error(9, fmt.Errorf("invalid company id %q", rawCompanyId))
 		}
-
-		rssi, err := strconv.Atoi(rawRssi)
+		data, err := decodeHex(rawData)
 		if err != nil {
-			return errors.New("cannot convert rssi to integer")
+			return advertisement, fields.Error(9, err)
 		}
 
-		txPower := -999
-		if rawTxPower != "" {
-			txPower, err = strconv.Atoi(rawTxPower)
-			if err != nil {
-				return errors.New("cannot convert tx power to integer")
-			}
-		}
+		advertisement.ManufacturerData = append(advertisement.ManufacturerData, definitions.BleManufacturerData{
+			CompanyId: int(companyId),
+			Data:      data,
+		})
+	}
 
-		manufacturerData := make([]definitions.BleManufacturerData, 0)
-		for _, data := range strings.Split(rawManufacturerData, ",") {
-			if data == "" {
-				continue
-			}
-			subparts := strings.Split(data, ":")
-
-			companyId, err := strconv.ParseUint(subparts[0], 16, 16)
-			if err != nil {
-				return errors.New("cannot convert company id to integer")
-			}
-
-			data := make([]byte, 0)
-			for i := 0; i < len(subparts[1]); i += 2 {
-				value, err := strconv.ParseInt(subparts[1][i:i+2], 16, 64)
-				if err != nil {
-					return errors.New("cannot convert data to byte")
-				}
-
-				data = append(data, byte(value))
-			}
-
-			manufacturerData = append(manufacturerData, definitions.BleManufacturerData{
-				CompanyId: int(companyId),
-				Data:      []byte(data),
-			})
+	advertisement.ServiceData = make([]definitions.BleServiceData, 0)
+	for _, entry := range strings.Split(fields.String(10), ",") {
+		rawUuid, rawData, _ := strings.Cut(entry, ":")
+		if rawUuid == "" {
+			continue
 		}
 
-		serviceData := make([]definitions.BleServiceData, 0)
-		for _, data := range strings.Split(rawServiceData, ",") {
-			subparts := strings.Split(data, ":")
-			if subparts[0] == "" {
-				continue
-			}
-			uuid, err := strconv.ParseUint(subparts[0], 16, 16)
-			if err != nil {
-				return fmt.Errorf("cannot convert uuid \"%s\" to integer", subparts[0])
-			}
-
-			data := make([]byte, 0)
-			for i := 0; i < len(subparts[1]); i += 2 {
-				value, err := strconv.ParseInt(subparts[1][i:i+2], 16, 64)
-				if err != nil {
-					return errors.New("cannot convert data to byte")
-				}
-
-				data = append(data, byte(value))
-			}
-
-			serviceData = append(serviceData, definitions.BleServiceData{
-				Uuid: int(uuid),
-				Data: []byte(data),
-			})
+		uuid, err := strconv.ParseUint(rawUuid, 16, 16)
+		if err != nil {
+			return advertisement, fields.Error(10, fmt.Errorf("invalid uuid %q", rawUuid))
+		}
+		data, err := decodeHex(rawData)
+		if err != nil {
+			return advertisement, fields.Error(10, err)
 		}
 
-		advertisements = append(advertisements, definitions.BleAdvertisement{
-			MacAddress:       normalizeMac(rawMacAddress),
-			Timestamp:        timestamp,
-			Latitude:         latitude,
-			Longitude:        longitude,
-			Altitude:         altitude,
-			Rssi:             rssi,
-			TxPower:          txPower,
-			Model:            rawModel,
-			DeviceName:       rawDeviceName,
-			ManufacturerData: manufacturerData,
-			ServiceData:      serviceData,
+		advertisement.ServiceData = append(advertisement.ServiceData, definitions.BleServiceData{
+			Uuid: int(uuid),
+			Data: data,
 		})
 	}
 
-	p.Advertisements = &advertisements
-	return nil
+	return advertisement, nil
+}
+
+// Decodes the bytes of an hexadecimal string, a trailing half byte is ignored
+func decodeHex(raw string) ([]byte, error) {
+	data := make([]byte, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		value, err := strconv.ParseUint(raw[i:i+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data byte %q", raw[i:i+2])
+		}
+		data = append(data, byte(value))
+	}
+	return data, nil
 }
 
 // ToPacket is a method that converts a PbPacket to a raw packet
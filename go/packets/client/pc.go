@@ -1,10 +1,7 @@
 package client
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
@@ -26,50 +23,46 @@ type PcPacket struct {
 // FromPacket is a method that converts a raw packet to a PcPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PcPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Pc>") || !strings.HasSuffix(*raw, "</Pc>") {
-		return errors.New("invalid package, should be <Pc>...</Pc>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Pc>")
-	*raw = strings.TrimSuffix(*raw, "</Pc>")
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+// FromFrame converts a frame to a PcPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PcPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *PcPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Pc", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
-
-	parts := strings.Split(*raw, ";")
-
-	if len(parts) != 3 {
-		return errors.New("invalid package, should contain 3 parts")
+	fields, err := f.Fields(content, offset, "timestamp", "command id", "message")
+	if err != nil {
+		return err
 	}
 
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := fields.Unix(0)
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
+		return err
 	}
-
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-	p.Message = &parts[2]
-
-	p.CommandId, err = strconv.Atoi(parts[1])
+	commandId, err := fields.Int(1)
 	if err != nil {
-		return errors.New("cannot convert command id to integer")
+		return err
 	}
 
+	message := fields.String(2)
+	p.Timestamp = timestamp
+	p.CommandId = commandId
+	p.Message = &message
 	return nil
 }
 
@@ -1,13 +1,12 @@
 package client
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
 
@@ -20,123 +19,80 @@ type PdPacket struct {
 	// Is the position of the device
 	Position *definitions.Position
 
-	// Is the extra data sent by the device
+	// Is the extra data sent by the device, decoded with canonical keys
 	ExtraData map[string]any
+
+	// Defines the keys of ExtraData written by ToPacket, by default they are written as they are.
+	// Use extras.WireKeys to write the short keys of the devices, or extras.CanonicalKeys
+	// to write the canonical keys
+	KeyStyle extras.KeyStyle
 }
 
 // FromPacket is a method that converts a raw packet to a PdPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PdPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Pd>") || !strings.HasSuffix(*raw, "</Pd>") {
-		return errors.New("invalid package, should be <Pd>...</Pd>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Pd>")
-	*raw = strings.TrimSuffix(*raw, "</Pd>")
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+// FromFrame converts a frame to a PdPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PdPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *PdPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Pd", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
-	}
-
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
-
-	parts := strings.Split(*raw, ";")
-	if len(parts) != 9 {
-		return errors.New("invalid package, should contain 9 parts")
-	}
-
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	content, offset, err := f.Checksum()
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
-	}
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-
-	var latitude, longitude, altitude, speed, direction, hdop *float64
-	var satelliteCount *int
-
-	if parts[1] != "" {
-		converted, err := strconv.ParseFloat(parts[1], 64)
-		if err != nil {
-			return errors.New("cannot convert latitude to float")
-		}
-		latitude = &converted
+		return err
 	}
 
-	if parts[2] != "" {
-		converted, err := strconv.ParseFloat(parts[2], 64)
-		if err != nil {
-			return errors.New("cannot convert longitude to float")
-		}
-		longitude = &converted
+	fields, err := f.Fields(content, offset, "timestamp", "latitude", "longitude", "altitude",
+		"speed", "direction", "satellite count", "hdop", "extra data")
+	if err != nil {
+		return err
 	}
 
-	if parts[3] != "" {
-		converted, err := strconv.ParseFloat(parts[3], 64)
-		if err != nil {
-			return errors.New("cannot convert altitude to float")
-		}
-		altitude = &converted
+	timestamp, err := fields.Unix(0)
+	if err != nil {
+		return err
 	}
 
-	if parts[4] != "" {
-		converted, err := strconv.ParseFloat(parts[4], 64)
-		if err != nil {
-			return errors.New("cannot convert speed to float")
+	var position definitions.Position
+	for i, value := range []**float64{&position.Latitude, &position.Longitude, &position.Altitude, &position.Speed, &position.Direction} {
+		if *value, err = fields.OptionalFloat(i + 1); err != nil {
+			return err
 		}
-		speed = &converted
 	}
-
-	if parts[5] != "" {
-		converted, err := strconv.ParseFloat(parts[5], 64)
-		if err != nil {
-			return errors.New("cannot convert direction to float")
-		}
-		direction = &converted
+	if position.SatelliteCount, err = fields.OptionalInt(6); err != nil {
+		return err
 	}
-
-	if parts[6] != "" {
-		converted, err := strconv.Atoi(parts[6])
-		if err != nil {
-			return errors.New("cannot convert satellite count to integer")
-		}
-		satelliteCount = &converted
+	if position.Hdop, err = fields.OptionalFloat(7); err != nil {
+		return err
 	}
 
-	if parts[7] != "" {
-		converted, err := strconv.ParseFloat(parts[7], 64)
-		if err != nil {
-			return errors.New("cannot convert hdop to float")
-		}
-		hdop = &converted
-	}
-
-	p.Position = &definitions.Position{
-		Latitude:       latitude,
-		Longitude:      longitude,
-		Altitude:       altitude,
-		Speed:          speed,
-		Direction:      direction,
-		SatelliteCount: satelliteCount,
-		Hdop:           hdop,
+	extraData, err := wire.DecodeArgs(fields.String(8), d)
+	if err != nil {
+		return fields.Error(8, err)
 	}
 
-	p.ExtraData = wire.ParseArgs(parts[8])
-
+	p.Timestamp = timestamp
+	p.Position = &position
+	p.ExtraData = extraData
 	return nil
 }
 
+// Extras returns the ExtraData with typed accessors
+func (p *PdPacket) Extras() extras.Extras {
+	return p.ExtraData
+}
+
 // ToPacket is a method that converts a PdPacket to a raw packet
 // based on the `Layrz Protocol v2` specification
 func (p *PdPacket) ToPacket() *string {
@@ -183,10 +139,11 @@ func (p *PdPacket) ToPacket() *string {
 	}
 
 	args := make([]string, 0)
-	for rawKey, value := range p.ExtraData {
+	for _, rawKey := range wire.SortedKeys(p.ExtraData) {
+		value := p.ExtraData[rawKey]
 		// Escape colons in the key so they don't collide with the `key:value` separator on the wire
 		// (e.g. a MAC-like identifier `a4:c1:...`). Reversed by wire.ParseArgs (`___` -> `:`).
-		key := strings.ReplaceAll(rawKey, ":", "___")
+		key := strings.ReplaceAll(extras.DefaultDictionary.Key(rawKey, p.KeyStyle), ":", "___")
 		switch v := value.(type) {
 		case string:
 			// Escape colons in the value as well.
@@ -1,12 +1,15 @@
 package client_test
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 )
 
@@ -176,3 +179,147 @@ func buildPdWithField(encoded string, fieldIdx int, badValue string) string {
 	mutated := strings.Join(parts, ";") + ";"
 	return fmt.Sprintf("<Pd>%s%s</Pd>", mutated, inner[len(inner)-4:])
 }
+
+func TestPd_ToPacket_Deterministic(t *testing.T) {
+	p := client.PdPacket{
+		Timestamp: time.Unix(1700000000, 0),
+		ExtraData: map[string]any{
+			"speed":       10,
+			"alarm.event": true,
+			"ble.mac":     "a4:c1:38",
+			"battery":     3.5,
+		},
+	}
+
+	want := "1700000000;;;;;;;;alarm.event:true,battery:3.5,ble.mac:a4___c1___38,speed:10;"
+	first := *p.ToPacket()
+	if !strings.HasPrefix(first, "<Pd>"+want) {
+		t.Errorf("ToPacket = %q, want the arguments sorted by key: %q", first, want)
+	}
+	for range 20 {
+		if got := *p.ToPacket(); got != first {
+			t.Fatalf("ToPacket changed between calls: %q != %q", got, first)
+		}
+	}
+}
+
+func TestPd_ToPacket_KeyStyle(t *testing.T) {
+	raw := "1700000000;;;;;;;;io3.di:true,report:7;"
+	raw = "<Pd>" + raw + fmt.Sprintf("%04X", wire.Calculate([]byte(raw))) + "</Pd>"
+
+	var p client.PdPacket
+	decoded := raw
+	if err := p.FromPacket(&decoded); err != nil {
+		t.Fatalf("FromPacket failed: %v", err)
+	}
+	if p.ExtraData["gpio.3.digital.input"] != true || p.ExtraData["report.code"] != 7 {
+		t.Fatalf("expected canonical keys, got %v", p.ExtraData)
+	}
+
+	if got := *p.ToPacket(); !strings.Contains(got, "gpio.3.digital.input:true,report.code:7;") {
+		t.Errorf("default ToPacket = %q", got)
+	}
+
+	// By default the keys are written as they are
+	p.ExtraData = map[string]any{"report": 1, "io3.di": true}
+	if got := *p.ToPacket(); !strings.Contains(got, "io3.di:true,report:1;") {
+		t.Errorf("default ToPacket of wire keys = %q", got)
+	}
+	p.KeyStyle = extras.CanonicalKeys
+	if got := *p.ToPacket(); !strings.Contains(got, "gpio.3.digital.input:true,report.code:1;") {
+		t.Errorf("canonical ToPacket = %q", got)
+	}
+	p.ExtraData = map[string]any{"gpio.3.digital.input": true, "report.code": 7}
+
+	// Decode then encode with the wire keys gives back the frame of the device
+	p.KeyStyle = extras.WireKeys
+	if got := *p.ToPacket(); got != raw {
+		t.Errorf("wire ToPacket = %q, want %q", got, raw)
+	}
+}
+
+func TestPd_FromPacket_Schema(t *testing.T) {
+	schema, err := extras.NewSchema(extras.Coerce, map[string]extras.Field{
+		"gpio.{n}.analog.input": {Type: extras.TypeFloat, Max: floatPtr(5)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &wire.Decoder{Schema: schema}
+
+	encode := func(args string) string {
+		content := "1700000000;;;;;;;;" + args + ";"
+		return "<Pd>" + content + fmt.Sprintf("%04X", wire.Calculate([]byte(content))) + "</Pd>"
+	}
+
+	var p client.PdPacket
+	if err := p.FromFrame([]byte(encode("io1.ai:3")), d); err != nil {
+		t.Fatalf("FromFrame failed: %v", err)
+	}
+	if v, err := p.Extras().GPIO(1).AnalogInput(); err != nil || v != 3 {
+		t.Errorf("AnalogInput = %f, %v", v, err)
+	}
+	if _, ok := p.ExtraData["gpio.1.analog.input"].(float64); !ok {
+		t.Errorf("expected the value to be coerced to float64, got %T", p.ExtraData["gpio.1.analog.input"])
+	}
+
+	if err := p.FromFrame([]byte(encode("io1.ai:12")), d); !errors.Is(err, extras.ErrOutOfRange) {
+		t.Errorf("expected ErrOutOfRange, got %v", err)
+	}
+
+	// The default schema is empty
+	raw := encode("io1.ai:12")
+	if err := p.FromPacket(&raw); err != nil {
+		t.Errorf("FromPacket without schema failed: %v", err)
+	}
+}
+
+// A frame like the ones sent by the trackers, with a position and the usual extra arguments
+func benchmarkPdFrame() []byte {
+	lat, lng, alt, speed, dir, hdop := 10.123456, -66.123456, 900.0, 45.5, 180.0, 0.9
+	satellites := 12
+	packet := &client.PdPacket{
+		Timestamp: time.Unix(1700000000, 0),
+		Position: &definitions.Position{
+			Latitude: &lat, Longitude: &lng, Altitude: &alt, Speed: &speed, Direction: &dir,
+			SatelliteCount: &satellites, Hdop: &hdop,
+		},
+		ExtraData: map[string]any{
+			"gpio.1.digital.input":      true,
+			"gpio.2.digital.input":      false,
+			"gpio.1.analog.input":       12.5,
+			"gpio.1.event.count":        42,
+			"ble.0.mac.address":         "AA:BB:CC:DD:EE:FF",
+			"ble.0.temperature.celsius": 23.4,
+			"ble.0.humidity":            55,
+			"report.code":               1,
+			"power.voltage":             12.8,
+			"ident":                     "007",
+		},
+		KeyStyle: extras.WireKeys,
+	}
+	return []byte(*packet.ToPacket())
+}
+
+func BenchmarkPd_FromFrame(b *testing.B) {
+	data := benchmarkPdFrame()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		var p client.PdPacket
+		if err := p.FromFrame(data, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode_Pd(b *testing.B) {
+	data := benchmarkPdFrame()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, err := client.Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
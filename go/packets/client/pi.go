@@ -1,10 +1,7 @@
 package client
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
@@ -48,66 +45,59 @@ type PiPacket struct {
 // FromPacket is a method that converts a raw packet to a PiPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PiPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Pi>") || !strings.HasSuffix(*raw, "</Pi>") {
-		return errors.New("invalid package, should be <Pi>...</Pi>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Pi>")
-	*raw = strings.TrimSuffix(*raw, "</Pi>")
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+// FromFrame converts a frame to a PiPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PiPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *PiPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Pi", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
-	parts := strings.Split(*raw, ";")
-
-	if len(parts) != 8 {
-		return errors.New("invalid package, should contain 8 parts")
+	fields, err := f.Fields(content, offset, "ident", "firmware id", "firmware build", "device id",
+		"hardware id", "model id", "firmware branch", "fota enabled")
+	if err != nil {
+		return err
 	}
 
-	p.Ident = parts[0]
-	p.FirmwareId = parts[1]
-
-	firmwareBuild, err := strconv.Atoi(parts[2])
+	firmwareBuild, err := fields.Int(2)
 	if err != nil {
-		return errors.New("cannot convert firmware build to integer")
+		return err
 	}
-	p.FirmwareBuild = firmwareBuild
-
-	deviceId, err := strconv.Atoi(parts[3])
+	deviceId, err := fields.Int(3)
 	if err != nil {
-		return errors.New("cannot convert device id to integer")
+		return err
 	}
-	p.DeviceId = deviceId
-
-	hardwareId, err := strconv.Atoi(parts[4])
+	hardwareId, err := fields.Int(4)
 	if err != nil {
-		return errors.New("cannot convert hardware id to integer")
+		return err
 	}
-	p.HardwareId = hardwareId
-
-	modelId, err := strconv.Atoi(parts[5])
+	modelId, err := fields.Int(5)
 	if err != nil {
-		return errors.New("cannot convert model id to integer")
+		return err
 	}
-	p.ModelId = modelId
-
-	p.FirmwareBranch = definitions.FirmwareBranch(parts[6])
-	p.FotaEnabled = parts[7] == "true" || parts[7] == "1"
 
+	p.Ident = fields.String(0)
+	p.FirmwareId = fields.String(1)
+	p.FirmwareBuild = firmwareBuild
+	p.DeviceId = deviceId
+	p.HardwareId = hardwareId
+	p.ModelId = modelId
+	p.FirmwareBranch = definitions.FirmwareBranch(fields.String(6))
+	p.FotaEnabled = fields.String(7) == "true" || fields.String(7) == "1"
 	return nil
 }
 
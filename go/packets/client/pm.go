@@ -2,10 +2,7 @@ package client
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
@@ -26,44 +23,42 @@ type PmPacket struct {
 // FromPacket is a method that converts a raw packet to a PmPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PmPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Pm>") || !strings.HasSuffix(*raw, "</Pm>") {
-		return errors.New("invalid package, should be <Pm>...</Pm>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Pm>")
-	*raw = strings.TrimSuffix(*raw, "</Pm>")
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+// FromFrame converts a frame to a PmPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PmPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *PmPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Pm", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
-	parts := strings.Split(*raw, ";")
-	if len(parts) != 3 {
-		return errors.New("invalid package, should contain 3 parts")
+	fields, err := f.Fields(content, offset, "filename", "content type", "data")
+	if err != nil {
+		return err
 	}
 
-	p.Filename = &parts[0]
-	p.ContentType = &parts[1]
-
-	data, err := base64.StdEncoding.DecodeString(parts[2])
+	data, err := base64.StdEncoding.DecodeString(fields.String(2))
 	if err != nil {
-		return errors.New("cannot decode base64 data")
+		return fields.Error(2, err)
 	}
-	p.Data = &data
 
+	filename, contentType := fields.String(0), fields.String(1)
+	p.Filename = &filename
+	p.ContentType = &contentType
+	p.Data = &data
 	return nil
 }
 
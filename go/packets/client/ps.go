@@ -1,12 +1,11 @@
 package client
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/goldenm-software/layrz-protocol/go/v3/extras"
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
 
@@ -16,63 +15,75 @@ type PsPacket struct {
 	// Is the timestamp of the response packet
 	Timestamp time.Time
 
-	// Is the current configuration of the device
+	// Is the current configuration of the device, decoded with canonical keys
 	Params map[string]any
+
+	// Defines the keys of Params written by ToPacket, by default they are written as they are.
+	// Use extras.WireKeys to write the short keys of the devices, or extras.CanonicalKeys
+	// to write the canonical keys
+	KeyStyle extras.KeyStyle
 }
 
 // FromPacket is a method that converts a raw packet to a PsPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *PsPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Ps>") || !strings.HasSuffix(*raw, "</Ps>") {
-		return errors.New("invalid package, should be <Ps>...</Ps>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Ps>")
-	*raw = strings.TrimSuffix(*raw, "</Ps>")
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+// FromFrame converts a frame to a PsPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *PsPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *PsPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Ps", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
-	parts := strings.Split(*raw, ";")
-	if len(parts) != 2 {
-		return errors.New("invalid package, should contain 2 parts")
+	fields, err := f.Fields(content, offset, "timestamp", "parameters")
+	if err != nil {
+		return err
 	}
 
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := fields.Unix(0)
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
+		return err
+	}
+	params, err := wire.DecodeArgs(fields.String(1), d)
+	if err != nil {
+		return fields.Error(1, err)
 	}
 
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-	p.Params = wire.ParseArgs(parts[1])
-
+	p.Timestamp = timestamp
+	p.Params = params
 	return nil
 }
 
+// Extras returns the Params with typed accessors
+func (p *PsPacket) Extras() extras.Extras {
+	return p.Params
+}
+
 // ToPacket is a method that converts a PsPacket to a raw packet
 // based on the `Layrz Protocol v2` specification
 func (p *PsPacket) ToPacket() *string {
 	content := ""
 
 	params := make([]string, 0)
-	for rawKey, value := range p.Params {
+	for _, rawKey := range wire.SortedKeys(p.Params) {
+		value := p.Params[rawKey]
 		// Escape colons in the key so they don't collide with the `key:value` separator on the wire
 		// (e.g. a MAC-like identifier `a4:c1:...`). Reversed by wire.ParseArgs (`___` -> `:`).
-		key := strings.ReplaceAll(rawKey, ":", "___")
+		key := strings.ReplaceAll(extras.DefaultDictionary.Key(rawKey, p.KeyStyle), ":", "___")
 		switch v := value.(type) {
 		case string:
 			// Escape colons in the value as well.
@@ -1,6 +1,7 @@
 package client_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
@@ -71,3 +72,21 @@ func TestPs_FromPacket_ToPacket(t *testing.T) {
 		})
 	}
 }
+
+func TestPs_ToPacket_Deterministic(t *testing.T) {
+	packet := client.PsPacket{
+		Timestamp: fixedTime,
+		Params:    map[string]any{"interval": 30, "apn": "internet", "enabled": true},
+	}
+
+	want := "apn:internet,enabled:true,interval:30;"
+	first := *packet.ToPacket()
+	if !strings.Contains(first, ";"+want) {
+		t.Errorf("ToPacket = %q, want the parameters sorted by key: %q", first, want)
+	}
+	for range 20 {
+		if got := *packet.ToPacket(); got != first {
+			t.Fatalf("ToPacket changed between calls: %q != %q", got, first)
+		}
+	}
+}
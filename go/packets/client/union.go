@@ -9,7 +9,22 @@ func (PmPacket) isClientPacket() {}
 func (PrPacket) isClientPacket() {}
 func (PsPacket) isClientPacket() {}
 
+func (PaPacket) Tag() string { return "Pa" }
+func (PbPacket) Tag() string { return "Pb" }
+func (PcPacket) Tag() string { return "Pc" }
+func (PdPacket) Tag() string { return "Pd" }
+func (PiPacket) Tag() string { return "Pi" }
+func (PmPacket) Tag() string { return "Pm" }
+func (PrPacket) Tag() string { return "Pr" }
+func (PsPacket) Tag() string { return "Ps" }
+
 type ClientPackets interface {
 	isClientPacket()
 	ToPacket() *string
 }
+
+// Extension is embedded by custom packets registered with packets.Register,
+// so they are decoded by Decode and delivered to the servers as ClientPackets
+type Extension struct{}
+
+func (Extension) isClientPacket() {}
@@ -12,3 +12,21 @@ func TestClientPackets_MarkerMethods(t *testing.T) {
 	PrPacket{}.isClientPacket()
 	PsPacket{}.isClientPacket()
 }
+
+func TestClientPackets_Tags(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{PaPacket{}.Tag(), "Pa"},
+		{PbPacket{}.Tag(), "Pb"},
+		{PcPacket{}.Tag(), "Pc"},
+		{PdPacket{}.Tag(), "Pd"},
+		{PiPacket{}.Tag(), "Pi"},
+		{PmPacket{}.Tag(), "Pm"},
+		{PrPacket{}.Tag(), "Pr"},
+		{PsPacket{}.Tag(), "Ps"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Tag() = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
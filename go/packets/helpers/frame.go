@@ -0,0 +1,167 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// DefaultMaxFrameSize is the frame size limit used when FrameScanner.MaxSize is not set
+const DefaultMaxFrameSize = 1 << 20
+
+// ErrFrameTooLarge is returned when a frame exceeds FrameScanner.MaxSize before its closing tag
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// FrameScanner splits a stream into Layrz frames, <Xx>...</Xx>, of any packet family.
+//
+// Line breaks and spaces between frames are skipped. Any other content outside a frame,
+// or a frame broken by a line break before its closing tag, is garbage: it is reported
+// to OnGarbage and skipped, so the frames after it are still delivered
+type FrameScanner struct {
+	// Maximum size of a frame in bytes, by default is DefaultMaxFrameSize
+	MaxSize int
+
+	// Called with the garbage found between frames, if nil the garbage is dropped silently.
+	// The slice is only valid during the call
+	OnGarbage func(data []byte)
+}
+
+// Split implements bufio.SplitFunc, every token is a complete frame without the line break
+func (f *FrameScanner) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	maxSize := f.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+
+	// bufio.Scanner stops at EOF after a call without token, so the whitespace and
+	// garbage before a frame are skipped in the same call
+	pos := 0
+	for pos < len(data) {
+		if isSpace(data[pos]) {
+			pos++
+			continue
+		}
+
+		rest := data[pos:]
+		if rest[0] == '<' {
+			if len(rest) < 4 && !atEOF {
+				return pos, nil, nil
+			}
+
+			if len(rest) >= 4 && isOpeningTag(rest[:4]) {
+				size, err := frameSize(rest, atEOF, maxSize)
+				if err != nil {
+					return pos, nil, err
+				}
+				if size > 0 {
+					return pos + size, rest[:size], nil
+				}
+				if size == 0 {
+					return pos, nil, nil
+				}
+				// A truncated frame is garbage up to the line break or the end of the data
+				pos += f.garbage(rest, -size)
+				continue
+			}
+		}
+
+		// Garbage ends on a line break or where the next frame may start
+		end := bytes.IndexAny(rest[1:], "<\n")
+		switch {
+		case end >= 0:
+			pos += f.garbage(rest, end+1)
+		case atEOF || len(rest) > maxSize:
+			pos += f.garbage(rest, len(rest))
+		default:
+			return pos, nil, nil
+		}
+	}
+
+	return pos, nil, nil
+}
+
+// Returns the size of the frame at the start of data, zero if more data is needed or
+// the negated size of the garbage if the frame was truncated
+func frameSize(data []byte, atEOF bool, maxSize int) (int, error) {
+	closing := []byte{'<', '/', data[1], data[2], '>'}
+
+	end := bytes.Index(data[4:], closing)
+	newline := bytes.IndexByte(data[4:], '\n')
+
+	// A line break before the closing tag means the frame was truncated
+	if newline >= 0 && (end < 0 || newline < end) {
+		return -(newline + 4), nil
+	}
+
+	if end >= 0 {
+		size := end + 4 + len(closing)
+		if size > maxSize {
+			return 0, ErrFrameTooLarge
+		}
+		return size, nil
+	}
+
+	if len(data) > maxSize {
+		return 0, ErrFrameTooLarge
+	}
+	if atEOF {
+		return -len(data), nil
+	}
+	return 0, nil
+}
+
+// Reports data[:end] as garbage, returns the number of bytes to skip
+func (f *FrameScanner) garbage(data []byte, end int) int {
+	if trimmed := bytes.TrimSpace(data[:end]); len(trimmed) > 0 && f.OnGarbage != nil {
+		f.OnGarbage(trimmed)
+	}
+	return end
+}
+
+// Returns true if the data is an opening tag like <Pa>, <Ac> or <Ts>
+func isOpeningTag(data []byte) bool {
+	return data[0] == '<' &&
+		data[1] >= 'A' && data[1] <= 'Z' &&
+		data[2] >= 'a' && data[2] <= 'z' &&
+		data[3] == '>'
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// FrameReader reads complete Layrz frames from an io.Reader
+type FrameReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewFrameReader creates a FrameReader over r using the limits and hooks of the FrameScanner
+func NewFrameReader(r io.Reader, frames FrameScanner) *FrameReader {
+	maxSize := frames.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+
+	scanner := bufio.NewScanner(r)
+	// One extra byte lets the split function see that a frame went over the limit
+	scanner.Buffer(make([]byte, 0, min(4096, maxSize+1)), maxSize+1)
+	scanner.Split(frames.Split)
+	return &FrameReader{scanner: scanner}
+}
+
+// Next returns the next frame, io.EOF is returned once the reader is exhausted.
+// The returned slice is only valid until the next call
+func (r *FrameReader) Next() ([]byte, error) {
+	if r.scanner.Scan() {
+		return r.scanner.Bytes(), nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, ErrFrameTooLarge
+		}
+		return nil, err
+	}
+	return nil, io.EOF
+}
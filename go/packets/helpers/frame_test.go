@@ -0,0 +1,164 @@
+package helpers_test
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
+)
+
+// readFrames reads every frame and the garbage reported while reading
+func readFrames(t *testing.T, r io.Reader, maxSize int) (frames, garbage []string, err error) {
+	t.Helper()
+	reader := helpers.NewFrameReader(r, helpers.FrameScanner{
+		MaxSize:   maxSize,
+		OnGarbage: func(data []byte) { garbage = append(garbage, string(data)) },
+	})
+	for {
+		frame, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return frames, garbage, nil
+		}
+		if err != nil {
+			return frames, garbage, err
+		}
+		frames = append(frames, string(frame))
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantFrames  []string
+		wantGarbage []string
+	}{
+		{
+			name:  "empty input",
+			input: "",
+		},
+		{
+			name:       "single frame",
+			input:      "<Pr>;1234</Pr>",
+			wantFrames: []string{"<Pr>;1234</Pr>"},
+		},
+		{
+			name:       "CRLF separated frames",
+			input:      "<Pa>a</Pa>\r\n<Pd>b</Pd>\r\n",
+			wantFrames: []string{"<Pa>a</Pa>", "<Pd>b</Pd>"},
+		},
+		{
+			name:       "concatenated frames",
+			input:      "<Pa>a</Pa><Pb>b</Pb><Pc>c</Pc>",
+			wantFrames: []string{"<Pa>a</Pa>", "<Pb>b</Pb>", "<Pc>c</Pc>"},
+		},
+		{
+			name:       "every family",
+			input:      "<As>;0000</As><Ac>x</Ac><Ts>t</Ts><Im>i</Im>",
+			wantFrames: []string{"<As>;0000</As>", "<Ac>x</Ac>", "<Ts>t</Ts>", "<Im>i</Im>"},
+		},
+		{
+			name:        "garbage between frames",
+			input:       "<Pa>a</Pa>noise<Pb>b</Pb>\r\n",
+			wantFrames:  []string{"<Pa>a</Pa>", "<Pb>b</Pb>"},
+			wantGarbage: []string{"noise"},
+		},
+		{
+			name:        "garbage line",
+			input:       "garbage data\n<Pr>r</Pr>\n",
+			wantFrames:  []string{"<Pr>r</Pr>"},
+			wantGarbage: []string{"garbage data"},
+		},
+		{
+			name:        "invalid tag",
+			input:       "<xx>bad</xx>\n<Pr>r</Pr>",
+			wantFrames:  []string{"<Pr>r</Pr>"},
+			wantGarbage: []string{"<xx>bad", "</xx>"},
+		},
+		{
+			name:        "frame truncated by a line break",
+			input:       "<Pd>truncated\r\n<Pr>r</Pr>\r\n",
+			wantFrames:  []string{"<Pr>r</Pr>"},
+			wantGarbage: []string{"<Pd>truncated"},
+		},
+		{
+			name:        "frame truncated by the end of the stream",
+			input:       "<Pr>r</Pr><Pd>trunc",
+			wantFrames:  []string{"<Pr>r</Pr>"},
+			wantGarbage: []string{"<Pd>trunc"},
+		},
+		{
+			name:       "content with other tags",
+			input:      "<Ac>1:set:a=<b></Ac>",
+			wantFrames: []string{"<Ac>1:set:a=<b></Ac>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, garbage, err := readFrames(t, strings.NewReader(tt.input), 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(frames, tt.wantFrames) {
+				t.Errorf("frames = %q, want %q", frames, tt.wantFrames)
+			}
+			if !reflect.DeepEqual(garbage, tt.wantGarbage) {
+				t.Errorf("garbage = %q, want %q", garbage, tt.wantGarbage)
+			}
+		})
+	}
+}
+
+func TestFrameReader_PartialReads(t *testing.T) {
+	input := "<Pa>ident;pass;ABCD</Pa>\r\nxx\n<Pd>1700000000;lat:1;0000</Pd>\r\n"
+	frames, garbage, err := readFrames(t, iotest.OneByteReader(strings.NewReader(input)), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"<Pa>ident;pass;ABCD</Pa>", "<Pd>1700000000;lat:1;0000</Pd>"}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("frames = %q, want %q", frames, want)
+	}
+	if !reflect.DeepEqual(garbage, []string{"xx"}) {
+		t.Errorf("garbage = %q", garbage)
+	}
+}
+
+func TestFrameReader_MaxSize(t *testing.T) {
+	input := "<Pr>r</Pr>\n<Pd>" + strings.Repeat("A", 100) + "</Pd>\n"
+	frames, _, err := readFrames(t, strings.NewReader(input), 64)
+	if !errors.Is(err, helpers.ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if !reflect.DeepEqual(frames, []string{"<Pr>r</Pr>"}) {
+		t.Errorf("frames before the oversized one = %q", frames)
+	}
+}
+
+func TestFrameReader_MaxSize_UnterminatedFrame(t *testing.T) {
+	_, _, err := readFrames(t, strings.NewReader("<Pd>"+strings.Repeat("A", 100)), 64)
+	if !errors.Is(err, helpers.ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestFrameReader_LongGarbageIsSkipped(t *testing.T) {
+	input := strings.Repeat("x", 100) + "<Pr>r</Pr>"
+	frames, garbage, err := readFrames(t, strings.NewReader(input), 64)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(frames, []string{"<Pr>r</Pr>"}) {
+		t.Errorf("frames = %q", frames)
+	}
+	// Garbage longer than the limit is reported in chunks
+	if got := strings.Join(garbage, ""); got != strings.Repeat("x", 100) {
+		t.Errorf("garbage = %q", garbage)
+	}
+}
@@ -9,6 +9,8 @@ var packetTag = regexp.MustCompile(`<P[A-Za-z]>`)
 
 // Split splits a string into packets, this function exists due to some
 // issues on TCP that sends multiple packages at the same time
+//
+// Deprecated: Split only recognizes client packets, use FrameScanner or FrameReader instead
 func Split(data string) []string {
 	data = strings.TrimRight(data, "\n\r")
 	locs := packetTag.FindAllStringIndex(data, -1)
@@ -0,0 +1,66 @@
+// Package packets decodes a frame of any packet family by its tag and lets
+// custom tags be registered next to the built-in packets
+package packets
+
+import (
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/registry"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+
+	// The families register their packets on init
+	_ "github.com/goldenm-software/layrz-protocol/go/v3/packets/ai"
+	_ "github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	_ "github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	_ "github.com/goldenm-software/layrz-protocol/go/v3/packets/trips"
+)
+
+// Packet is implemented by every packet of every family
+type Packet = registry.Packet
+
+// DecodeError describes why a frame could not be decoded: the tag, the index, name,
+// raw value and byte offset of the field, and the sentinel error it wraps.
+// Every decode error of the built-in packets is a *DecodeError
+type DecodeError = wire.DecodeError
+
+// Decoder selects the decoding mode of DecodeAnyWith and of the DecodeWith function of every
+// family. In lenient mode, frames with a bad CRC or extra trailing fields are decoded and each
+// problem is added to Warnings. A nil Decoder decodes in strict mode
+type Decoder = wire.Decoder
+
+var (
+	// ErrInvalidCrc is wrapped by the errors of frames whose CRC does not match the content
+	ErrInvalidCrc = wire.ErrInvalidCrc
+
+	// ErrInvalidPacket is wrapped by the errors of frames with an unknown tag,
+	// or that are not enclosed by the tags of the packet
+	ErrInvalidPacket = wire.ErrInvalidPacket
+
+	// ErrUnknownTag is the cause of the errors of frames whose tag is not registered,
+	// these errors also wrap ErrInvalidPacket
+	ErrUnknownTag = wire.ErrUnknownTag
+
+	// ErrFieldCount is wrapped by the errors of frames with missing or extra fields
+	ErrFieldCount = wire.ErrFieldCount
+
+	// ErrInvalidField is wrapped by the errors of fields whose value cannot be parsed
+	ErrInvalidField = wire.ErrInvalidField
+)
+
+// Register adds a custom tag, constructor must return a new empty packet of that tag.
+// The tag must be an uppercase letter followed by a lowercase letter, like "Px",
+// and cannot be one of the registered tags.
+//
+// Custom packets that embed client.Extension are also decoded by client.Decode,
+// so the servers deliver them to their OnNewPacket handlers
+func Register(tag string, constructor func() Packet) error {
+	return registry.Register(tag, constructor)
+}
+
+// DecodeAny decodes a frame of any registered packet, like <Pa>...</Pa> or <Ac>...</Ac>
+func DecodeAny(data []byte) (Packet, error) {
+	return registry.Decode(data, nil)
+}
+
+// DecodeAnyWith is like DecodeAny with the mode of the decoder
+func DecodeAnyWith(data []byte, d *Decoder) (Packet, error) {
+	return registry.DecodeWith(data, nil, d)
+}
@@ -0,0 +1,270 @@
+package packets_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/ai"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/trips"
+)
+
+// PxPacket is a custom packet carrying a single value, <Px>value;CRC</Px>
+type PxPacket struct {
+	client.Extension
+	Value string
+}
+
+func (PxPacket) Tag() string { return "Px" }
+
+func (p *PxPacket) ToPacket() *string {
+	content := p.Value + ";"
+	packet := fmt.Sprintf("<Px>%s%04X</Px>", content, wire.Calculate([]byte(content)))
+	return &packet
+}
+
+func (p *PxPacket) FromPacket(raw *string) error {
+	body := strings.TrimSuffix(strings.TrimPrefix(*raw, "<Px>"), "</Px>")
+	value, crc, ok := strings.Cut(body, ";")
+	if !ok || crc != fmt.Sprintf("%04X", wire.Calculate([]byte(value+";"))) {
+		return wire.ErrInvalidCrc
+	}
+	p.Value = value
+	return nil
+}
+
+func init() {
+	if err := packets.Register("Px", func() packets.Packet { return &PxPacket{} }); err != nil {
+		panic(err)
+	}
+}
+
+func TestDecodeAny_AllFamilies(t *testing.T) {
+	message := "OK"
+	tests := []packets.Packet{
+		&client.PrPacket{},
+		&client.PcPacket{Timestamp: time.Unix(1700000000, 0), CommandId: 1, Message: &message},
+		&server.AsPacket{},
+		&server.ArPacket{Reason: "error"},
+		&trips.TsPacket{TripId: "12345678-1234-1234-1234-123456789012", Timestamp: time.Unix(1700000000, 0)},
+		&ai.ImPacket{ChatId: "12345678-1234-1234-1234-123456789012", Timestamp: time.Unix(1700000000, 0), Message: "hi"},
+	}
+
+	for _, packet := range tests {
+		t.Run(packet.Tag(), func(t *testing.T) {
+			encoded := *packet.ToPacket()
+			decoded, err := packets.DecodeAny([]byte(encoded))
+			if err != nil {
+				t.Fatalf("DecodeAny failed: %v", err)
+			}
+			if decoded.Tag() != packet.Tag() {
+				t.Errorf("expected tag %s, got %s", packet.Tag(), decoded.Tag())
+			}
+			if *decoded.ToPacket() != encoded {
+				t.Errorf("round-trip mismatch: got %s, want %s", *decoded.ToPacket(), encoded)
+			}
+		})
+	}
+}
+
+// Returns a packet of every built-in tag with every field set
+func builtinPackets() []packets.Packet {
+	message, name, ident, password := "OK", "reboot", "867000000000000", "secret"
+	mac, model := "AA:BB:CC:DD:EE:FF", "GENERIC"
+	filename, contentType, image := "photo.jpg", "image/jpeg", []byte{0xFF, 0xD8}
+	lat, lng := 10.5, -66.9
+	timestamp := time.Unix(1700000000, 0)
+	return []packets.Packet{
+		&client.PaPacket{Ident: &ident, Password: &password},
+		&client.PbPacket{Advertisements: &[]definitions.BleAdvertisement{{
+			MacAddress: mac, Timestamp: timestamp, Latitude: &lat, Longitude: &lng, Model: model, Rssi: -60, TxPower: 4,
+			ManufacturerData: []definitions.BleManufacturerData{{CompanyId: 0x004C, Data: []byte{1, 2}}},
+			ServiceData:      []definitions.BleServiceData{{Uuid: 0xFEAA, Data: []byte{3}}},
+		}}},
+		&client.PcPacket{Timestamp: timestamp, CommandId: 1, Message: &message},
+		&client.PdPacket{Timestamp: timestamp, Position: &definitions.Position{Latitude: &lat, Longitude: &lng},
+			ExtraData: map[string]any{"gpio.1.digital.input": true, "ble.0.mac.address": mac}},
+		&client.PiPacket{Ident: ident, FirmwareId: "fw", FirmwareBuild: 1, DeviceId: 2, HardwareId: 3, ModelId: 4,
+			FirmwareBranch: definitions.Stable},
+		&client.PmPacket{Filename: &filename, ContentType: &contentType, Data: &image},
+		&client.PrPacket{},
+		&client.PsPacket{Timestamp: timestamp, Params: map[string]any{"report.code": 1}},
+		&server.AbPacket{Devices: &[]definitions.BleData{{MacAddress: &mac, Model: &model}}},
+		&server.AcPacket{Commands: []definitions.CommandDefinition{{CommandId: 1, CommandName: &name, Args: map[string]any{"delay": 5}}}},
+		&server.AoPacket{Timestamp: timestamp},
+		&server.ArPacket{Reason: "error"},
+		&server.AsPacket{},
+		&trips.TsPacket{TripId: "12345678-1234-1234-1234-123456789012", Timestamp: timestamp},
+		&trips.TePacket{TripId: "12345678-1234-1234-1234-123456789012", Timestamp: timestamp, DistanceTraveled: 1.5, MaxSpeed: 80, Duration: time.Minute},
+		&ai.ImPacket{ChatId: "12345678-1234-1234-1234-123456789012", Timestamp: timestamp, Message: "a;b"},
+	}
+}
+
+func TestFromPacket_DoesNotModifyRaw(t *testing.T) {
+	for _, packet := range builtinPackets() {
+		t.Run(packet.Tag(), func(t *testing.T) {
+			encoded := *packet.ToPacket()
+			raw := encoded
+
+			decoded, err := packets.DecodeAny([]byte(encoded))
+			if err != nil {
+				t.Fatalf("DecodeAny failed: %v", err)
+			}
+			if err := decoded.FromPacket(&raw); err != nil {
+				t.Fatalf("FromPacket failed: %v", err)
+			}
+			if raw != encoded {
+				t.Errorf("raw was modified: got %q, want %q", raw, encoded)
+			}
+		})
+	}
+}
+
+func TestDecodeAny_DoesNotRetainData(t *testing.T) {
+	for _, packet := range builtinPackets() {
+		t.Run(packet.Tag(), func(t *testing.T) {
+			encoded := *packet.ToPacket()
+			data := []byte(encoded)
+
+			decoded, err := packets.DecodeAny(data)
+			if err != nil {
+				t.Fatalf("DecodeAny failed: %v", err)
+			}
+			if string(data) != encoded {
+				t.Errorf("data was modified: got %q, want %q", data, encoded)
+			}
+
+			// The servers reuse their read buffer once the frame is decoded
+			for i := range data {
+				data[i] = 'x'
+			}
+			if *decoded.ToPacket() != encoded {
+				t.Errorf("decoded packet changed with the buffer: got %s, want %s", *decoded.ToPacket(), encoded)
+			}
+		})
+	}
+}
+
+func TestDecodeAny_Invalid(t *testing.T) {
+	for _, input := range []string{"", "garbage", "<Xx>;0000</Xx>", "<Pr>;0000</Ps>", "<pr>;0000</pr>"} {
+		if _, err := packets.DecodeAny([]byte(input)); !errors.Is(err, wire.ErrInvalidPacket) {
+			t.Errorf("DecodeAny(%q): expected ErrInvalidPacket, got %v", input, err)
+		}
+	}
+}
+
+func TestDecodeAny_DecodeError(t *testing.T) {
+	content := "1700000000;12;done;"
+	tests := []struct {
+		name     string
+		input    string
+		sentinel error
+		field    string
+	}{
+		{"bad crc", "<Pc>" + content + "0000</Pc>", packets.ErrInvalidCrc, "crc"},
+		{"missing field", fmt.Sprintf("<Pc>1700000000;12;%04X</Pc>", wire.Calculate([]byte("1700000000;12;"))), packets.ErrFieldCount, ""},
+		{"invalid field", fmt.Sprintf("<Ao>x;%04X</Ao>", wire.Calculate([]byte("x;"))), packets.ErrInvalidField, "timestamp"},
+		{"unknown tag", "<Xx>;0000</Xx>", packets.ErrInvalidPacket, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := packets.DecodeAny([]byte(tt.input))
+
+			var decodeErr *packets.DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("expected a *DecodeError, got %v", err)
+			}
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("expected %v, got %v", tt.sentinel, err)
+			}
+			if decodeErr.Name != tt.field {
+				t.Errorf("expected field %q, got %q", tt.field, decodeErr.Name)
+			}
+		})
+	}
+}
+
+func TestDecodeAnyWith_Lenient(t *testing.T) {
+	input := []byte("<Ar>stale firmware;0000</Ar>")
+
+	if _, err := packets.DecodeAnyWith(input, nil); !errors.Is(err, packets.ErrInvalidCrc) {
+		t.Fatalf("strict: expected ErrInvalidCrc, got %v", err)
+	}
+
+	d := &packets.Decoder{Lenient: true}
+	packet, err := packets.DecodeAnyWith(input, d)
+	if err != nil {
+		t.Fatalf("lenient: %v", err)
+	}
+	if ar, ok := packet.(*server.ArPacket); !ok || ar.Reason != "stale firmware" {
+		t.Errorf("unexpected packet: %#v", packet)
+	}
+	if len(d.Warnings) != 1 || !errors.Is(d.Warnings[0], packets.ErrInvalidCrc) {
+		t.Errorf("expected a CRC warning, got %v", d.Warnings)
+	}
+
+	// Families accept the decoder as well
+	content := "1700000000;12;done;extra;"
+	input = []byte(fmt.Sprintf("<Pc>%s%04X</Pc>", content, wire.Calculate([]byte(content))))
+	d = &packets.Decoder{Lenient: true}
+	if _, err := client.DecodeWith(input, d); err != nil {
+		t.Fatalf("client.DecodeWith: %v", err)
+	}
+	if len(d.Warnings) != 1 || !errors.Is(d.Warnings[0], packets.ErrFieldCount) {
+		t.Errorf("expected a field count warning, got %v", d.Warnings)
+	}
+}
+
+func TestRegister_CustomTag(t *testing.T) {
+	encoded := *(&PxPacket{Value: "custom"}).ToPacket()
+
+	decoded, err := packets.DecodeAny([]byte(encoded))
+	if err != nil {
+		t.Fatalf("DecodeAny failed: %v", err)
+	}
+	if px, ok := decoded.(*PxPacket); !ok || px.Value != "custom" {
+		t.Errorf("unexpected packet: %#v", decoded)
+	}
+
+	// Extensions are client packets too
+	packet, err := client.Decode([]byte(encoded))
+	if err != nil {
+		t.Fatalf("client.Decode failed: %v", err)
+	}
+	if _, ok := packet.(*PxPacket); !ok {
+		t.Errorf("expected *PxPacket, got %T", packet)
+	}
+	if _, err := server.Decode([]byte(encoded)); !errors.Is(err, wire.ErrInvalidPacket) {
+		t.Errorf("server.Decode: expected ErrInvalidPacket, got %v", err)
+	}
+}
+
+func TestRegister_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		tag         string
+		constructor func() packets.Packet
+	}{
+		{"built-in tag", "Pa", func() packets.Packet { return &client.PaPacket{} }},
+		{"already registered", "Px", func() packets.Packet { return &PxPacket{} }},
+		{"invalid tag", "PX", func() packets.Packet { return &PxPacket{} }},
+		{"tag mismatch", "Py", func() packets.Packet { return &PxPacket{} }},
+		{"nil constructor", "Pz", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := packets.Register(tt.tag, tt.constructor); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
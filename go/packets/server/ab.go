@@ -3,7 +3,6 @@ package server
 import (
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
@@ -20,60 +19,57 @@ type AbPacket struct {
 // FromPacket is a method that converts a raw packet to a AbPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *AbPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Ab>") || !strings.HasSuffix(*raw, "</Ab>") {
-		return errors.New("invalid package, should be <Ab>...</Ab>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Ab>")
-	*raw = strings.TrimSuffix(*raw, "</Ab>")
-
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+	return p.decode(*raw, nil)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+// FromFrame converts a frame to a AbPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *AbPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
+func (p *AbPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Ab", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	*raw = strings.TrimSuffix(*raw, ";")
+	parts := f.List(content, offset, "device")
 
-	parts := strings.Split(*raw, ";")
-
-	devices := make([]definitions.BleData, 0)
-
-	for _, part := range parts {
-		subparts := strings.Split(part, ":")
-		if len(subparts) != 2 {
-			return errors.New("invalid device definition")
+	devices := make([]definitions.BleData, 0, parts.Len())
+	for i := range parts.Len() {
+		rawMacAddress, model, ok := strings.Cut(parts.String(i), ":")
+		if !ok || strings.Contains(model, ":") {
+			return parts.Error(i, errors.New("should be <mac address>:<model>"))
+		}
+		if len(rawMacAddress)%2 != 0 {
+			return parts.Error(i, errors.New("invalid mac address"))
 		}
 
-		macAddress := ""
-
-		for j := 0; j < len(subparts[0]); j += 2 {
-			macAddress += subparts[0][j : j+2]
-			if j != len(subparts[0])-2 {
-				macAddress += ":"
+		var mac strings.Builder
+		mac.Grow(len(rawMacAddress) * 3 / 2)
+		for j := 0; j < len(rawMacAddress); j += 2 {
+			if j > 0 {
+				mac.WriteByte(':')
 			}
-
+			mac.WriteString(rawMacAddress[j : j+2])
 		}
+		macAddress := mac.String()
 
 		devices = append(devices, definitions.BleData{
 			MacAddress: &macAddress,
-			Model:      &subparts[1],
+			Model:      &model,
 		})
-
-		p.Devices = &devices
 	}
 
+	p.Devices = &devices
 	return nil
 }
 
@@ -1,9 +1,7 @@
 package server
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
@@ -20,72 +18,45 @@ type AcPacket struct {
 // FromPacket is a method that converts a raw packet to a AcPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *AcPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Ac>") || !strings.HasSuffix(*raw, "</Ac>") {
-		return errors.New("invalid package, should be <Ac>...</Ac>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<Ac>")
-	*raw = strings.TrimSuffix(*raw, "</Ac>")
-
-	rawCrc := (*raw)[len(*raw)-4:]
-	*raw = (*raw)[:len(*raw)-4]
+	return p.decode(*raw, nil)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+// FromFrame converts a frame to a AcPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *AcPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
+func (p *AcPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Ac", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(*raw))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
-	}
-
-	*raw = strings.TrimSuffix(*raw, ";")
-
-	parts := strings.Split(*raw, ";")
-
-	if len(parts) == 0 {
-		return errors.New("invalid package, should contain at least one command")
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	if len(parts)%4 != 0 {
-		return errors.New("invalid package, should contain a multiple of 4 parts")
+	groups, err := f.Groups(content, offset, "command id", "command name", "arguments", "crc")
+	if err != nil {
+		return err
 	}
 
-	commands := make([]definitions.CommandDefinition, 0)
-
-	for i := 0; i < len(parts); i += 4 {
-		rawCommandId := parts[i]
-		rawCommandName := parts[i+1]
-		rawArgs := parts[i+2]
-		rawCrc := parts[i+3]
-
-		receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
-		if err != nil {
-			return errors.New("cannot convert CRC to integer")
-		}
-
-		calculatedCrc := wire.Calculate([]byte(fmt.Sprintf("%s;%s;%s;", rawCommandId, rawCommandName, rawArgs)))
-
-		if calculatedCrc != uint16(receivedCrc) {
-			return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
-		}
-
-		commandId, err := strconv.Atoi(rawCommandId)
+	commands := make([]definitions.CommandDefinition, 0, len(groups))
+	for _, fields := range groups {
+		commandId, err := fields.Int(0)
 		if err != nil {
-			return errors.New("cannot convert command id to integer")
+			return err
 		}
 
-		args := wire.ParseArgs(rawArgs)
-
+		commandName := fields.String(1)
 		commands = append(commands, definitions.CommandDefinition{
 			CommandId:   commandId,
-			CommandName: &rawCommandName,
-			Args:        args,
+			CommandName: &commandName,
+			Args:        wire.ParseArgs(fields.String(2)),
 		})
 	}
 
@@ -103,8 +74,8 @@ func (p *AcPacket) ToPacket() *string {
 	for _, command := range p.Commands {
 		args := make([]string, 0)
 
-		for key, value := range command.Args {
-			args = append(args, fmt.Sprintf("%s:%v", key, value))
+		for _, key := range wire.SortedKeys(command.Args) {
+			args = append(args, fmt.Sprintf("%s:%v", key, command.Args[key]))
 		}
 
 		cmd := fmt.Sprintf(
@@ -1,6 +1,7 @@
 package server_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
@@ -46,3 +47,19 @@ func TestAc_FromPacket_ToPacket(t *testing.T) {
 		})
 	}
 }
+
+func TestAc_ToPacket_Deterministic(t *testing.T) {
+	packet := server.AcPacket{Commands: []definitions.CommandDefinition{
+		{CommandId: 1, CommandName: stringPtr("config"), Args: map[string]any{"z": 1, "a": "x", "m": true}},
+	}}
+
+	first := *packet.ToPacket()
+	if !strings.HasPrefix(first, "<Ac>1;config;a:x,m:true,z:1;") {
+		t.Errorf("ToPacket = %q, want the arguments sorted by key", first)
+	}
+	for range 20 {
+		if got := *packet.ToPacket(); got != first {
+			t.Fatalf("ToPacket changed between calls: %q != %q", got, first)
+		}
+	}
+}
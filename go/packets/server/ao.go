@@ -1,10 +1,8 @@
 package server
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
@@ -20,38 +18,39 @@ type AoPacket struct {
 // FromPacket is a method that converts a raw packet to a AoPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *AoPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Ao>") || !strings.HasSuffix(*raw, "</Ao>") {
-		return errors.New("invalid package, should be <Ao>...</Ao>")
-	}
+	return p.decode(*raw, nil)
+}
 
-	*raw = strings.TrimPrefix(*raw, "<Ao>")
-	*raw = strings.TrimSuffix(*raw, "</Ao>")
+// FromFrame converts a frame to a AoPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *AoPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	parts := strings.Split(*raw, ";")
-	if len(parts) != 2 {
-		return errors.New("invalid package, should have 2 parts")
+func (p *AoPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Ao", d)
+	if err != nil {
+		return err
 	}
 
-	receivedCrc, err := strconv.ParseUint(parts[1], 16, 16)
+	content, offset, err := f.Checksum()
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(fmt.Sprintf("%s;", parts[0])))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	fields, err := f.Fields(content, offset, "timestamp")
+	if err != nil {
+		return err
 	}
 
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := fields.Unix(0)
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
+		return err
 	}
 
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-
+	p.Timestamp = timestamp
 	return nil
 }
 
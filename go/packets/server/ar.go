@@ -1,10 +1,7 @@
 package server
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
@@ -19,32 +16,34 @@ type ArPacket struct {
 // FromPacket is a method that converts a raw packet to a ArPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *ArPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Ar>") || !strings.HasSuffix(*raw, "</Ar>") {
-		return errors.New("invalid package, should be <Ar>...</Ar>")
-	}
+	return p.decode(*raw, nil)
+}
 
-	*raw = strings.TrimPrefix(*raw, "<Ar>")
-	*raw = strings.TrimSuffix(*raw, "</Ar>")
+// FromFrame converts a frame to a ArPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *ArPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	parts := strings.Split(*raw, ";")
-	if len(parts) != 2 {
-		return errors.New("invalid package, should have 2 parts")
+func (p *ArPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Ar", d)
+	if err != nil {
+		return err
 	}
 
-	receivedCrc, err := strconv.ParseUint(parts[1], 16, 16)
+	content, offset, err := f.Checksum()
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(fmt.Sprintf("%s;", parts[0])))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	fields, err := f.Fields(content, offset, "reason")
+	if err != nil {
+		return err
 	}
 
-	p.Reason = parts[0]
+	p.Reason = fields.String(0)
 	return nil
 }
 
@@ -1,10 +1,7 @@
 package server
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
@@ -16,32 +13,30 @@ type AsPacket struct{}
 // FromPacket is a method that converts a raw packet to a AsPacket
 // based on the `Layrz Protocol v2` specification
 //
-// Returns an error if the packet is invalid
+// Returns a DecodeError if the packet is invalid, raw is not modified
 func (p *AsPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<As>") || !strings.HasSuffix(*raw, "</As>") {
-		return errors.New("invalid package, should be <As>...</As>")
-	}
-
-	*raw = strings.TrimPrefix(*raw, "<As>")
-	*raw = strings.TrimSuffix(*raw, "</As>")
+	return p.decode(*raw, nil)
+}
 
-	parts := strings.Split(*raw, ";")
-	if len(parts) != 2 {
-		return errors.New("invalid package, should contain 2 parts")
-	}
+// FromFrame converts a frame to a AsPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained
+func (p *AsPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(parts[1], 16, 16)
+func (p *AsPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "As", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(";"))
-
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	_, err = f.Fields(content, offset)
+	return err
 }
 
 // ToPacket is a method that converts a AsPacket to a raw packet
@@ -1,59 +1,40 @@
 package server
 
 import (
-	"fmt"
-	"strings"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/registry"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
 
-func Decode(dataBytes []byte) (ServerPackets, error) {
-	data := string(dataBytes)
-	if strings.HasPrefix(data, "<Ab>") && strings.HasSuffix(data, "</Ab>") {
-		packet := &AbPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Ac>") && strings.HasSuffix(data, "</Ac>") {
-		packet := &AcPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Ao>") && strings.HasSuffix(data, "</Ao>") {
-		packet := &AoPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
-
-	if strings.HasPrefix(data, "<Ar>") && strings.HasSuffix(data, "</Ar>") {
-		packet := &ArPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
-	}
+func init() {
+	registry.MustRegister("Ab", func() registry.Packet { return &AbPacket{} })
+	registry.MustRegister("Ac", func() registry.Packet { return &AcPacket{} })
+	registry.MustRegister("Ao", func() registry.Packet { return &AoPacket{} })
+	registry.MustRegister("Ar", func() registry.Packet { return &ArPacket{} })
+	registry.MustRegister("As", func() registry.Packet { return &AsPacket{} })
+	registry.MustRegister("Au", func() registry.Packet { return &AuPacket{} }) //nolint:staticcheck
+}
 
-	if strings.HasPrefix(data, "<As>") && strings.HasSuffix(data, "</As>") {
-		packet := &AsPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+// Decode decodes a frame of any server packet, including the packets of other families that
+// embed Extension. Frames of other families are rejected as invalid packets
+func Decode(dataBytes []byte) (ServerPackets, error) {
+	packet, err := registry.Decode(dataBytes, accepts)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(ServerPackets), nil
+}
 
-	if strings.HasPrefix(data, "<Au>") && strings.HasSuffix(data, "</Au>") {
-		packet := &AuPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+// DecodeWith is like Decode with the mode of the decoder, see packets.Decoder
+func DecodeWith(dataBytes []byte, d *wire.Decoder) (ServerPackets, error) {
+	packet, err := registry.DecodeWith(dataBytes, accepts, d)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(ServerPackets), nil
+}
 
-	return nil, fmt.Errorf("invalid packet: %s", data)
+// Returns true if the registered packet belongs to this family
+func accepts(packet registry.Packet) bool {
+	_, ok := packet.(ServerPackets)
+	return ok
 }
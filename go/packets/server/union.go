@@ -7,7 +7,23 @@ func (ArPacket) isServerPacket() {}
 func (AsPacket) isServerPacket() {}
 func (AuPacket) isServerPacket() {}
 
+func (AbPacket) Tag() string { return "Ab" }
+func (AcPacket) Tag() string { return "Ac" }
+func (AoPacket) Tag() string { return "Ao" }
+func (ArPacket) Tag() string { return "Ar" }
+func (AsPacket) Tag() string { return "As" }
+func (AuPacket) Tag() string { return "Au" }
+
+// ServerPackets are the packets a server sends to the devices, the packets of this package
+// and the packets of other families that embed Extension, like trips.TsPacket or ai.ImPacket
 type ServerPackets interface {
 	isServerPacket()
+	Tag() string
 	ToPacket() *string
 }
+
+// Extension is embedded by the packets of other families that a server also sends to
+// the devices, so they are decoded by Decode as ServerPackets
+type Extension struct{}
+
+func (Extension) isServerPacket() {}
@@ -10,3 +10,19 @@ func TestServerPackets_MarkerMethods(t *testing.T) {
 	AsPacket{}.isServerPacket()
 	AuPacket{}.isServerPacket()
 }
+
+func TestServerPackets_Tags(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{AbPacket{}.Tag(), "Ab"},
+		{AcPacket{}.Tag(), "Ac"},
+		{AoPacket{}.Tag(), "Ao"},
+		{ArPacket{}.Tag(), "Ar"},
+		{AsPacket{}.Tag(), "As"},
+		{AuPacket{}.Tag(), "Au"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Tag() = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
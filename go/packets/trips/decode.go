@@ -1,27 +1,35 @@
 package trips
 
 import (
-	"fmt"
-	"strings"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/registry"
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 )
 
+func init() {
+	registry.MustRegister("Te", func() registry.Packet { return &TePacket{} })
+	registry.MustRegister("Ts", func() registry.Packet { return &TsPacket{} })
+}
+
+// Decode decodes a frame of any trips packet, frames of other families are rejected as invalid packets
 func Decode(dataBytes []byte) (TripsPackets, error) {
-	data := string(dataBytes)
-	if strings.HasPrefix(data, "<Te>") && strings.HasSuffix(data, "</Te>") {
-		packet := &TePacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+	packet, err := registry.Decode(dataBytes, accepts)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(TripsPackets), nil
+}
 
-	if strings.HasPrefix(data, "<Ts>") && strings.HasSuffix(data, "</Ts>") {
-		packet := &TsPacket{}
-		if err := packet.FromPacket(&data); err != nil {
-			return nil, err
-		}
-		return packet, nil
+// DecodeWith is like Decode with the mode of the decoder, see packets.Decoder
+func DecodeWith(dataBytes []byte, d *wire.Decoder) (TripsPackets, error) {
+	packet, err := registry.DecodeWith(dataBytes, accepts, d)
+	if err != nil {
+		return nil, err
 	}
+	return packet.(TripsPackets), nil
+}
 
-	return nil, fmt.Errorf("invalid packet: %s", data)
+// Returns true if the registered packet belongs to this family
+func accepts(packet registry.Packet) bool {
+	_, ok := packet.(TripsPackets)
+	return ok
 }
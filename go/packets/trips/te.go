@@ -1,17 +1,18 @@
 package trips
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
 // TePacket is the Trip End packet sent between Layrz services to identify trips.
 type TePacket struct {
+	// Makes it a server.ServerPackets, the servers send it to the devices
+	server.Extension
+
 	// Timestamp of the packet
 	Timestamp time.Time
 
@@ -29,57 +30,55 @@ type TePacket struct {
 }
 
 // FromPacket converts a raw <Te>...</Te> string to a TePacket.
+// Returns a DecodeError if the packet is invalid, raw is not modified.
 func (p *TePacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Te>") || !strings.HasSuffix(*raw, "</Te>") {
-		return errors.New("invalid packet, should be <Te>...</Te>")
-	}
-
-	body := (*raw)[4 : len(*raw)-5]
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := body[len(body)-4:]
-	body = body[:len(body)-4]
+// FromFrame converts a frame to a TePacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained.
+func (p *TePacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *TePacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Te", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(body))
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	body = strings.TrimSuffix(body, ";")
-	parts := strings.Split(body, ";")
-	if len(parts) != 5 {
-		return errors.New("invalid packet, should have 5 parts")
+	fields, err := f.Fields(content, offset, "timestamp", "trip id", "distance traveled", "max speed", "duration")
+	if err != nil {
+		return err
 	}
 
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := fields.Unix(0)
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
+		return err
 	}
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-	p.TripId = parts[1]
-
-	distanceTraveled, err := strconv.ParseFloat(parts[2], 64)
+	distanceTraveled, err := fields.Float(2)
 	if err != nil {
-		return errors.New("cannot convert distance_traveled to float")
+		return err
 	}
-	p.DistanceTraveled = distanceTraveled
-
-	maxSpeed, err := strconv.ParseFloat(parts[3], 64)
+	maxSpeed, err := fields.Float(3)
 	if err != nil {
-		return errors.New("cannot convert max_speed to float")
+		return err
 	}
-	p.MaxSpeed = maxSpeed
-
-	durationSecs, err := strconv.ParseInt(parts[4], 10, 64)
+	duration, err := fields.Seconds(4)
 	if err != nil {
-		return errors.New("cannot convert duration to integer")
+		return err
 	}
-	p.Duration = time.Duration(durationSecs) * time.Second
 
+	p.Timestamp = timestamp
+	p.TripId = fields.String(1)
+	p.DistanceTraveled = distanceTraveled
+	p.MaxSpeed = maxSpeed
+	p.Duration = duration
 	return nil
 }
 
@@ -1,17 +1,18 @@
 package trips
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
 // TsPacket is the Trip Start packet sent between Layrz services to identify trips.
 type TsPacket struct {
+	// Makes it a server.ServerPackets, the servers send it to the devices
+	server.Extension
+
 	// Timestamp of the packet
 	Timestamp time.Time
 
@@ -20,39 +21,40 @@ type TsPacket struct {
 }
 
 // FromPacket converts a raw <Ts>...</Ts> string to a TsPacket.
+// Returns a DecodeError if the packet is invalid, raw is not modified.
 func (p *TsPacket) FromPacket(raw *string) error {
-	if !strings.HasPrefix(*raw, "<Ts>") || !strings.HasSuffix(*raw, "</Ts>") {
-		return errors.New("invalid packet, should be <Ts>...</Ts>")
-	}
-
-	body := (*raw)[4 : len(*raw)-5]
+	return p.decode(*raw, nil)
+}
 
-	rawCrc := body[len(body)-4:]
-	body = body[:len(body)-4]
+// FromFrame converts a frame to a TsPacket with the mode of the decoder,
+// a nil decoder decodes in strict mode. data is copied once, it is neither modified nor retained.
+func (p *TsPacket) FromFrame(data []byte, d *wire.Decoder) error {
+	return p.decode(string(data), d)
+}
 
-	receivedCrc, err := strconv.ParseUint(rawCrc, 16, 16)
+func (p *TsPacket) decode(raw string, d *wire.Decoder) error {
+	f, err := wire.OpenFrame(raw, "Ts", d)
 	if err != nil {
-		return errors.New("cannot convert CRC to integer")
+		return err
 	}
 
-	calculatedCrc := wire.Calculate([]byte(body))
-	if calculatedCrc != uint16(receivedCrc) {
-		return fmt.Errorf("invalid CRC, received: %04X, calculated: %04X", receivedCrc, calculatedCrc)
+	content, offset, err := f.Checksum()
+	if err != nil {
+		return err
 	}
 
-	body = strings.TrimSuffix(body, ";")
-	parts := strings.Split(body, ";")
-	if len(parts) != 2 {
-		return errors.New("invalid packet, should have 2 parts")
+	fields, err := f.Fields(content, offset, "timestamp", "trip id")
+	if err != nil {
+		return err
 	}
 
-	rawTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := fields.Unix(0)
 	if err != nil {
-		return errors.New("cannot convert timestamp to integer")
+		return err
 	}
-	p.Timestamp = time.Unix(rawTimestamp, 0)
-	p.TripId = parts[1]
 
+	p.Timestamp = timestamp
+	p.TripId = fields.String(1)
 	return nil
 }
 
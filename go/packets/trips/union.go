@@ -3,6 +3,9 @@ package trips
 func (TePacket) isTripsPacket() {}
 func (TsPacket) isTripsPacket() {}
 
+func (TePacket) Tag() string { return "Te" }
+func (TsPacket) Tag() string { return "Ts" }
+
 type TripsPackets interface {
 	isTripsPacket()
 	ToPacket() *string
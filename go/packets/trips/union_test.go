@@ -6,3 +6,15 @@ func TestTripsPackets_MarkerMethods(t *testing.T) {
 	TePacket{}.isTripsPacket()
 	TsPacket{}.isTripsPacket()
 }
+
+func TestTripsPackets_Tags(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{TePacket{}.Tag(), "Te"},
+		{TsPacket{}.Tag(), "Ts"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Tag() = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,22 @@
 package servers
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 )
 
@@ -17,28 +24,93 @@ type HttpConfig struct {
 	// Port to bind the HTTP listener on
 	Port int
 
-	// Called for every POST /v2/message.
+	// Called for every packet of a POST /v2/message, in the order of the body.
 	// Return a non-nil ServerPackets to write the encoded packet as the response body.
 	// Return nil to respond with 204.
 	// Return an error to respond with 500.
+	//
+	// A body with several frames, concatenated or separated by line breaks, is a batch:
+	// every frame is decoded and handled on its own and the response has one line per frame,
+	// in order. The line is the packet returned for the frame, <Ao> when nil is returned,
+	// or <Ar> when the frame cannot be decoded or the handler fails.
 	OnNewPacket func(packet client.ClientPackets, r *http.Request) (server.ServerPackets, error)
 
+	// Maximum size of a POST /v2/message body in bytes, larger bodies are answered with 413.
+	// By default is DefaultMaxBodySize.
+	MaxBodySize int
+
 	// Called for every GET /v2/commands.
 	// ident and passwd are extracted from the LayrzAuth header.
 	// Return nil to respond with 204; non-nil to write the encoded packet.
+	// When nil is returned, or OnPullCommands is nil, the oldest packet queued with
+	// PushCommand is written instead.
 	OnPullCommands func(ident, passwd string, r *http.Request) (server.ServerPackets, error)
 
+	// Time a GET /v2/commands is held waiting for a PushCommand when the device has nothing
+	// queued, the request is answered with 204 once it passes.
+	// If zero, the request is answered straight away.
+	LongPollTimeout time.Duration
+
+	// Maximum number of packets queued with PushCommand for a device.
+	// By default is DefaultMaxQueuedCommands.
+	MaxQueuedCommands int
+
+	// Called for every GET /v2/ble to fetch the BLE whitelist of the device.
+	// Return nil to respond with 204; non-nil to write the encoded <Ab> packet.
+	// If nil, every request is answered with 204.
+	OnPullBle func(ident, passwd string, r *http.Request) (*server.AbPacket, error)
+
+	// Called for every POST /v2/image/{filename}, the body is a base64 data URI.
+	// filename is the last element of the path, like "passwd" for "..%2Fpasswd",
+	// and contentType and data are taken from the data URI.
+	// Return nil to respond with 204; non-nil to write the encoded packet.
+	// If nil, the endpoint responds with 404.
+	OnImage func(ident, filename, contentType string, data []byte, r *http.Request) (server.ServerPackets, error)
+
+	// Maximum size of a decoded image in bytes, larger images are answered with 413.
+	// By default is DefaultMaxImageSize.
+	MaxImageSize int
+
 	// Called to authenticate every request.
 	// If nil, all requests are allowed.
 	OnAuthenticate func(ident, passwd string, r *http.Request) bool
 
 	// Called when a packet cannot be decoded; parallel to TcpConfig.OnDecodeError.
 	OnDecodeError func(err error, data []byte, r *http.Request)
+
+	// Decodes in lenient mode; parallel to TcpConfig.LenientDecoding.
+	LenientDecoding bool
+	// Called for every problem accepted by LenientDecoding; parallel to TcpConfig.OnDecodeWarning.
+	OnDecodeWarning func(err error, data []byte, r *http.Request)
+
+	// Called on the first authenticated request of a device,
+	// and again when it comes back after OnLastSeen; parallel to TcpConfig.OnConnect.
+	OnFirstSeen func(ident string, r *http.Request)
+
+	// Called when a device made no requests within PresenceTimeout,
+	// lastSeen is the time of its last request; parallel to TcpConfig.OnDisconnect.
+	OnLastSeen func(ident string, lastSeen time.Time)
+
+	// Time without requests after which a device is considered gone.
+	// By default is DefaultPresenceTimeout.
+	PresenceTimeout time.Duration
 }
 
+const (
+	// DefaultMaxImageSize is the image size limit used when HttpConfig.MaxImageSize is not set
+	DefaultMaxImageSize = 10 << 20
+	// DefaultMaxBodySize is the message body limit used when HttpConfig.MaxBodySize is not set
+	DefaultMaxBodySize = 16 << 20
+)
+
+// Reported to OnDecodeError for the content of a batch that is not a frame
+var errInvalidFrame = errors.New("invalid frame")
+
 type HttpServer struct {
-	config *HttpConfig
-	srv    *http.Server
+	config   *HttpConfig
+	srv      *http.Server
+	presence presence
+	mailbox  mailbox
 }
 
 // NewHttp creates a new HTTP server with the given configuration.
@@ -57,10 +129,32 @@ func NewHttp(cfg *HttpConfig) (*HttpServer, error) {
 		}
 	}
 
+	if cfg.OnDecodeWarning == nil {
+		cfg.OnDecodeWarning = func(err error, data []byte, r *http.Request) {
+			log.Printf("Accepted packet with warning: %s Data: %s", err.Error(), string(data))
+		}
+	}
+
 	if cfg.Port <= 0 || cfg.Port >= 65535 {
 		return nil, fmt.Errorf("port is not valid")
 	}
 
+	if cfg.PresenceTimeout <= 0 {
+		cfg.PresenceTimeout = DefaultPresenceTimeout
+	}
+
+	if cfg.MaxImageSize <= 0 {
+		cfg.MaxImageSize = DefaultMaxImageSize
+	}
+
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = DefaultMaxBodySize
+	}
+
+	if cfg.MaxQueuedCommands <= 0 {
+		cfg.MaxQueuedCommands = DefaultMaxQueuedCommands
+	}
+
 	return &HttpServer{config: cfg}, nil
 }
 
@@ -70,10 +164,26 @@ func (s *HttpServer) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v2/message", s.handleMessage)
 	mux.HandleFunc("/v2/commands", s.handleCommands)
+	mux.HandleFunc("/v2/commands/stream", s.handleCommandStream)
+	mux.HandleFunc("/v2/ble", s.handleBle)
+	mux.HandleFunc("/v2/image/{filename}", s.handleImage)
+
+	// Held requests and event streams end when the server shuts down
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
 	s.srv = &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.config.Port),
-		Handler: mux,
+		Addr:        fmt.Sprintf(":%d", s.config.Port),
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	s.srv.RegisterOnShutdown(cancelRequests)
+
+	// Without the sweep, a device would be first seen only once and tracked forever
+	if s.config.OnFirstSeen != nil || s.config.OnLastSeen != nil {
+		sweepCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.sweepPresence(sweepCtx)
 	}
 
 	errCh := make(chan error, 1)
@@ -108,25 +218,35 @@ func (s *HttpServer) handleMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ident, passwd, ok := parseLayrzAuth(r.Header.Get("Authorization"))
-	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	if _, _, ok := s.authorize(w, r); !ok {
 		return
 	}
 
-	if s.config.OnAuthenticate != nil && !s.config.OnAuthenticate(ident, passwd, r) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.config.MaxBodySize))
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "failed to read body", http.StatusBadRequest)
 		return
 	}
 
-	packet, err := client.Decode(data)
+	frames := splitFrames(data)
+	if len(frames) > 1 {
+		s.handleBatch(w, r, frames)
+		return
+	}
+
+	// A body with a single frame keeps the plain responses
+	frame := data
+	if len(frames) == 1 && frames[0].err == nil {
+		frame = frames[0].data
+	}
+
+	packet, err := s.decode(frame, r)
 	if err != nil {
 		s.config.OnDecodeError(err, data, r)
 		http.Error(w, "invalid packet", http.StatusBadRequest)
@@ -140,14 +260,59 @@ func (s *HttpServer) handleMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if response == nil {
-		w.WriteHeader(http.StatusNoContent)
-		return
+	_ = writePacket(w, response)
+}
+
+// handleBatch handles every frame of the body on its own and writes one line per frame,
+// the packet returned by the handler, <Ao> if it returned nil, or <Ar> if the frame failed
+func (s *HttpServer) handleBatch(w http.ResponseWriter, r *http.Request, frames []bodyFrame) {
+	var out strings.Builder
+	for _, frame := range frames {
+		response := s.handleFrame(frame, r)
+		if response == nil {
+			response = &server.AoPacket{Timestamp: time.Now()}
+		}
+		out.WriteString(*response.ToPacket())
+		out.WriteString("\r\n")
 	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	_, _ = fmt.Fprint(w, *response.ToPacket())
+	_, _ = io.WriteString(w, out.String())
+}
+
+// decode decodes a frame in the mode of the configuration
+func (s *HttpServer) decode(frame []byte, r *http.Request) (client.ClientPackets, error) {
+	if !s.config.LenientDecoding {
+		return client.Decode(frame)
+	}
+
+	d := &wire.Decoder{Lenient: true}
+	packet, err := client.DecodeWith(frame, d)
+	for _, warning := range d.Warnings {
+		s.config.OnDecodeWarning(warning, frame, r)
+	}
+	return packet, err
+}
+
+// handleFrame decodes and handles a frame of a batch, failures are returned as <Ar>
+func (s *HttpServer) handleFrame(frame bodyFrame, r *http.Request) server.ServerPackets {
+	err := frame.err
+	var packet client.ClientPackets
+	if err == nil {
+		packet, err = s.decode(frame.data, r)
+	}
+	if err != nil {
+		s.config.OnDecodeError(err, frame.data, r)
+		return &server.ArPacket{Reason: "invalid packet"}
+	}
+
+	response, err := s.config.OnNewPacket(packet, r)
+	if err != nil {
+		log.Printf("Error in handler callback: %s", err.Error())
+		return &server.ArPacket{Reason: "internal server error"}
+	}
+	return response
 }
 
 func (s *HttpServer) handleCommands(w http.ResponseWriter, r *http.Request) {
@@ -156,37 +321,220 @@ func (s *HttpServer) handleCommands(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ident, passwd, ok := parseLayrzAuth(r.Header.Get("Authorization"))
+	ident, passwd, ok := s.authorize(w, r)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if s.config.OnAuthenticate != nil && !s.config.OnAuthenticate(ident, passwd, r) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	var response server.ServerPackets
+	if s.config.OnPullCommands != nil {
+		var err error
+		response, err = s.config.OnPullCommands(ident, passwd, r)
+		if err != nil {
+			log.Printf("Error in commands callback: %s", err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if response == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), s.config.LongPollTimeout)
+		defer cancel()
+		if response = s.mailbox.wait(ctx, ident); response != nil {
+			// The packet goes back to the mailbox if the device is gone
+			if err := r.Context().Err(); err != nil {
+				s.mailbox.unpop(ident, response)
+				return
+			}
+			if err := writePacket(w, response); err != nil {
+				log.Printf("Error writing the commands of %s: %s", ident, err.Error())
+				s.mailbox.unpop(ident, response)
+			}
+			return
+		}
+	}
+
+	_ = writePacket(w, response)
+}
+
+func (s *HttpServer) handleBle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if s.config.OnPullCommands == nil {
+	ident, passwd, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	if s.config.OnPullBle == nil {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	response, err := s.config.OnPullCommands(ident, passwd, r)
+	response, err := s.config.OnPullBle(ident, passwd, r)
 	if err != nil {
-		log.Printf("Error in commands callback: %s", err.Error())
+		log.Printf("Error in BLE callback: %s", err.Error())
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	// A nil *AbPacket is not a nil ServerPackets
 	if response == nil {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	_ = writePacket(w, response)
+}
+
+func (s *HttpServer) handleImage(w http.ResponseWriter, r *http.Request) {
+	if s.config.OnImage == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, _, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	// An escaped slash in the filename could point outside the storage of the images
+	filename := path.Base(r.PathValue("filename"))
+	if filename == "" || filename == "." || filename == ".." || filename == "/" {
+		http.Error(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	// The base64 encoding grows the image by a third, plus the data URI header
+	r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(s.config.MaxImageSize))+256)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "image too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	contentType, data, err := parseDataURI(body)
+	if err != nil {
+		http.Error(w, "invalid image: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) > s.config.MaxImageSize {
+		http.Error(w, "image too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	response, err := s.config.OnImage(ident, filename, contentType, data, r)
+	if err != nil {
+		log.Printf("Error in image callback: %s", err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	_ = writePacket(w, response)
+}
+
+// authorize authenticates the request with its LayrzAuth header and records the device as seen,
+// responds with 401 and returns false if the request is not authorized
+func (s *HttpServer) authorize(w http.ResponseWriter, r *http.Request) (ident, passwd string, ok bool) {
+	ident, passwd, ok = parseLayrzAuth(r.Header.Get("Authorization"))
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	if s.config.OnAuthenticate != nil && !s.config.OnAuthenticate(ident, passwd, r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	s.seen(ident, r)
+	return ident, passwd, true
+}
+
+// writePacket writes the encoded packet as the response body, or responds with 204 if it is nil.
+// Returns the error of the write, most handlers ignore it since the device sends the request again
+func writePacket(w http.ResponseWriter, response server.ServerPackets) error {
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	_, _ = fmt.Fprint(w, *response.ToPacket())
+	if _, err := fmt.Fprint(w, *response.ToPacket()); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// parseDataURI parses a base64 data URI, "data:<content type>;base64,<data>".
+// The content type is text/plain when the URI does not define one
+func parseDataURI(body []byte) (contentType string, data []byte, err error) {
+	rest, ok := bytes.CutPrefix(bytes.TrimSpace(body), []byte("data:"))
+	if !ok {
+		return "", nil, errors.New("not a data URI")
+	}
+
+	header, payload, ok := bytes.Cut(rest, []byte(","))
+	if !ok {
+		return "", nil, errors.New("missing data")
+	}
+
+	mediaType, ok := strings.CutSuffix(string(header), ";base64")
+	if !ok {
+		return "", nil, errors.New("data URI is not base64 encoded")
+	}
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+
+	data = make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	n, err := base64.StdEncoding.Decode(data, payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return mediaType, data[:n], nil
+}
+
+// A frame of a message body. The garbage between frames, or the rest of the body after a
+// frame over the size limit, is kept with its error so it gets its own result
+type bodyFrame struct {
+	data []byte
+	err  error
+}
+
+// splitFrames splits a message body into its frames and the garbage between them, in order.
+// The line breaks between frames are skipped
+func splitFrames(data []byte) []bodyFrame {
+	var frames []bodyFrame
+	reader := helpers.NewFrameReader(bytes.NewReader(data), helpers.FrameScanner{
+		OnGarbage: func(garbage []byte) {
+			frames = append(frames, bodyFrame{data: bytes.Clone(garbage), err: errInvalidFrame})
+		},
+	})
+
+	for {
+		frame, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if err != nil {
+			// The reader cannot go past a frame over the size limit
+			return append(frames, bodyFrame{err: err})
+		}
+		frames = append(frames, bodyFrame{data: bytes.Clone(frame)})
+	}
 }
 
 // parseLayrzAuth parses "LayrzAuth <ident>;<passwd>" from the Authorization header.
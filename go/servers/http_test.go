@@ -8,9 +8,11 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
@@ -18,6 +20,13 @@ import (
 
 // realHttpServer starts the HttpServer on a free port and returns its base URL and a stop func.
 func realHttpServer(t *testing.T, cfg *servers.HttpConfig) (baseURL string, stop func()) {
+	t.Helper()
+	_, baseURL, stop = startHttpServer(t, cfg)
+	return baseURL, stop
+}
+
+// startHttpServer is realHttpServer that also returns the server.
+func startHttpServer(t *testing.T, cfg *servers.HttpConfig) (srv *servers.HttpServer, baseURL string, stop func()) {
 	t.Helper()
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -27,7 +36,7 @@ func realHttpServer(t *testing.T, cfg *servers.HttpConfig) (baseURL string, stop
 	_ = ln.Close()
 
 	cfg.Port = port
-	srv, err := servers.NewHttp(cfg)
+	srv, err = servers.NewHttp(cfg)
 	if err != nil {
 		t.Fatalf("NewHttp: %v", err)
 	}
@@ -36,7 +45,7 @@ func realHttpServer(t *testing.T, cfg *servers.HttpConfig) (baseURL string, stop
 	go func() { _ = srv.Start(ctx) }()
 	time.Sleep(30 * time.Millisecond)
 
-	return fmt.Sprintf("http://127.0.0.1:%d", port), cancel
+	return srv, fmt.Sprintf("http://127.0.0.1:%d", port), cancel
 }
 
 // --- Constructor validation ---
@@ -283,6 +292,27 @@ func TestHandleMessage_WithResponse(t *testing.T) {
 	}
 }
 
+func TestHandleMessage_TrailingLineBreak(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(p client.ClientPackets, r *http.Request) (server.ServerPackets, error) {
+			return &server.AsPacket{}, nil
+		},
+	})
+	defer stop()
+
+	body := *(&client.PrPacket{}).ToPacket() + "\r\n"
+	req, _ := http.NewRequest(http.MethodPost, url+"/v2/message", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
 // --- handleCommands tests ---
 
 func TestHandleCommands_MethodNotAllowed(t *testing.T) {
@@ -424,3 +454,299 @@ func TestHandleCommands_WithResponse(t *testing.T) {
 		t.Errorf("body mismatch: got %q, want %q", string(respBody), *asPacket.ToPacket())
 	}
 }
+
+// --- /v2/ble ---
+
+// getBle requests the BLE whitelist of ident;pass
+func getBle(t *testing.T, url string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url+"/v2/ble", nil)
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestHandleBle_NilHandler(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+	})
+	defer stop()
+
+	if resp := getBle(t, url); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleBle_NilPacket(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+		OnPullBle: func(ident, passwd string, r *http.Request) (*server.AbPacket, error) {
+			return nil, nil
+		},
+	})
+	defer stop()
+
+	if resp := getBle(t, url); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleBle_WithResponse(t *testing.T) {
+	mac, model := "1234567890AB", "GENERIC"
+	devices := []definitions.BleData{{MacAddress: &mac, Model: &model}}
+	abPacket := &server.AbPacket{Devices: &devices}
+
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+		OnPullBle: func(ident, passwd string, r *http.Request) (*server.AbPacket, error) {
+			if ident != "ident" || passwd != "pass" {
+				t.Errorf("unexpected credentials %q %q", ident, passwd)
+			}
+			return abPacket, nil
+		},
+	})
+	defer stop()
+
+	resp := getBle(t, url)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != *abPacket.ToPacket() {
+		t.Errorf("body = %q, want %q", body, *abPacket.ToPacket())
+	}
+}
+
+func TestHandleBle_MethodNotAllowed(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+	})
+	defer stop()
+
+	resp, err := http.Post(url+"/v2/ble", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", resp.StatusCode)
+	}
+}
+
+// --- /v2/image/{filename} ---
+
+// postImage posts body as the image filename of ident;pass
+func postImage(t *testing.T, url, filename, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url+"/v2/image/"+filename, strings.NewReader(body))
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestHandleImage(t *testing.T) {
+	type image struct {
+		ident, filename, contentType, data string
+	}
+	images := make(chan image, 1)
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+		OnImage: func(ident, filename, contentType string, data []byte, r *http.Request) (server.ServerPackets, error) {
+			images <- image{ident, filename, contentType, string(data)}
+			return nil, nil
+		},
+	})
+	defer stop()
+
+	resp := postImage(t, url, "front_camera_jpg", "data:image/png;base64,aW1hZ2U=")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+
+	want := image{"ident", "front_camera_jpg", "image/png", "image"}
+	if got := <-images; got != want {
+		t.Errorf("OnImage got %+v, want %+v", got, want)
+	}
+}
+
+func TestHandleImage_Errors(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+		OnImage: func(ident, filename, contentType string, data []byte, r *http.Request) (server.ServerPackets, error) {
+			return nil, nil
+		},
+		MaxImageSize: 8,
+	})
+	defer stop()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "not a data URI", body: "aW1hZ2U=", status: http.StatusBadRequest},
+		{name: "not base64", body: "data:image/png,image", status: http.StatusBadRequest},
+		{name: "invalid base64", body: "data:image/png;base64,!!!", status: http.StatusBadRequest},
+		{name: "decoded image too large", body: "data:image/png;base64,aW1hZ2UgdG9vIGxhcmdl", status: http.StatusRequestEntityTooLarge},
+		{name: "body too large", body: "data:image/png;base64," + strings.Repeat("A", 1024), status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := postImage(t, url, "image", tt.body); resp.StatusCode != tt.status {
+				t.Errorf("expected %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandleImage_Filename(t *testing.T) {
+	filenames := make(chan string, 1)
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+		OnImage: func(ident, filename, contentType string, data []byte, r *http.Request) (server.ServerPackets, error) {
+			filenames <- filename
+			return nil, nil
+		},
+	})
+	defer stop()
+
+	tests := []struct {
+		name     string
+		filename string
+		status   int
+		want     string
+	}{
+		{name: "escaped slashes", filename: "..%2F..%2Fpasswd", status: http.StatusNoContent, want: "passwd"},
+		{name: "dot", filename: "%2E", status: http.StatusBadRequest},
+		{name: "dot dot", filename: "%2E%2E", status: http.StatusBadRequest},
+		{name: "parent of a directory", filename: "images%2F..", status: http.StatusBadRequest},
+		{name: "only slashes", filename: "%2F%2F", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := postImage(t, url, tt.filename, "data:image/png;base64,aW1hZ2U=")
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.want != "" {
+				if got := <-filenames; got != tt.want {
+					t.Errorf("expected filename %q, got %q", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleImage_NotConfigured(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+	})
+	defer stop()
+
+	if resp := postImage(t, url, "image", "data:image/png;base64,aW1hZ2U="); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+// --- batches ---
+
+func TestHandleMessage_Batch(t *testing.T) {
+	var mu sync.Mutex
+	var handled []string
+	var decodeErrors int
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(p client.ClientPackets, r *http.Request) (server.ServerPackets, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, fmt.Sprintf("%T", p))
+			switch p.(type) {
+			case *client.PrPacket:
+				return nil, fmt.Errorf("handler error")
+			case *client.PaPacket:
+				return &server.AsPacket{}, nil
+			}
+			return nil, nil
+		},
+		OnDecodeError: func(e error, data []byte, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			decodeErrors++
+		},
+	})
+	defer stop()
+
+	pd := *(&client.PdPacket{Timestamp: time.Unix(1700000000, 0)}).ToPacket()
+	ident, pass := "ident", "pass"
+	pa := *(&client.PaPacket{Ident: &ident, Password: &pass}).ToPacket()
+	pr := *(&client.PrPacket{}).ToPacket()
+	// Concatenated and newline-separated frames, with garbage in the middle
+	body := pd + pd + "\r\ngarbage\n" + pr + "\n" + pa + "\r\n"
+
+	req, _ := http.NewRequest(http.MethodPost, url+"/v2/message", strings.NewReader(body))
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	lines := strings.Split(strings.TrimSpace(string(respBody)), "\r\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 results, got %q", lines)
+	}
+	for i, prefix := range []string{"<Ao>", "<Ao>", "<Ar>", "<Ar>", "<As>"} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("result %d = %q, want %s", i, lines[i], prefix)
+		}
+	}
+	if want := *(&server.ArPacket{Reason: "invalid packet"}).ToPacket(); lines[2] != want {
+		t.Errorf("garbage result = %q, want %q", lines[2], want)
+	}
+	if want := *(&server.ArPacket{Reason: "internal server error"}).ToPacket(); lines[3] != want {
+		t.Errorf("handler error result = %q, want %q", lines[3], want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	wantHandled := "*client.PdPacket,*client.PdPacket,*client.PrPacket,*client.PaPacket"
+	if got := strings.Join(handled, ","); got != wantHandled {
+		t.Errorf("handled %s, want %s", got, wantHandled)
+	}
+	if decodeErrors != 1 {
+		t.Errorf("expected 1 decode error, got %d", decodeErrors)
+	}
+}
+
+func TestHandleMessage_BodyTooLarge(t *testing.T) {
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+		MaxBodySize: 64,
+	})
+	defer stop()
+
+	body := strings.Repeat(*(&client.PrPacket{}).ToPacket(), 10)
+	req, _ := http.NewRequest(http.MethodPost, url+"/v2/message", strings.NewReader(body))
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", resp.StatusCode)
+	}
+}
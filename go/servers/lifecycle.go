@@ -0,0 +1,29 @@
+package servers
+
+import "errors"
+
+var (
+	// ErrClosedByPeer is the close reason of a session closed by the device
+	ErrClosedByPeer = errors.New("connection closed by peer")
+
+	// ErrServerShutdown is the close reason of a session drained by TcpServer.Shutdown
+	ErrServerShutdown = errors.New("server shutdown")
+
+	// ErrServerClosed is the close reason of a session closed by TcpServer.Close,
+	// or force closed when the Shutdown deadline was reached
+	ErrServerClosed = errors.New("server closed")
+
+	// ErrAuthenticationFailed is the close reason of an authenticated session
+	// that sent a <Pa> handshake that failed
+	ErrAuthenticationFailed = errors.New("authentication failed")
+)
+
+// Registers the authenticated session and notifies TcpConfig.OnAuthenticated
+func (s *TcpServer) markAuthenticated(ident string, byCertificate bool, sess *Session) {
+	s.registry.register(ident, sess)
+	sess.authenticate(ident, byCertificate)
+
+	if s.config.OnAuthenticated != nil {
+		s.config.OnAuthenticated(sess)
+	}
+}
@@ -0,0 +1,120 @@
+package servers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// lifecycleEvents collects the lifecycle callbacks of a TcpServer
+type lifecycleEvents struct {
+	connected     chan *servers.Session
+	authenticated chan *servers.Session
+	disconnected  chan error
+}
+
+func newLifecycleConfig(cfg *servers.TcpConfig) (*servers.TcpConfig, *lifecycleEvents) {
+	events := &lifecycleEvents{
+		connected:     make(chan *servers.Session, 4),
+		authenticated: make(chan *servers.Session, 4),
+		disconnected:  make(chan error, 4),
+	}
+	if cfg.OnNewPacket == nil {
+		cfg.OnNewPacket = nopHandler
+	}
+	cfg.OnConnect = func(session *servers.Session) { events.connected <- session }
+	cfg.OnAuthenticated = func(session *servers.Session) { events.authenticated <- session }
+	cfg.OnDisconnect = func(session *servers.Session, reason error) { events.disconnected <- reason }
+	return cfg, events
+}
+
+func (e *lifecycleEvents) waitDisconnect(t *testing.T) error {
+	t.Helper()
+	select {
+	case reason := <-e.disconnected:
+		return reason
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnDisconnect was not called")
+		return nil
+	}
+}
+
+func TestTcpServer_Lifecycle_ClosedByPeer(t *testing.T) {
+	cfg, events := newLifecycleConfig(&servers.TcpConfig{})
+	port, cancel := startTcpServer(t, cfg)
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	select {
+	case session := <-events.connected:
+		if session.Authenticated() {
+			t.Error("expected unauthenticated session on connect")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect was not called")
+	}
+
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	select {
+	case session := <-events.authenticated:
+		if session.Ident() != "device-1" {
+			t.Errorf("unexpected ident: %q", session.Ident())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnAuthenticated was not called")
+	}
+
+	_ = conn.Close()
+	if reason := events.waitDisconnect(t); !errors.Is(reason, servers.ErrClosedByPeer) {
+		t.Errorf("expected ErrClosedByPeer, got %v", reason)
+	}
+}
+
+func TestTcpServer_Lifecycle_IdleTimeout(t *testing.T) {
+	cfg, events := newLifecycleConfig(&servers.TcpConfig{IdleTimeout: 100 * time.Millisecond})
+	port, cancel := startTcpServer(t, cfg)
+	defer cancel()
+
+	_ = dialTcp(t, port)
+	if reason := events.waitDisconnect(t); !errors.Is(reason, servers.ErrIdleTimeout) {
+		t.Errorf("expected ErrIdleTimeout, got %v", reason)
+	}
+}
+
+func TestTcpServer_Lifecycle_Shutdown(t *testing.T) {
+	cfg, events := newLifecycleConfig(&servers.TcpConfig{})
+	srv, port, _ := startShutdownServer(t, cfg)
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	if reason := events.waitDisconnect(t); !errors.Is(reason, servers.ErrServerShutdown) {
+		t.Errorf("expected ErrServerShutdown, got %v", reason)
+	}
+}
+
+func TestTcpServer_Lifecycle_Close(t *testing.T) {
+	cfg, events := newLifecycleConfig(&servers.TcpConfig{})
+	srv, port, _ := startShutdownServer(t, cfg)
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	_ = srv.Close()
+	if reason := events.waitDisconnect(t); !errors.Is(reason, servers.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed, got %v", reason)
+	}
+}
@@ -0,0 +1,84 @@
+package servers
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
+)
+
+const (
+	// DefaultMaxFrameSize is the frame size limit used when TcpConfig.MaxFrameSize is not set
+	DefaultMaxFrameSize = helpers.DefaultMaxFrameSize
+
+	// DefaultWriteTimeout is the write time limit used when TcpConfig.WriteTimeout is not set
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+var (
+	// ErrIdleTimeout is the close reason of a session that sent nothing within TcpConfig.IdleTimeout
+	ErrIdleTimeout = errors.New("idle timeout")
+
+	// ErrHeartbeatTimeout is the close reason of a session that sent no <Pr> heartbeat
+	// within TcpConfig.HeartbeatTimeout
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+	// ErrHandshakeTimeout is the close reason of a session that was not authenticated
+	// within TcpConfig.HandshakeTimeout
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+
+	// ErrFrameTooLarge is the close reason of a session that exceeded TcpConfig.MaxFrameSize
+	// without completing a frame
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrWriteTimeout is the close reason of a session whose device did not take a packet
+	// within TcpConfig.WriteTimeout, like a stalled or half-open connection
+	ErrWriteTimeout = errors.New("write timeout")
+)
+
+// Returns the read deadline of the session based on the configured timeouts,
+// a zero time means no deadline
+func (s *TcpServer) readDeadline(sess *Session) time.Time {
+	var deadline time.Time
+	if s.config.IdleTimeout > 0 {
+		deadline = time.Now().Add(s.config.IdleTimeout)
+	}
+
+	if s.config.HandshakeTimeout > 0 && !sess.Authenticated() {
+		handshake := sess.connectedAt.Add(s.config.HandshakeTimeout)
+		if deadline.IsZero() || handshake.Before(deadline) {
+			deadline = handshake
+		}
+	}
+
+	if last := sess.LastHeartbeat(); s.config.HeartbeatTimeout > 0 && !last.IsZero() {
+		heartbeat := last.Add(s.config.HeartbeatTimeout)
+		if deadline.IsZero() || heartbeat.Before(deadline) {
+			deadline = heartbeat
+		}
+	}
+
+	return deadline
+}
+
+// Classifies a read error caused by a deadline into the close reason of the session,
+// returns nil if the error is not a timeout
+func (s *TcpServer) timeoutReason(err error, sess *Session) error {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return nil
+	}
+
+	if s.config.HandshakeTimeout > 0 && !sess.Authenticated() &&
+		!time.Now().Before(sess.connectedAt.Add(s.config.HandshakeTimeout)) {
+		return ErrHandshakeTimeout
+	}
+
+	if last := sess.LastHeartbeat(); s.config.HeartbeatTimeout > 0 && !last.IsZero() &&
+		!time.Now().Before(last.Add(s.config.HeartbeatTimeout)) {
+		return ErrHeartbeatTimeout
+	}
+
+	return ErrIdleTimeout
+}
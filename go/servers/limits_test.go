@@ -0,0 +1,240 @@
+package servers_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// expectClosed waits until the server closes the connection
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 512)
+	for {
+		_, err := conn.Read(buf)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, io.EOF) && !strings.Contains(err.Error(), "reset") {
+			t.Fatalf("expected the connection to be closed, got %v", err)
+		}
+		return
+	}
+}
+
+// captureSession returns a handler that sends every session it sees to the channel
+func captureSession(sessions chan<- *servers.Session) func(client.ClientPackets, *servers.Session) (server.ServerPackets, error) {
+	return func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+		select {
+		case sessions <- session:
+		default:
+		}
+		return nil, nil
+	}
+}
+
+func TestTcpServer_IdleTimeout(t *testing.T) {
+	idle := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		IdleTimeout: 100 * time.Millisecond,
+		OnNewPacket: nopHandler,
+		OnIdle:      func(session *servers.Session) { idle <- session },
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	expectClosed(t, conn)
+
+	select {
+	case session := <-idle:
+		if session.Ident() != "device-1" {
+			t.Errorf("unexpected session: %q", session.Ident())
+		}
+		if !errors.Is(session.CloseReason(), servers.ErrIdleTimeout) {
+			t.Errorf("expected ErrIdleTimeout, got %v", session.CloseReason())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnIdle was not called")
+	}
+}
+
+func TestTcpServer_IdleTimeout_ResetByTraffic(t *testing.T) {
+	idle := make(chan struct{}, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		IdleTimeout: 150 * time.Millisecond,
+		OnNewPacket: nopHandler,
+		OnIdle:      func(session *servers.Session) { idle <- struct{}{} },
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	for i := 0; i < 6; i++ {
+		writePacket(t, conn, &client.PrPacket{})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case <-idle:
+		t.Error("session went idle while sending packets")
+	default:
+	}
+}
+
+func TestTcpServer_HandshakeTimeout(t *testing.T) {
+	sessions := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		HandshakeTimeout: 100 * time.Millisecond,
+		OnNewPacket:      captureSession(sessions),
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, &client.PrPacket{})
+	session := <-sessions
+
+	expectClosed(t, conn)
+	waitFor(t, func() bool { return session.CloseReason() != nil })
+	if !errors.Is(session.CloseReason(), servers.ErrHandshakeTimeout) {
+		t.Errorf("expected ErrHandshakeTimeout, got %v", session.CloseReason())
+	}
+}
+
+func TestTcpServer_HandshakeTimeout_AuthenticatedSessionStaysOpen(t *testing.T) {
+	sessions := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		HandshakeTimeout: 100 * time.Millisecond,
+		OnNewPacket:      captureSession(sessions),
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	time.Sleep(200 * time.Millisecond)
+	writePacket(t, conn, &client.PrPacket{})
+
+	select {
+	case session := <-sessions:
+		if session.CloseReason() != nil {
+			t.Errorf("expected open session, got %v", session.CloseReason())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("authenticated session was closed by the handshake timeout")
+	}
+}
+
+func TestTcpServer_MaxFrameSize(t *testing.T) {
+	oversized := make(chan int, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		MaxFrameSize: 64,
+		OnNewPacket:  nopHandler,
+		OnOversizedFrame: func(session *servers.Session, size int) {
+			if session.CloseReason() != nil {
+				t.Errorf("close reason set before the callback: %v", session.CloseReason())
+			}
+			oversized <- size
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	if _, err := conn.Write([]byte("<Pd>" + strings.Repeat("A", 100))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case size := <-oversized:
+		if size <= 64 {
+			t.Errorf("expected size above the limit, got %d", size)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnOversizedFrame was not called")
+	}
+	expectClosed(t, conn)
+}
+
+func TestTcpServer_AnswersHeartbeat(t *testing.T) {
+	sessions := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{OnNewPacket: captureSession(sessions)})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, &client.PrPacket{})
+
+	if frame := readFrame(t, conn); !strings.HasPrefix(frame, "<Ao>") {
+		t.Errorf("expected <Ao>, got %q", frame)
+	}
+	if session := <-sessions; session.LastHeartbeat().IsZero() {
+		t.Error("expected the heartbeat to be recorded")
+	}
+}
+
+func TestTcpServer_HeartbeatTimeout(t *testing.T) {
+	timedOut := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		HeartbeatTimeout:   300 * time.Millisecond,
+		OnNewPacket:        nopHandler,
+		OnHeartbeatTimeout: func(session *servers.Session) { timedOut <- session },
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	writePacket(t, conn, &client.PrPacket{})
+	_ = readFrame(t, conn)
+
+	// Other packets do not count as heartbeats
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		writePacket(t, conn, &client.PdPacket{Timestamp: time.Now()})
+	}
+
+	expectClosed(t, conn)
+	select {
+	case session := <-timedOut:
+		if !errors.Is(session.CloseReason(), servers.ErrHeartbeatTimeout) {
+			t.Errorf("expected ErrHeartbeatTimeout, got %v", session.CloseReason())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnHeartbeatTimeout was not called")
+	}
+}
+
+func TestTcpServer_HeartbeatTimeout_NotBeforeFirstHeartbeat(t *testing.T) {
+	sessions := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		HeartbeatTimeout: 50 * time.Millisecond,
+		OnNewPacket:      captureSession(sessions),
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	time.Sleep(150 * time.Millisecond)
+	writePacket(t, conn, &client.PdPacket{Timestamp: time.Now()})
+
+	select {
+	case session := <-sessions:
+		if session.CloseReason() != nil {
+			t.Errorf("expected open session, got %v", session.CloseReason())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session without heartbeats was closed")
+	}
+}
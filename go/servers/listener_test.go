@@ -0,0 +1,275 @@
+package servers_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// testPKI is a throwaway certificate authority with a server and a client certificate
+type testPKI struct {
+	pool   *x509.CertPool
+	server tls.Certificate
+	client tls.Certificate
+}
+
+func newTestPKI(t *testing.T, clientCN string) *testPKI {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Layrz Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatalf("parse CA: %v", err)
+	}
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("create certificate: %v", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return &testPKI{
+		pool:   pool,
+		server: issue(2, "127.0.0.1", x509.ExtKeyUsageServerAuth),
+		client: issue(3, clientCN, x509.ExtKeyUsageClientAuth),
+	}
+}
+
+// startListenerServer starts a TcpServer on a listener bound to a random port and returns its address
+func startListenerServer(t *testing.T, cfg *servers.TcpConfig) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	cfg.Listener = ln
+	srv, err := servers.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	go func() { _ = srv.Start(context.Background()) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String()
+}
+
+func nopHandler(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+	return nil, nil
+}
+
+func TestNew_ListenerWithoutPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	if _, err := servers.New(&servers.TcpConfig{Listener: ln, OnNewPacket: nopHandler}); err != nil {
+		t.Errorf("expected no error with a listener and no port, got %v", err)
+	}
+}
+
+func TestNew_InvalidNetwork(t *testing.T) {
+	_, err := servers.New(&servers.TcpConfig{Port: 9000, Network: "udp", OnNewPacket: nopHandler})
+	if err == nil {
+		t.Error("expected error for network=udp")
+	}
+}
+
+func TestNew_IdentFromCertificateRequiresTLS(t *testing.T) {
+	_, err := servers.New(&servers.TcpConfig{Port: 9000, IdentFromCertificate: true, OnNewPacket: nopHandler})
+	if err == nil {
+		t.Error("expected error for IdentFromCertificate without TLSConfig")
+	}
+}
+
+func TestTcpServer_CustomListener(t *testing.T) {
+	addr := startListenerServer(t, &servers.TcpConfig{OnNewPacket: nopHandler})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	writePacket(t, conn, authPacket("device-1", ""))
+	if got := readFrame(t, conn); got != *(&server.AsPacket{}).ToPacket() {
+		t.Errorf("expected <As>, got %q", got)
+	}
+}
+
+func TestTcpServer_IPv6Only(t *testing.T) {
+	probe, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	_ = probe.Close()
+
+	srv, err := servers.New(&servers.TcpConfig{
+		Port:        port,
+		Address:     "::1",
+		Network:     "tcp6",
+		OnNewPacket: nopHandler,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	go func() { _ = srv.Start(context.Background()) }()
+	defer func() { _ = srv.Close() }()
+	time.Sleep(30 * time.Millisecond)
+
+	conn, err := net.Dial("tcp6", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	writePacket(t, conn, authPacket("device-1", ""))
+	if got := readFrame(t, conn); got != *(&server.AsPacket{}).ToPacket() {
+		t.Errorf("expected <As>, got %q", got)
+	}
+}
+
+func TestTcpServer_TLS(t *testing.T) {
+	pki := newTestPKI(t, "device-1")
+	addr := startListenerServer(t, &servers.TcpConfig{
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{pki.server}},
+		OnNewPacket: nopHandler,
+	})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pki.pool})
+	if err != nil {
+		t.Fatalf("tls dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	writePacket(t, conn, authPacket("device-1", ""))
+	if got := readFrame(t, conn); got != *(&server.AsPacket{}).ToPacket() {
+		t.Errorf("expected <As>, got %q", got)
+	}
+}
+
+func TestTcpServer_MutualTLS_IdentFromCertificate(t *testing.T) {
+	pki := newTestPKI(t, "device-42")
+
+	type seen struct {
+		ident string
+		tls   bool
+	}
+	got := make(chan seen, 1)
+	addr := startListenerServer(t, &servers.TcpConfig{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{pki.server},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pki.pool,
+		},
+		IdentFromCertificate: true,
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			got <- seen{ident: session.Ident(), tls: session.TLS() != nil}
+			return nil, nil
+		},
+	})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		RootCAs:      pki.pool,
+		Certificates: []tls.Certificate{pki.client},
+	})
+	if err != nil {
+		t.Fatalf("tls dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// No <Pa> is sent, the certificate authenticates the session, and a <Pa> cannot replace its ident
+	writePacket(t, conn, authPacket("device-99", ""))
+	if got := readFrame(t, conn); !strings.HasPrefix(got, "<Ar>") {
+		t.Errorf("expected <Ar> for the <Pa>, got %q", got)
+	}
+	writePacket(t, conn, &client.PdPacket{Timestamp: time.Unix(1700000000, 0)})
+
+	select {
+	case s := <-got:
+		if s.ident != "device-42" {
+			t.Errorf("expected ident from certificate, got %q", s.ident)
+		}
+		if !s.tls {
+			t.Error("expected session TLS state")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnNewPacket was not called")
+	}
+}
+
+func TestTcpServer_MutualTLS_RejectsMissingCertificate(t *testing.T) {
+	pki := newTestPKI(t, "device-42")
+	addr := startListenerServer(t, &servers.TcpConfig{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{pki.server},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pki.pool,
+		},
+		OnNewPacket: nopHandler,
+	})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pki.pool})
+	if err != nil {
+		return // rejected during the handshake
+	}
+	defer func() { _ = conn.Close() }()
+
+	// With TLS 1.3 the client learns about the rejection on the first read
+	_, _ = conn.Write([]byte(*authPacket("device-1", "").ToPacket() + "\n"))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Error("expected the connection to be rejected without a client certificate")
+	}
+}
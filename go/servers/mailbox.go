@@ -0,0 +1,230 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+const (
+	// DefaultMaxQueuedCommands is the queue limit used when HttpConfig.MaxQueuedCommands is not set
+	DefaultMaxQueuedCommands = 100
+
+	// Interval of the comments written to an idle event stream, so proxies keep it open
+	streamKeepAliveInterval = 15 * time.Second
+)
+
+// ErrCommandQueueFull is returned by PushCommand when the device has too many undelivered packets
+var ErrCommandQueueFull = errors.New("command queue full")
+
+// mailbox keeps the packets pushed to every HTTP device until a request of the device
+// takes them, through /v2/commands or the event stream. The queue of a device is removed
+// once it is empty, and its waiter once no request waits for it
+type mailbox struct {
+	mu      sync.Mutex
+	queues  map[string][]server.ServerPackets
+	waiters map[string]*waiter
+}
+
+// waiter wakes up the requests waiting for the packets of a device
+type waiter struct {
+	// Closed on the next push for the device
+	ready chan struct{}
+	// Number of requests waiting
+	count int
+}
+
+// push queues a packet for the device and wakes up the requests waiting for it
+func (m *mailbox) push(ident string, packet server.ServerPackets, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.queues[ident]) >= limit {
+		return ErrCommandQueueFull
+	}
+
+	if m.queues == nil {
+		m.queues = make(map[string][]server.ServerPackets)
+	}
+	m.queues[ident] = append(m.queues[ident], packet)
+	m.wakeLocked(ident)
+	return nil
+}
+
+// Wakes up the requests waiting for the device, the caller must hold the lock
+func (m *mailbox) wakeLocked(ident string) {
+	if w, ok := m.waiters[ident]; ok {
+		close(w.ready)
+		delete(m.waiters, ident)
+	}
+}
+
+// pop takes the oldest packet of the device. If there is none, it returns a channel
+// closed on the next push for the device, the caller must call leave if it stops waiting
+// before the channel is closed
+func (m *mailbox) pop(ident string) (server.ServerPackets, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if queue := m.queues[ident]; len(queue) > 0 {
+		packet := queue[0]
+		queue[0] = nil
+		if len(queue) == 1 {
+			delete(m.queues, ident)
+		} else {
+			m.queues[ident] = queue[1:]
+		}
+		return packet, nil
+	}
+
+	if m.waiters == nil {
+		m.waiters = make(map[string]*waiter)
+	}
+	w, ok := m.waiters[ident]
+	if !ok {
+		w = &waiter{ready: make(chan struct{})}
+		m.waiters[ident] = w
+	}
+	w.count++
+	return nil, w.ready
+}
+
+// leave stops waiting on the channel returned by pop, the waiter of the device is removed
+// once no request waits for it
+func (m *mailbox) leave(ident string, ready <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A closed channel was already removed by push
+	if w, ok := m.waiters[ident]; ok && w.ready == ready {
+		if w.count--; w.count == 0 {
+			delete(m.waiters, ident)
+		}
+	}
+}
+
+// unpop puts back a packet taken by pop that could not be delivered, as the oldest packet
+// of the device. The limit is not checked, the packet was already queued
+func (m *mailbox) unpop(ident string, packet server.ServerPackets) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.queues == nil {
+		m.queues = make(map[string][]server.ServerPackets)
+	}
+	m.queues[ident] = append([]server.ServerPackets{packet}, m.queues[ident]...)
+	m.wakeLocked(ident)
+}
+
+// wait takes the oldest packet of the device, waiting for one to be pushed until ctx is done.
+// Returns nil once ctx is done
+func (m *mailbox) wait(ctx context.Context, ident string) server.ServerPackets {
+	for {
+		packet, ready := m.pop(ident)
+		if packet != nil {
+			return packet
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			m.leave(ident, ready)
+			return nil
+		}
+	}
+}
+
+// PushCommand queues a <Ac> or <Ab> packet for the HTTP device with the given ident.
+// The packet is delivered by the next GET /v2/commands of the device, waking up a request
+// held by the long-poll, or written to its event stream. A packet that could not be written
+// is queued again, so it may be delivered twice if the device received part of it.
+// Returns ErrCommandQueueFull if the device already has MaxQueuedCommands undelivered packets
+func (s *HttpServer) PushCommand(ident string, packet server.ServerPackets) error {
+	switch p := packet.(type) {
+	case *server.AcPacket:
+		if p == nil {
+			return errors.New("packet is nil")
+		}
+	case *server.AbPacket:
+		if p == nil {
+			return errors.New("packet is nil")
+		}
+	default:
+		return fmt.Errorf("unsupported packet %T, should be <Ac> or <Ab>", packet)
+	}
+
+	return s.mailbox.push(ident, packet, s.config.MaxQueuedCommands)
+}
+
+// handleCommandStream streams the packets pushed to the device as Server-Sent Events,
+// one event per packet, named after its packet type, until the device disconnects
+func (s *HttpServer) handleCommandStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, _, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	rc := http.NewResponseController(w)
+	for {
+		packet, ready := s.mailbox.pop(ident)
+		if packet != nil {
+			if err := writeEvent(w, rc, r, packet); err != nil {
+				log.Printf("Error writing the event stream of %s: %s", ident, err.Error())
+				s.mailbox.unpop(ident, packet)
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ready:
+		case <-keepAlive.C:
+			s.mailbox.leave(ident, ready)
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			s.mailbox.leave(ident, ready)
+			return
+		}
+	}
+}
+
+// Writes the packet as an event of the stream, fails if the device already disconnected
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, r *http.Request, packet server.ServerPackets) error {
+	if err := r.Context().Err(); err != nil {
+		return err
+	}
+
+	frame := *packet.ToPacket()
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", frame[1:3], frame); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
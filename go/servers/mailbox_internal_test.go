@@ -0,0 +1,54 @@
+package servers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+func TestMailbox_RemovesIdleDevices(t *testing.T) {
+	var m mailbox
+
+	// Requests of many devices that give up without receiving anything
+	for _, ident := range []string{"device-1", "device-2", "device-3"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if packet := m.wait(ctx, ident); packet != nil {
+			t.Errorf("expected no packet, got %T", packet)
+		}
+		cancel()
+	}
+
+	// A delivered packet leaves no queue behind
+	if err := m.push("device-4", &server.AcPacket{}, 10); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if packet := m.wait(context.Background(), "device-4"); packet == nil {
+		t.Fatal("expected the pushed packet")
+	}
+
+	if len(m.waiters) != 0 || len(m.queues) != 0 {
+		t.Errorf("expected no entries left, got %d waiters and %d queues", len(m.waiters), len(m.queues))
+	}
+}
+
+func TestMailbox_LeaveKeepsOtherWaiters(t *testing.T) {
+	var m mailbox
+
+	_, first := m.pop("device-1")
+	_, second := m.pop("device-1")
+	m.leave("device-1", first)
+
+	if err := m.push("device-1", &server.AcPacket{}, 10); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	select {
+	case <-second:
+	default:
+		t.Error("expected the remaining waiter to be woken up")
+	}
+	if len(m.waiters) != 0 {
+		t.Errorf("expected no waiters left, got %d", len(m.waiters))
+	}
+}
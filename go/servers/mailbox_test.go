@@ -0,0 +1,251 @@
+package servers_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+func commandsConfig() *servers.HttpConfig {
+	return &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) { return nil, nil },
+	}
+}
+
+func acPacket(id int) *server.AcPacket {
+	name := "ping"
+	return &server.AcPacket{Commands: []definitions.CommandDefinition{{CommandId: id, CommandName: &name}}}
+}
+
+// getCommands requests /v2/commands for ident;pass, returns the status and the body
+func getCommands(t *testing.T, url string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url+"/v2/commands", nil)
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("request failed: %v", err)
+		return 0, ""
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestPushCommand_Errors(t *testing.T) {
+	cfg := commandsConfig()
+	cfg.MaxQueuedCommands = 1
+	srv, _, stop := startHttpServer(t, cfg)
+	defer stop()
+
+	if err := srv.PushCommand("ident", &server.AoPacket{}); err == nil {
+		t.Error("expected error for an <Ao> packet")
+	}
+	if err := srv.PushCommand("ident", (*server.AcPacket)(nil)); err == nil {
+		t.Error("expected error for a nil packet")
+	}
+	if err := srv.PushCommand("ident", acPacket(1)); err != nil {
+		t.Fatalf("PushCommand failed: %v", err)
+	}
+	if err := srv.PushCommand("ident", acPacket(2)); !errors.Is(err, servers.ErrCommandQueueFull) {
+		t.Errorf("expected ErrCommandQueueFull, got %v", err)
+	}
+	if err := srv.PushCommand("other", acPacket(2)); err != nil {
+		t.Errorf("expected the queue of another device to be free, got %v", err)
+	}
+}
+
+func TestHandleCommands_PushedCommand(t *testing.T) {
+	srv, url, stop := startHttpServer(t, commandsConfig())
+	defer stop()
+
+	first, second := acPacket(1), acPacket(2)
+	for _, packet := range []*server.AcPacket{first, second} {
+		if err := srv.PushCommand("ident", packet); err != nil {
+			t.Fatalf("PushCommand failed: %v", err)
+		}
+	}
+
+	for _, want := range []*server.AcPacket{first, second} {
+		if status, body := getCommands(t, url); status != http.StatusOK || body != *want.ToPacket() {
+			t.Errorf("got %d %q, want %q", status, body, *want.ToPacket())
+		}
+	}
+	if status, _ := getCommands(t, url); status != http.StatusNoContent {
+		t.Errorf("expected 204 once the queue is empty, got %d", status)
+	}
+}
+
+func TestHandleCommands_OnPullCommandsFirst(t *testing.T) {
+	pulled := acPacket(1)
+	cfg := commandsConfig()
+	cfg.OnPullCommands = func(ident, passwd string, r *http.Request) (server.ServerPackets, error) {
+		return pulled, nil
+	}
+	srv, url, stop := startHttpServer(t, cfg)
+	defer stop()
+
+	if err := srv.PushCommand("ident", acPacket(2)); err != nil {
+		t.Fatalf("PushCommand failed: %v", err)
+	}
+	if _, body := getCommands(t, url); body != *pulled.ToPacket() {
+		t.Errorf("body = %q, want the packet of OnPullCommands", body)
+	}
+}
+
+func TestHandleCommands_LongPoll(t *testing.T) {
+	cfg := commandsConfig()
+	cfg.LongPollTimeout = 3 * time.Second
+	srv, url, stop := startHttpServer(t, cfg)
+	defer stop()
+
+	packet := acPacket(1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = srv.PushCommand("ident", packet)
+	}()
+
+	start := time.Now()
+	status, body := getCommands(t, url)
+	if status != http.StatusOK || body != *packet.ToPacket() {
+		t.Errorf("got %d %q, want %q", status, body, *packet.ToPacket())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the pushed packet to end the wait, took %s", elapsed)
+	}
+}
+
+func TestHandleCommands_LongPollTimeout(t *testing.T) {
+	cfg := commandsConfig()
+	cfg.LongPollTimeout = 150 * time.Millisecond
+	_, url, stop := startHttpServer(t, cfg)
+	defer stop()
+
+	start := time.Now()
+	if status, _ := getCommands(t, url); status != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", status)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the request to be held, answered after %s", elapsed)
+	}
+}
+
+func TestHandleCommandStream(t *testing.T) {
+	srv, url, stop := startHttpServer(t, commandsConfig())
+	defer stop()
+
+	queued := acPacket(1)
+	if err := srv.PushCommand("ident", queued); err != nil {
+		t.Fatalf("PushCommand failed: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/v2/commands/stream", nil)
+	req.Header.Set("Authorization", "LayrzAuth ident;pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	pushed := &server.AbPacket{Devices: &[]definitions.BleData{}}
+	if err := srv.PushCommand("ident", pushed); err != nil {
+		t.Fatalf("PushCommand failed: %v", err)
+	}
+
+	events := make(chan string, 4)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		var event []string
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				event = append(event, line)
+				continue
+			}
+			events <- strings.Join(event, "|")
+			event = nil
+		}
+		close(events)
+	}()
+
+	for _, want := range []string{
+		"event: Ac|data: " + *queued.ToPacket(),
+		"event: Ab|data: " + *pushed.ToPacket(),
+	} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("event = %q, want %q", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("event not received")
+		}
+	}
+
+	// The stream ends when the server shuts down
+	stop()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected no more events")
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("stream still open after shutdown")
+	}
+}
+
+func TestHandleCommands_DisconnectedKeepsPackets(t *testing.T) {
+	for _, path := range []string{"/v2/commands", "/v2/commands/stream"} {
+		t.Run(path, func(t *testing.T) {
+			cfg := commandsConfig()
+			cfg.LongPollTimeout = 3 * time.Second
+			srv, url, stop := startHttpServer(t, cfg)
+			defer stop()
+
+			// The device gives up on the request before the packet is pushed
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+path, nil)
+			req.Header.Set("Authorization", "LayrzAuth ident;pass")
+			if resp, err := http.DefaultClient.Do(req); err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			packet := acPacket(1)
+			if err := srv.PushCommand("ident", packet); err != nil {
+				t.Fatalf("PushCommand failed: %v", err)
+			}
+			if status, body := getCommands(t, url); status != http.StatusOK || body != *packet.ToPacket() {
+				t.Errorf("got %d %q, want the packet on the next request", status, body)
+			}
+		})
+	}
+}
+
+func TestHandleCommandStream_MissingAuth(t *testing.T) {
+	_, url, stop := startHttpServer(t, commandsConfig())
+	defer stop()
+
+	resp, err := http.Get(url + "/v2/commands/stream")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,102 @@
+package servers
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+// ErrorAction is what TcpServer does after a packet could not be processed
+type ErrorAction int
+
+const (
+	// ErrorSilent drops the packet without telling the device
+	ErrorSilent ErrorAction = iota
+	// ErrorRespond answers with an <Ar> packet, so the device can retransmit the frame.
+	// The reason is fixed for each kind of failure, like "invalid crc",
+	// see ErrorPolicy.ExposeHandlerErrors for the handler errors
+	ErrorRespond
+	// ErrorClose closes the connection, the error becomes the close reason of the session
+	ErrorClose
+)
+
+// ErrorPolicy defines how TcpServer reacts to each kind of failure.
+// The zero value keeps every failure silent
+type ErrorPolicy struct {
+	// Frames whose CRC does not match their content
+	InvalidCrc ErrorAction
+	// Frames whose tag is not registered, see packets.ErrUnknownTag
+	UnknownTag ErrorAction
+	// Frames with a malformed content
+	InvalidPacket ErrorAction
+	// Errors returned by TcpConfig.OnNewPacket.
+	// With ErrorRespond the device receives the reason HandlerErrorReason
+	HandlerError ErrorAction
+	// Sends the message of the handler errors to the device instead of HandlerErrorReason.
+	// The message may hold internal details, like the name of a database.
+	// The separators of the frames, like ';' and '<', are replaced by spaces
+	ExposeHandlerErrors bool
+}
+
+// HandlerErrorReason is the reason of the <Ar> sent for a handler error
+// when ErrorPolicy.ExposeHandlerErrors is false
+const HandlerErrorReason = "internal error"
+
+// Returns the action configured for a decode error
+func (p ErrorPolicy) decodeAction(err error) ErrorAction {
+	switch {
+	case errors.Is(err, wire.ErrInvalidCrc):
+		return p.InvalidCrc
+	case errors.Is(err, wire.ErrUnknownTag):
+		return p.UnknownTag
+	}
+	return p.InvalidPacket
+}
+
+// Reasons of the <Ar> sent for the decode errors, the error itself holds the frame
+// of the device, which cannot be written in a reason
+const (
+	invalidCrcReason    = "invalid crc"
+	unknownTagReason    = "unknown tag"
+	invalidPacketReason = "invalid packet"
+)
+
+// Replaces the separators of the frames, a reason holding them cannot be decoded
+var reasonReplacer = strings.NewReplacer(";", " ", "<", " ", ">", " ")
+
+// Returns the reason sent to the device for a decode error
+func decodeReason(err error) string {
+	switch {
+	case errors.Is(err, wire.ErrInvalidCrc):
+		return invalidCrcReason
+	case errors.Is(err, wire.ErrUnknownTag):
+		return unknownTagReason
+	}
+	return invalidPacketReason
+}
+
+// Returns the reason sent to the device for a handler error
+func (p ErrorPolicy) handlerReason(err error) string {
+	if p.ExposeHandlerErrors {
+		return reasonReplacer.Replace(err.Error())
+	}
+	return HandlerErrorReason
+}
+
+// Applies the action to the failed packet, returns false if the connection must be closed.
+// reason is sent to the device with ErrorRespond
+func (s *TcpServer) applyErrorAction(action ErrorAction, err error, reason string, sess *Session) bool {
+	switch action {
+	case ErrorRespond:
+		if sendErr := sess.Send(&server.ArPacket{Reason: reason}); sendErr != nil {
+			log.Printf("Error writing to connection: %s", sendErr.Error())
+		}
+	case ErrorClose:
+		sess.setCloseReason(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package servers_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// writeRaw sends a raw frame followed by a newline
+func writeRaw(t *testing.T, conn net.Conn, frame string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// expectReason reads a frame and checks it decodes to an <Ar> with the reason
+func expectReason(t *testing.T, conn net.Conn, reason string) {
+	t.Helper()
+	got := readFrame(t, conn)
+	packet, err := server.Decode([]byte(got))
+	if err != nil {
+		t.Fatalf("the device cannot decode %q: %v", got, err)
+	}
+	if ar, ok := packet.(*server.ArPacket); !ok || ar.Reason != reason {
+		t.Errorf("expected <Ar> with %q, got %q", reason, got)
+	}
+}
+
+func TestTcpServer_ErrorPolicy_SilentByDefault(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{OnNewPacket: nopHandler})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writeRaw(t, conn, "<Pr>;0000</Pr>")
+	writePacket(t, conn, authPacket("device-1", ""))
+
+	// The first frame the device receives is the handshake response
+	if got := readFrame(t, conn); got != *(&server.AsPacket{}).ToPacket() {
+		t.Errorf("expected <As>, got %q", got)
+	}
+}
+
+func TestTcpServer_ErrorPolicy_RespondInvalidCrc(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: nopHandler,
+		ErrorPolicy: servers.ErrorPolicy{InvalidCrc: servers.ErrorRespond},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writeRaw(t, conn, "<Pr>;0000</Pr>")
+	expectReason(t, conn, "invalid crc")
+}
+
+func TestTcpServer_ErrorPolicy_RespondInvalidPacket(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: nopHandler,
+		ErrorPolicy: servers.ErrorPolicy{
+			InvalidPacket: servers.ErrorRespond,
+			InvalidCrc:    servers.ErrorClose,
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writeRaw(t, conn, "<Pr>garbage</Pr>")
+	expectReason(t, conn, "invalid packet")
+}
+
+func TestTcpServer_ErrorPolicy_UnknownTag(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: nopHandler,
+		ErrorPolicy: servers.ErrorPolicy{
+			InvalidPacket: servers.ErrorClose,
+			UnknownTag:    servers.ErrorRespond,
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writeRaw(t, conn, "<Px>garbage</Px>")
+	expectReason(t, conn, "unknown tag")
+
+	// The connection stays open, and the frame is not written in the reason
+	writeRaw(t, conn, "<Xx>a;b</Xx>")
+	expectReason(t, conn, "unknown tag")
+}
+
+func TestTcpServer_ErrorPolicy_RespondHandlerError(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, errors.New("storage unavailable")
+		},
+		ErrorPolicy: servers.ErrorPolicy{HandlerError: servers.ErrorRespond},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, &client.PrPacket{})
+	if got := readFrame(t, conn); got != *(&server.ArPacket{Reason: servers.HandlerErrorReason}).ToPacket() {
+		t.Errorf("expected <Ar> with the fixed reason, got %q", got)
+	}
+}
+
+func TestTcpServer_ErrorPolicy_ExposeHandlerErrors(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, errors.New("storage unavailable; <db>")
+		},
+		ErrorPolicy: servers.ErrorPolicy{HandlerError: servers.ErrorRespond, ExposeHandlerErrors: true},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, &client.PrPacket{})
+	expectReason(t, conn, "storage unavailable   db ")
+}
+
+func TestTcpServer_ErrorPolicy_Close(t *testing.T) {
+	sessions := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: nopHandler,
+		OnConnect:   func(session *servers.Session) { sessions <- session },
+		ErrorPolicy: servers.ErrorPolicy{InvalidCrc: servers.ErrorClose},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	session := <-sessions
+	writeRaw(t, conn, "<Pr>;0000</Pr>")
+	expectClosed(t, conn)
+
+	waitFor(t, func() bool { return session.CloseReason() != nil })
+	if !strings.Contains(session.CloseReason().Error(), "invalid CRC") {
+		t.Errorf("expected the CRC error as close reason, got %v", session.CloseReason())
+	}
+}
+
+func TestTcpServer_ErrorPolicy_DecodeErrorStillReported(t *testing.T) {
+	reported := make(chan error, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket:   nopHandler,
+		OnDecodeError: func(err error, data []byte, session *servers.Session) { reported <- err },
+		ErrorPolicy:   servers.ErrorPolicy{InvalidCrc: servers.ErrorRespond},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writeRaw(t, conn, "<Pr>;0000</Pr>")
+	expectReason(t, conn, "invalid crc")
+
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("OnDecodeError was not called")
+	}
+}
@@ -0,0 +1,79 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultPresenceTimeout is the presence timeout used when HttpConfig.PresenceTimeout is not set
+const DefaultPresenceTimeout = 5 * time.Minute
+
+// presence tracks when every HTTP device was last seen, HTTP has no connections
+// so a device is considered gone after a period without requests
+type presence struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+// touch records a request of the device, returns true if the device was not being tracked
+func (p *presence) touch(ident string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lastSeen == nil {
+		p.lastSeen = make(map[string]time.Time)
+	}
+
+	_, seen := p.lastSeen[ident]
+	p.lastSeen[ident] = now
+	return !seen
+}
+
+// expire removes and returns the devices not seen since before the given time
+func (p *presence) expire(before time.Time) map[string]time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	expired := make(map[string]time.Time)
+	for ident, lastSeen := range p.lastSeen {
+		if lastSeen.Before(before) {
+			expired[ident] = lastSeen
+			delete(p.lastSeen, ident)
+		}
+	}
+	return expired
+}
+
+// Records the request of an authenticated device and notifies HttpConfig.OnFirstSeen
+func (s *HttpServer) seen(ident string, r *http.Request) {
+	if s.config.OnFirstSeen == nil && s.config.OnLastSeen == nil {
+		return
+	}
+
+	if s.presence.touch(ident, time.Now()) && s.config.OnFirstSeen != nil {
+		s.config.OnFirstSeen(ident, r)
+	}
+}
+
+// Periodically notifies HttpConfig.OnLastSeen for the devices that went quiet,
+// runs until ctx is done
+func (s *HttpServer) sweepPresence(ctx context.Context) {
+	timeout := s.config.PresenceTimeout
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for ident, lastSeen := range s.presence.expire(now.Add(-timeout)) {
+				if s.config.OnLastSeen != nil {
+					s.config.OnLastSeen(ident, lastSeen)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package servers_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// postHeartbeat sends a <Pr> as the given device
+func postHeartbeat(t *testing.T, url, ident string) {
+	t.Helper()
+	body := *(&client.PrPacket{}).ToPacket()
+	req, _ := http.NewRequest(http.MethodPost, url+"/v2/message", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "LayrzAuth "+ident+";pass")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+}
+
+func TestHttpServer_Presence(t *testing.T) {
+	firstSeen := make(chan string, 4)
+	lastSeen := make(chan string, 4)
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		PresenceTimeout: 100 * time.Millisecond,
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) {
+			return nil, nil
+		},
+		OnFirstSeen: func(ident string, r *http.Request) { firstSeen <- ident },
+		OnLastSeen:  func(ident string, at time.Time) { lastSeen <- ident },
+	})
+	defer stop()
+
+	postHeartbeat(t, url, "device-1")
+	postHeartbeat(t, url, "device-1")
+
+	select {
+	case ident := <-firstSeen:
+		if ident != "device-1" {
+			t.Errorf("unexpected ident: %q", ident)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnFirstSeen was not called")
+	}
+	select {
+	case <-firstSeen:
+		t.Error("OnFirstSeen called twice for a known device")
+	default:
+	}
+
+	select {
+	case ident := <-lastSeen:
+		if ident != "device-1" {
+			t.Errorf("unexpected ident: %q", ident)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnLastSeen was not called")
+	}
+
+	// A device seen again after going quiet is new again
+	postHeartbeat(t, url, "device-1")
+	select {
+	case <-firstSeen:
+	case <-time.After(time.Second):
+		t.Fatal("OnFirstSeen was not called after the device came back")
+	}
+}
+
+func TestHttpServer_Presence_OnlyFirstSeen(t *testing.T) {
+	firstSeen := make(chan string, 4)
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		PresenceTimeout: 100 * time.Millisecond,
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) {
+			return nil, nil
+		},
+		OnFirstSeen: func(ident string, r *http.Request) { firstSeen <- ident },
+	})
+	defer stop()
+
+	postHeartbeat(t, url, "device-1")
+	<-firstSeen
+
+	// The device goes quiet and is first seen again when it comes back
+	time.Sleep(300 * time.Millisecond)
+	postHeartbeat(t, url, "device-1")
+	select {
+	case <-firstSeen:
+	case <-time.After(time.Second):
+		t.Fatal("OnFirstSeen was not called after the device came back")
+	}
+}
+
+func TestHttpServer_Presence_RejectedAuthIsNotSeen(t *testing.T) {
+	firstSeen := make(chan string, 1)
+	url, stop := realHttpServer(t, &servers.HttpConfig{
+		OnNewPacket: func(client.ClientPackets, *http.Request) (server.ServerPackets, error) {
+			return nil, nil
+		},
+		OnAuthenticate: func(ident, passwd string, r *http.Request) bool { return false },
+		OnFirstSeen:    func(ident string, r *http.Request) { firstSeen <- ident },
+	})
+	defer stop()
+
+	postHeartbeat(t, url, "device-1")
+	select {
+	case <-firstSeen:
+		t.Error("OnFirstSeen called for a rejected device")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,113 @@
+package servers
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+// ErrSessionNotFound is returned when no authenticated session exists for an ident
+var ErrSessionNotFound = errors.New("session not found")
+
+// registry keeps the authenticated sessions indexed by the ident of the device
+type registry struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// register indexes the session by ident. If another session was registered with the
+// same ident (the device reconnected), the newest session takes its place
+func (r *registry) register(ident string, sess *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions == nil {
+		r.sessions = make(map[string]*Session)
+	}
+
+	if previous := sess.Ident(); previous != "" && previous != ident && r.sessions[previous] == sess {
+		delete(r.sessions, previous)
+	}
+
+	r.sessions[ident] = sess
+}
+
+// unregister removes the session, only if it is still the one registered for its ident
+func (r *registry) unregister(sess *Session) {
+	ident := sess.Ident()
+	if ident == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[ident] == sess {
+		delete(r.sessions, ident)
+	}
+}
+
+func (r *registry) get(ident string) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sess, ok := r.sessions[ident]
+	return sess, ok
+}
+
+// snapshot returns the registered sessions at the moment of the call
+func (r *registry) snapshot() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, sess := range r.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// Session returns the authenticated session of the device with the given ident
+func (s *TcpServer) Session(ident string) (*Session, bool) {
+	return s.registry.get(ident)
+}
+
+// Sessions returns all the authenticated sessions
+func (s *TcpServer) Sessions() []*Session {
+	return s.registry.snapshot()
+}
+
+// SendTo pushes a packet to the connected device with the given ident,
+// for example a <Ac> command packet. Returns ErrSessionNotFound if the device is not connected
+func (s *TcpServer) SendTo(ident string, packet server.ServerPackets) error {
+	sess, ok := s.registry.get(ident)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return sess.Send(packet)
+}
+
+// Broadcast pushes a packet to every authenticated session accepted by filter,
+// if filter is nil the packet is sent to all the sessions.
+//
+// The sessions are written concurrently, so a stalled device only delays its own packet
+// up to TcpConfig.WriteTimeout. Returns the number of sessions the packet was written to,
+// the failed writes are logged
+func (s *TcpServer) Broadcast(filter func(session *Session) bool, packet server.ServerPackets) int {
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for _, sess := range s.registry.snapshot() {
+		if filter != nil && !filter(sess) {
+			continue
+		}
+		wg.Go(func() {
+			if err := sess.Send(packet); err != nil {
+				log.Printf("Error broadcasting to %s: %s", sess.Ident(), err.Error())
+				return
+			}
+			sent.Add(1)
+		})
+	}
+	wg.Wait()
+	return int(sent.Load())
+}
@@ -0,0 +1,180 @@
+package servers_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// startRegistryServer starts a TcpServer and returns it along with its port
+func startRegistryServer(t *testing.T) (*servers.TcpServer, int) {
+	t.Helper()
+	var srv *servers.TcpServer
+	port, cancel := startTcpServerWith(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, nil
+		},
+	}, &srv, nil)
+	t.Cleanup(cancel)
+	return srv, port
+}
+
+// waitFor polls cond until it is true or the timeout is reached
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
+
+func commandPacket() *server.AcPacket {
+	return &server.AcPacket{Commands: []definitions.CommandDefinition{
+		{CommandId: 1, CommandName: strPtr("reboot"), Args: map[string]any{}},
+	}}
+}
+
+func TestTcpServer_SendTo(t *testing.T) {
+	srv, port := startRegistryServer(t)
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+
+	packet := commandPacket()
+	if err := srv.SendTo("device-1", packet); err != nil {
+		t.Fatalf("SendTo failed: %v", err)
+	}
+
+	if got := readFrame(t, conn); got != *packet.ToPacket() {
+		t.Errorf("pushed packet mismatch: got %q, want %q", got, *packet.ToPacket())
+	}
+}
+
+func TestTcpServer_SendTo_UnknownIdent(t *testing.T) {
+	srv, _ := startRegistryServer(t)
+
+	err := srv.SendTo("missing", commandPacket())
+	if !errors.Is(err, servers.ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestTcpServer_SendTo_UnauthenticatedIsNotRegistered(t *testing.T) {
+	srv, port := startRegistryServer(t)
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, &client.PrPacket{})
+	time.Sleep(50 * time.Millisecond)
+
+	if sessions := srv.Sessions(); len(sessions) != 0 {
+		t.Errorf("expected no registered sessions, got %d", len(sessions))
+	}
+}
+
+func TestTcpServer_Broadcast(t *testing.T) {
+	srv, port := startRegistryServer(t)
+
+	idents := []string{"truck-1", "truck-2", "car-1"}
+	for _, ident := range idents {
+		conn := dialTcp(t, port)
+		writePacket(t, conn, authPacket(ident, ""))
+		_ = readFrame(t, conn)
+	}
+
+	packet := commandPacket()
+	sent := srv.Broadcast(func(session *servers.Session) bool {
+		return session.Ident() != "car-1"
+	}, packet)
+	if sent != 2 {
+		t.Errorf("expected 2 sessions, got %d", sent)
+	}
+
+	if sent := srv.Broadcast(nil, packet); sent != 3 {
+		t.Errorf("expected 3 sessions with nil filter, got %d", sent)
+	}
+}
+
+func TestTcpServer_WriteTimeout_StalledDevice(t *testing.T) {
+	var srv *servers.TcpServer
+	port, cancel := startTcpServerWith(t, &servers.TcpConfig{
+		OnNewPacket:  nopHandler,
+		WriteTimeout: 500 * time.Millisecond,
+	}, &srv, nil)
+	defer cancel()
+
+	// The stalled device never reads, a large packet fills the buffers of the connection
+	stalled := dialTcp(t, port)
+	writePacket(t, stalled, authPacket("stalled", ""))
+	_ = readFrame(t, stalled)
+	healthy := dialTcp(t, port)
+	writePacket(t, healthy, authPacket("healthy", ""))
+	_ = readFrame(t, healthy)
+
+	session, _ := srv.Session("stalled")
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- srv.SendTo("stalled", &server.ArPacket{Reason: strings.Repeat("x", 32<<20)})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// The stalled device does not hold back the others
+	packet := commandPacket()
+	go srv.Broadcast(nil, packet)
+	if got := readFrame(t, healthy); got != *packet.ToPacket() {
+		t.Errorf("expected the broadcast packet, got %q", got)
+	}
+
+	select {
+	case err := <-sendErr:
+		if err == nil {
+			t.Error("expected the write to the stalled device to fail")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("SendTo blocked past the write timeout")
+	}
+	if !errors.Is(session.CloseReason(), servers.ErrWriteTimeout) {
+		t.Errorf("expected ErrWriteTimeout, got %v", session.CloseReason())
+	}
+}
+
+func TestTcpServer_Registry_ReconnectReplacesSession(t *testing.T) {
+	srv, port := startRegistryServer(t)
+
+	first := dialTcp(t, port)
+	writePacket(t, first, authPacket("device-1", ""))
+	_ = readFrame(t, first)
+	old, _ := srv.Session("device-1")
+
+	second := dialTcp(t, port)
+	writePacket(t, second, authPacket("device-1", ""))
+	_ = readFrame(t, second)
+
+	current, ok := srv.Session("device-1")
+	if !ok || current == old {
+		t.Fatal("expected the newest session to be registered")
+	}
+
+	// The stale connection going away must not evict the new session
+	_ = first.Close()
+	time.Sleep(50 * time.Millisecond)
+	if current, ok := srv.Session("device-1"); !ok || current == old {
+		t.Error("closing the stale session evicted the new one")
+	}
+
+	_ = second.Close()
+	waitFor(t, func() bool {
+		_, ok := srv.Session("device-1")
+		return !ok
+	})
+}
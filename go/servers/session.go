@@ -0,0 +1,181 @@
+package servers
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+)
+
+// Session is the state of a single device connected to the TcpServer.
+// A session is created for every accepted connection and is passed to every callback,
+// it is safe to use from multiple goroutines
+type Session struct {
+	conn         net.Conn
+	connectedAt  time.Time
+	writeTimeout time.Duration
+
+	// Framing buffer, only touched by the connection goroutine
+	accumulated []byte
+
+	mu            sync.RWMutex
+	ident         string
+	authenticated bool
+	byCertificate bool
+	values        map[string]any
+	lastHeartbeat time.Time
+
+	writeMu sync.Mutex
+
+	// Closed once the connection goroutine exits
+	done chan struct{}
+	// Set when the connection was closed by the server without draining
+	closed atomic.Bool
+	// Why the session was closed, the first reason set wins
+	reason error
+}
+
+func newSession(conn net.Conn, writeTimeout time.Duration) *Session {
+	return &Session{
+		conn:         conn,
+		connectedAt:  time.Now(),
+		writeTimeout: writeTimeout,
+		done:         make(chan struct{}),
+	}
+}
+
+// Ident returns the ident of the device, empty until the session is authenticated
+func (s *Session) Ident() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ident
+}
+
+// Authenticated returns true when the device completed the <Pa> handshake
+func (s *Session) Authenticated() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authenticated
+}
+
+// RemoteAddr returns the remote address of the device
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// ConnectedAt returns when the connection was accepted
+func (s *Session) ConnectedAt() time.Time {
+	return s.connectedAt
+}
+
+// Conn returns the underlying connection of the session
+func (s *Session) Conn() net.Conn {
+	return s.conn
+}
+
+// TLS returns the state of the TLS connection, or nil if the session does not use TLS
+func (s *Session) TLS() *tls.ConnectionState {
+	conn, ok := s.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := conn.ConnectionState()
+	return &state
+}
+
+// Get returns the user data stored under key, or nil if not set
+func (s *Session) Get(key string) any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+// Set stores user data under key, the data lives as long as the session
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]any)
+	}
+	s.values[key] = value
+}
+
+// authenticate marks the session as authenticated by the given ident
+func (s *Session) authenticate(ident string, byCertificate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ident = ident
+	s.authenticated = true
+	s.byCertificate = byCertificate
+}
+
+// Returns true when the ident was taken from the client certificate
+func (s *Session) authenticatedByCertificate() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byCertificate
+}
+
+// LastHeartbeat returns when the last <Pr> heartbeat was received, zero if none was received
+func (s *Session) LastHeartbeat() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastHeartbeat
+}
+
+// heartbeat records a <Pr> heartbeat received now
+func (s *Session) heartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeat = time.Now()
+}
+
+// CloseReason returns why the session was closed, for example ErrClosedByPeer or ErrIdleTimeout,
+// or nil while the session is open
+func (s *Session) CloseReason() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reason
+}
+
+// setCloseReason records why the session is being closed, keeping the first reason
+func (s *Session) setCloseReason(reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == nil {
+		s.reason = reason
+	}
+}
+
+// forceClose closes the connection without waiting for in-flight packets
+func (s *Session) forceClose() {
+	s.closed.Store(true)
+	s.setCloseReason(ErrServerClosed)
+	_ = s.conn.Close()
+}
+
+// Send encodes and writes a packet to the device, it may be called at any time.
+// Writes are serialized so packets from different goroutines never interleave on the wire.
+//
+// A write not completed within TcpConfig.WriteTimeout fails and closes the session with
+// ErrWriteTimeout, part of the packet may have been written
+func (s *Session) Send(packet server.ServerPackets) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.writeTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	_, err := s.conn.Write([]byte(*packet.ToPacket()))
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		s.setCloseReason(ErrWriteTimeout)
+		_ = s.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package servers_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/definitions"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+func strPtr(s string) *string { return &s }
+
+// dialTcp connects to the test server and returns the connection
+func dialTcp(t *testing.T, port int) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// writePacket writes a newline terminated packet to the connection
+func writePacket(t *testing.T, conn net.Conn, packet client.ClientPackets) {
+	t.Helper()
+	if _, err := fmt.Fprint(conn, *packet.ToPacket()+"\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// readFrame reads from the connection until a complete frame is received
+func readFrame(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	var got []byte
+	buf := make([]byte, 512)
+	for !strings.Contains(string(got), "</") {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, got)
+		}
+		got = append(got, buf[:n]...)
+	}
+	return string(got)
+}
+
+func authPacket(ident, passwd string) *client.PaPacket {
+	return &client.PaPacket{Ident: strPtr(ident), Password: strPtr(passwd)}
+}
+
+func TestSession_HandshakeAccepted(t *testing.T) {
+	type seen struct {
+		ident       string
+		remote      net.Addr
+		connectedAt time.Time
+		value       any
+	}
+	got := make(chan seen, 1)
+
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnAuthenticate: func(ident, passwd string, session *servers.Session) bool {
+			if ident != "device-1" || passwd != "secret" {
+				return false
+			}
+			session.Set("tenant", "acme")
+			return true
+		},
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			got <- seen{
+				ident:       session.Ident(),
+				remote:      session.RemoteAddr(),
+				connectedAt: session.ConnectedAt(),
+				value:       session.Get("tenant"),
+			}
+			return nil, nil
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", "secret"))
+	if resp := readFrame(t, conn); resp != *(&server.AsPacket{}).ToPacket() {
+		t.Fatalf("expected <As>, got %q", resp)
+	}
+
+	writePacket(t, conn, &client.PdPacket{Timestamp: time.Unix(1700000000, 0)})
+
+	select {
+	case s := <-got:
+		if s.ident != "device-1" {
+			t.Errorf("Ident mismatch: got %q", s.ident)
+		}
+		if s.remote == nil {
+			t.Error("expected RemoteAddr to be set")
+		}
+		if s.connectedAt.IsZero() {
+			t.Error("expected ConnectedAt to be set")
+		}
+		if s.value != "acme" {
+			t.Errorf("user data mismatch: got %v", s.value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnNewPacket was not called")
+	}
+}
+
+func TestSession_HandshakeRejected(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnAuthenticate: func(ident, passwd string, session *servers.Session) bool { return false },
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, nil
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", "wrong"))
+
+	resp := readFrame(t, conn)
+	if !strings.HasPrefix(resp, "<Ar>") {
+		t.Errorf("expected <Ar>, got %q", resp)
+	}
+}
+
+func TestSession_FailedReauthenticationCloses(t *testing.T) {
+	sessions := make(chan *servers.Session, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnAuthenticate: func(ident, passwd string, session *servers.Session) bool { return passwd == "secret" },
+		OnConnect:      func(session *servers.Session) { sessions <- session },
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, nil
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	session := <-sessions
+	writePacket(t, conn, authPacket("device-1", "secret"))
+	_ = readFrame(t, conn)
+
+	writePacket(t, conn, authPacket("device-2", "wrong"))
+	if resp := readFrame(t, conn); !strings.HasPrefix(resp, "<Ar>") {
+		t.Errorf("expected <Ar>, got %q", resp)
+	}
+	expectClosed(t, conn)
+
+	waitFor(t, func() bool { return session.CloseReason() != nil })
+	if !errors.Is(session.CloseReason(), servers.ErrAuthenticationFailed) {
+		t.Errorf("expected ErrAuthenticationFailed, got %v", session.CloseReason())
+	}
+}
+
+func TestSession_NilOnAuthenticateAllowsAll(t *testing.T) {
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, nil
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+
+	if resp := readFrame(t, conn); resp != *(&server.AsPacket{}).ToPacket() {
+		t.Errorf("expected <As>, got %q", resp)
+	}
+}
+
+func TestSession_PaNotDeliveredToHandler(t *testing.T) {
+	called := make(chan client.ClientPackets, 2)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			called <- p
+			return nil, nil
+		},
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, authPacket("device-1", ""))
+	_ = readFrame(t, conn)
+	writePacket(t, conn, &client.PrPacket{})
+
+	select {
+	case p := <-called:
+		if _, ok := p.(*client.PrPacket); !ok {
+			t.Errorf("expected *client.PrPacket, got %T", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnNewPacket was not called")
+	}
+}
+
+func TestSession_RejectsDataBeforeAuthentication(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet client.ClientPackets
+	}{
+		{name: "Pd", packet: &client.PdPacket{Timestamp: time.Unix(1700000000, 0)}},
+		{name: "Pb", packet: &client.PbPacket{Advertisements: &[]definitions.BleAdvertisement{{
+			MacAddress: "AA:BB:CC:DD:EE:FF",
+			Timestamp:  time.Unix(1700000000, 0),
+			Model:      "GENERIC",
+			Rssi:       -60,
+		}}}},
+		{name: "Pc", packet: &client.PcPacket{Timestamp: time.Unix(1700000000, 0), CommandId: 1, Message: strPtr("ok")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := make(chan struct{}, 1)
+			port, cancel := startTcpServer(t, &servers.TcpConfig{
+				OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+					called <- struct{}{}
+					return nil, nil
+				},
+			})
+			defer cancel()
+
+			conn := dialTcp(t, port)
+			writePacket(t, conn, tt.packet)
+
+			resp := readFrame(t, conn)
+			if resp != *(&server.ArPacket{Reason: "not authenticated"}).ToPacket() {
+				t.Errorf("expected <Ar> not authenticated, got %q", resp)
+			}
+
+			select {
+			case <-called:
+				t.Error("OnNewPacket should not be called before authentication")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ShutdownResult reports how the connections were closed by Shutdown
+type ShutdownResult struct {
+	// Connections that finished their in-flight packets and were closed gracefully
+	Drained int
+	// Connections still busy when the deadline was reached, closed without draining
+	ForceClosed int
+}
+
+// Shutdown gracefully stops the TCP server.
+// It stops accepting new connections, lets the in-flight OnNewPacket calls finish,
+// sends the TcpConfig.OnShutdown packet to every session and closes the connections.
+//
+// If ctx expires before every connection was drained, the remaining connections are
+// closed immediately and the context error is returned alongside the result
+func (s *TcpServer) Shutdown(ctx context.Context) (ShutdownResult, error) {
+	s.draining.Store(true)
+
+	s.mu.Lock()
+	ln := s.listener
+	sessions := s.activeSessions()
+	s.mu.Unlock()
+
+	var result ShutdownResult
+	if ln != nil {
+		if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return result, err
+		}
+	}
+
+	// Unblock the pending reads, the connection goroutines finish the frames already
+	// received and exit on the next read
+	for _, sess := range sessions {
+		_ = sess.conn.SetReadDeadline(time.Now())
+	}
+
+	for i, sess := range sessions {
+		select {
+		case <-sess.done:
+			result.Drained++
+		case <-ctx.Done():
+			for _, pending := range sessions[i:] {
+				select {
+				case <-pending.done:
+					result.Drained++
+				default:
+					pending.forceClose()
+					result.ForceClosed++
+				}
+			}
+			s.stop()
+			return result, ctx.Err()
+		}
+	}
+
+	s.stop()
+	return result, nil
+}
+
+// stop cancels the context of the running Start call
+func (s *TcpServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// track adds the session to the active connections, returns false if the server is draining
+func (s *TcpServer) track(sess *Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining.Load() {
+		return false
+	}
+	if s.active == nil {
+		s.active = make(map[*Session]struct{})
+	}
+	s.active[sess] = struct{}{}
+	return true
+}
+
+func (s *TcpServer) untrack(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, sess)
+}
+
+// activeSessions returns the tracked sessions, the caller must hold s.mu
+func (s *TcpServer) activeSessions() []*Session {
+	sessions := make([]*Session, 0, len(s.active))
+	for sess := range s.active {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
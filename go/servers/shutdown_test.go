@@ -0,0 +1,154 @@
+package servers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
+	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
+)
+
+// startShutdownServer starts a TcpServer and returns it, its port and the Start result channel
+func startShutdownServer(t *testing.T, cfg *servers.TcpConfig) (*servers.TcpServer, int, <-chan error) {
+	t.Helper()
+	var srv *servers.TcpServer
+	done := make(chan error, 1)
+	port, cancel := startTcpServerWith(t, cfg, &srv, done)
+	t.Cleanup(cancel)
+	return srv, port, done
+}
+
+func TestTcpServer_Shutdown_DrainsWithFinalPacket(t *testing.T) {
+	final := &server.ArPacket{Reason: "server shutdown"}
+	srv, port, done := startShutdownServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, nil
+		},
+		OnShutdown: func(session *servers.Session) server.ServerPackets { return final },
+	})
+
+	conns := []net.Conn{dialTcp(t, port), dialTcp(t, port)}
+	for _, conn := range conns {
+		writePacket(t, conn, authPacket("device", ""))
+		_ = readFrame(t, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	result, err := srv.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if result.Drained != 2 || result.ForceClosed != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	for _, conn := range conns {
+		if got := readFrame(t, conn); got != *final.ToPacket() {
+			t.Errorf("final packet mismatch: got %q", got)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, io.EOF) {
+			t.Errorf("expected EOF after shutdown, got %v", err)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Start did not return after Shutdown")
+	}
+}
+
+func TestTcpServer_Shutdown_WaitsInFlightHandler(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	response := &server.AoPacket{Timestamp: time.Unix(1700000000, 0)}
+
+	srv, port, _ := startShutdownServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			close(entered)
+			<-release
+			return response, nil
+		},
+	})
+
+	conn := dialTcp(t, port)
+	writePacket(t, conn, &client.PrPacket{})
+	<-entered
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	result, err := srv.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if result.Drained != 1 {
+		t.Errorf("expected 1 drained connection, got %+v", result)
+	}
+
+	if got := readFrame(t, conn); got != *response.ToPacket() {
+		t.Errorf("in-flight response lost: got %q", got)
+	}
+}
+
+func TestTcpServer_Shutdown_ForceClosesAfterDeadline(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	srv, port, _ := startShutdownServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			close(entered)
+			<-release
+			return nil, nil
+		},
+	})
+
+	busy := dialTcp(t, port)
+	writePacket(t, busy, &client.PrPacket{})
+	<-entered
+	_ = dialTcp(t, port)
+	time.Sleep(30 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err := srv.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if result.Drained != 1 || result.ForceClosed != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestTcpServer_Shutdown_StopsAccepting(t *testing.T) {
+	srv, port, _ := startShutdownServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			return nil, nil
+		},
+	})
+
+	if _, err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	if conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second); err == nil {
+		_ = conn.Close()
+		t.Error("expected dial to fail after Shutdown")
+	}
+}
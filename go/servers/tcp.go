@@ -3,11 +3,18 @@ package servers
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/goldenm-software/layrz-protocol/go/v3/internal/wire"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/helpers"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
@@ -16,24 +23,101 @@ import (
 
 // TcpServer is a TCP server that listens for incoming connections and processes the incoming packets
 type TcpServer struct {
-	config             *TcpConfig
-	ctx                context.Context
-	cancel             context.CancelFunc
-	accumulatedPerPort map[int][]byte
+	config   *TcpConfig
+	registry registry
+
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	listener net.Listener
+	active   map[*Session]struct{}
+
+	// Set once Shutdown or Close is called, the server never accepts connections again
+	draining atomic.Bool
 }
 
 // TcpConfig is the configuration for the TCP server
 type TcpConfig struct {
-	// Defines the TCP Port for the TCP server to listen
+	// Defines the TCP Port for the TCP server to listen, not required when Listener is set
 	Port int
+	// Defines the address to bind, for example "127.0.0.1" or "::1". By default binds all the interfaces
+	Address string
+	// Defines the network to listen on, "tcp", "tcp4" or "tcp6" (IPv6-only). By default is "tcp"
+	Network string
+	// Uses the given listener instead of binding Address and Port.
+	// ProxyProtocolV2 and TLSConfig are applied on top of it
+	Listener net.Listener
 	// Enables Proxy Protocol v2 support, by default is disabled
 	ProxyProtocolV2 bool
+	// Enables TLS with the given configuration, by default is disabled.
+	// For mutual TLS, set ClientAuth and ClientCAs on the configuration
+	TLSConfig *tls.Config
+	// Authenticates the session with the Common Name of the verified client certificate,
+	// as an alternative to the <Pa> handshake. Requires TLSConfig.
+	// OnAuthenticate is not called for sessions authenticated by certificate,
+	// and a <Pa> sent on these sessions is answered with <Ar>
+	IdentFromCertificate bool
 	// Handler on new packet received, the response is optional, if nil, no response will be sent
 	// however, if you need to send a response, you must return a server.ServerPackets
-	OnNewPacket func(packet client.ClientPackets, conn net.Conn) (server.ServerPackets, error)
+	//
+	// <Pr> heartbeats are answered with <Ao> when the handler returns no response
+	//
+	// The <Pa> handshake is handled by the server and is not delivered to this handler,
+	// <Pd>, <Pb> and <Pc> packets are only delivered once the session is authenticated
+	OnNewPacket func(packet client.ClientPackets, session *Session) (server.ServerPackets, error)
+
+	// Called to authenticate the device when a <Pa> packet is received.
+	// Returning true responds with <As>, returning false responds with <Ar>.
+	// If nil, all devices are allowed.
+	OnAuthenticate func(ident, passwd string, session *Session) bool
 
 	// Is the defined callback when something went wrong on decoder
-	OnDecodeError func(err error, data []byte, conn net.Conn)
+	OnDecodeError func(err error, data []byte, session *Session)
+
+	// Decodes in lenient mode: frames with a bad CRC or extra trailing fields are accepted
+	// and every problem is reported to OnDecodeWarning. By default frames are decoded strictly
+	LenientDecoding bool
+	// Called for every problem accepted by LenientDecoding, err is a *packets.DecodeError
+	OnDecodeWarning func(err error, data []byte, session *Session)
+
+	// Defines whether the device is told about packets that failed to decode or to be handled,
+	// by default every failure is silent
+	ErrorPolicy ErrorPolicy
+
+	// Closes the session when nothing is received within this duration, by default is disabled
+	IdleTimeout time.Duration
+	// Closes the session when no <Pr> heartbeat is received within this duration since the
+	// previous one, detecting half-open connections. Only applies once the device sent its
+	// first heartbeat, by default is disabled
+	HeartbeatTimeout time.Duration
+	// Closes the session when it is not authenticated within this duration since the connection
+	// was accepted, including the TLS handshake. By default is disabled
+	HandshakeTimeout time.Duration
+	// Closes the session when the accumulated data exceeds this size without completing a frame.
+	// By default is DefaultMaxFrameSize
+	MaxFrameSize int
+	// Time limit to write a packet to the device, a write that exceeds it fails and closes
+	// the session with ErrWriteTimeout. By default is DefaultWriteTimeout
+	WriteTimeout time.Duration
+
+	// Called before closing a session because of IdleTimeout
+	OnIdle func(session *Session)
+	// Called before closing a session because of HeartbeatTimeout
+	OnHeartbeatTimeout func(session *Session)
+	// Called before closing a session because of MaxFrameSize, size is the accumulated size
+	OnOversizedFrame func(session *Session, size int)
+
+	// Called when a connection is accepted, before the TLS handshake and the authentication
+	OnConnect func(session *Session)
+	// Called when the session is authenticated, by the <Pa> handshake or by its client certificate
+	OnAuthenticated func(session *Session)
+	// Called when the connection is closed, reason tells why, for example ErrClosedByPeer,
+	// ErrIdleTimeout or ErrServerShutdown. Only called for sessions that got OnConnect
+	OnDisconnect func(session *Session, reason error)
+
+	// Called for every session drained by Shutdown, once its in-flight packets were handled.
+	// The returned packet, if not nil, is sent to the device before closing the connection
+	OnShutdown func(session *Session) server.ServerPackets
 }
 
 // Creates a new TCP server with the given configuration
@@ -47,15 +131,40 @@ func New(cfg *TcpConfig) (*TcpServer, error) {
 	}
 
 	if cfg.OnDecodeError == nil {
-		cfg.OnDecodeError = func(err error, data []byte, conn net.Conn) {
+		cfg.OnDecodeError = func(err error, data []byte, session *Session) {
 			log.Printf("Error decoding packet: %s Data: %s", err.Error(), string(data))
 		}
 	}
 
-	if cfg.Port <= 0 || cfg.Port >= 65535 {
+	if cfg.OnDecodeWarning == nil {
+		cfg.OnDecodeWarning = func(err error, data []byte, session *Session) {
+			log.Printf("Accepted packet with warning: %s Data: %s", err.Error(), string(data))
+		}
+	}
+
+	if cfg.Listener == nil && (cfg.Port <= 0 || cfg.Port >= 65535) {
 		return nil, fmt.Errorf("port is not valid")
 	}
 
+	switch cfg.Network {
+	case "":
+		cfg.Network = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("network %q is not valid", cfg.Network)
+	}
+
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+
+	if cfg.IdentFromCertificate && cfg.TLSConfig == nil {
+		return nil, fmt.Errorf("ident from certificate requires a TLS configuration")
+	}
+
 	return &TcpServer{config: cfg}, nil
 }
 
@@ -64,103 +173,331 @@ func New(cfg *TcpConfig) (*TcpServer, error) {
 // or an error occurs
 func (s *TcpServer) Start(ctx context.Context) error {
 	subctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
 	s.ctx = subctx
 	s.cancel = cancel
-
-	s.accumulatedPerPort = make(map[int][]byte)
+	s.mu.Unlock()
 
 	defer cancel()
 
-	var ln net.Listener
-	var err error
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
 
-	if s.config.ProxyProtocolV2 {
-		rawLn, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
-		if err != nil {
-			return err
-		}
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
 
-		ln = &proxyproto.Listener{Listener: rawLn}
-	} else {
-		ln, err = net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
+	if s.draining.Load() {
+		_ = ln.Close()
+		return nil
 	}
 
-	if err != nil {
-		return err
-	}
+	go func() {
+		<-subctx.Done()
+		_ = ln.Close()
+	}()
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.draining.Load() || subctx.Err() != nil {
+				return nil
+			}
 			return err
 		}
-		go s.handleConnection(conn)
+
+		sess := newSession(conn, s.config.WriteTimeout)
+		if !s.track(sess) {
+			_ = conn.Close()
+			continue
+		}
+		go s.handleConnection(sess)
 	}
 }
 
+// Builds the listener of the server, wrapping it with Proxy Protocol and TLS when enabled
+func (s *TcpServer) listen() (net.Listener, error) {
+	ln := s.config.Listener
+	if ln == nil {
+		addr := net.JoinHostPort(s.config.Address, strconv.Itoa(s.config.Port))
+		raw, err := net.Listen(s.config.Network, addr)
+		if err != nil {
+			return nil, err
+		}
+		ln = raw
+	}
+
+	if s.config.ProxyProtocolV2 {
+		ln = &proxyproto.Listener{Listener: ln}
+	}
+
+	if s.config.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.config.TLSConfig)
+	}
+
+	return ln, nil
+}
+
 // Handles a new connection and processes the incoming packets
-// This method is not thread-safe and should be called in a separate goroutine
-func (s *TcpServer) handleConnection(conn net.Conn) {
-	port := s.getPort(conn)
+// Every connection runs on its own goroutine and only touches its own session
+func (s *TcpServer) handleConnection(sess *Session) {
+	conn := sess.conn
 	defer func() {
+		if s.draining.Load() && !sess.closed.Load() {
+			sess.setCloseReason(ErrServerShutdown)
+			if s.config.OnShutdown != nil {
+				if packet := s.config.OnShutdown(sess); packet != nil {
+					_ = sess.Send(packet)
+				}
+			}
+		}
+
+		s.registry.unregister(sess)
+		s.untrack(sess)
 		_ = conn.Close()
-		delete(s.accumulatedPerPort, port)
+
+		if s.config.OnDisconnect != nil {
+			s.config.OnDisconnect(sess, sess.CloseReason())
+		}
+		close(sess.done)
 	}()
 
+	if s.config.OnConnect != nil {
+		s.config.OnConnect(sess)
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := s.handshakeTLS(tlsConn, sess); err != nil {
+			if reason := s.timeoutReason(err, sess); reason != nil {
+				sess.setCloseReason(reason)
+				return
+			}
+			log.Printf("Error on TLS handshake: %s", err.Error())
+			sess.setCloseReason(err)
+			return
+		}
+	}
+
+	var garbage [][]byte
+	frames := helpers.FrameScanner{
+		MaxSize:   s.config.MaxFrameSize,
+		OnGarbage: func(data []byte) { garbage = append(garbage, bytes.Clone(data)) },
+	}
+
 	buf := make([]byte, 1024)
 	for {
+		_ = conn.SetReadDeadline(s.readDeadline(sess))
+		// Shutdown may have interrupted the reads while the deadline was being reset
+		if s.draining.Load() {
+			return
+		}
+
 		n, err := conn.Read(buf)
 		if err != nil {
+			if s.draining.Load() {
+				return
+			}
 			if err == io.EOF {
+				sess.setCloseReason(ErrClosedByPeer)
+				return
+			}
+			if reason := s.timeoutReason(err, sess); reason != nil {
+				if reason == ErrIdleTimeout && s.config.OnIdle != nil {
+					s.config.OnIdle(sess)
+				}
+				if reason == ErrHeartbeatTimeout && s.config.OnHeartbeatTimeout != nil {
+					s.config.OnHeartbeatTimeout(sess)
+				}
+				sess.setCloseReason(reason)
 				return
 			}
 			log.Printf("Error reading from connection: %s", err.Error())
+			sess.setCloseReason(err)
 			return
 		}
 
-		s.accumulatedPerPort[port] = append(s.accumulatedPerPort[port], buf[:n]...)
-		if !bytes.ContainsRune(s.accumulatedPerPort[port], '\n') {
-			continue
-		}
-
-		messages := helpers.Split(string(s.accumulatedPerPort[port]))
-		s.accumulatedPerPort[port] = []byte{}
+		sess.accumulated = append(sess.accumulated, buf[:n]...)
 
-		for _, message := range messages {
-			packet, err := client.Decode([]byte(message))
+		consumed := 0
+		for {
+			advance, frame, err := frames.Split(sess.accumulated[consumed:], false)
 			if err != nil {
-				s.config.OnDecodeError(err, []byte(message), conn)
-				continue
+				if s.config.OnOversizedFrame != nil {
+					s.config.OnOversizedFrame(sess, len(sess.accumulated)-consumed)
+				}
+				sess.setCloseReason(ErrFrameTooLarge)
+				return
 			}
+			consumed += advance
 
-			response, err := s.config.OnNewPacket(packet, conn)
-			if err != nil {
-				log.Printf("Error in handler callback: %s", err.Error())
-				continue
+			// Garbage is decoded as well, so it is reported to OnDecodeError
+			for _, data := range garbage {
+				if !s.handleFrame(data, sess) {
+					return
+				}
 			}
+			garbage = garbage[:0]
 
-			if response != nil {
-				responseStr := response.ToPacket()
-				_, err = conn.Write([]byte(*responseStr))
-				if err != nil {
-					log.Printf("Error writing to connection: %s", err.Error())
-					continue
-				}
+			if frame == nil {
+				break
+			}
+			if !s.handleFrame(frame, sess) {
+				return
 			}
 		}
+		sess.accumulated = append(sess.accumulated[:0], sess.accumulated[consumed:]...)
 	}
 }
 
-// Close the TCP server and release the port
-func (s *TcpServer) Close() error {
-	s.cancel()
+// Decodes a frame in the mode of the configuration
+func (s *TcpServer) decode(frame []byte, sess *Session) (client.ClientPackets, error) {
+	if !s.config.LenientDecoding {
+		return client.Decode(frame)
+	}
+
+	d := &wire.Decoder{Lenient: true}
+	packet, err := client.DecodeWith(frame, d)
+	for _, warning := range d.Warnings {
+		s.config.OnDecodeWarning(warning, frame, sess)
+	}
+	return packet, err
+}
+
+// Decodes and dispatches a frame, returns false if the connection must be closed
+func (s *TcpServer) handleFrame(frame []byte, sess *Session) bool {
+	packet, err := s.decode(frame, sess)
+	if err != nil {
+		s.config.OnDecodeError(err, frame, sess)
+		return s.applyErrorAction(s.config.ErrorPolicy.decodeAction(err), err, decodeReason(err), sess)
+	}
+
+	response, err := s.dispatch(packet, sess)
+	if errors.Is(err, ErrAuthenticationFailed) {
+		if err := sess.Send(response); err != nil {
+			log.Printf("Error writing to connection: %s", err.Error())
+		}
+		sess.setCloseReason(err)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error in handler callback: %s", err.Error())
+		return s.applyErrorAction(s.config.ErrorPolicy.HandlerError, err, s.config.ErrorPolicy.handlerReason(err), sess)
+	}
+
+	if response != nil {
+		if err := sess.Send(response); err != nil {
+			log.Printf("Error writing to connection: %s", err.Error())
+		}
+	}
+	return true
+}
+
+// Completes the TLS handshake and, when enabled, authenticates the session
+// with the Common Name of the client certificate
+func (s *TcpServer) handshakeTLS(conn *tls.Conn, sess *Session) error {
+	_ = conn.SetDeadline(s.readDeadline(sess))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if !s.config.IdentFromCertificate {
+		return nil
+	}
+
+	// Only certificates verified against the ClientCAs are trusted
+	chains := conn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 || chains[0][0].Subject.CommonName == "" {
+		return nil
+	}
+
+	s.markAuthenticated(chains[0][0].Subject.CommonName, true, sess)
 	return nil
 }
 
-// Helper function to get the port from a connection
-func (s *TcpServer) getPort(conn net.Conn) int {
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		return addr.Port
+// Routes a decoded packet, the <Pa> handshake is answered here and packets that
+// require authentication are rejected until the session is authenticated
+func (s *TcpServer) dispatch(packet client.ClientPackets, sess *Session) (server.ServerPackets, error) {
+	switch p := packet.(type) {
+	case *client.PaPacket:
+		if sess.authenticatedByCertificate() {
+			// The ident of the certificate cannot be replaced
+			return &server.ArPacket{Reason: "authenticated by certificate"}, nil
+		}
+		return s.authenticate(p, sess)
+
+	case *client.PdPacket, *client.PbPacket, *client.PcPacket:
+		if !sess.Authenticated() {
+			return &server.ArPacket{Reason: "not authenticated"}, nil
+		}
+
+	case *client.PrPacket:
+		sess.heartbeat()
+		response, err := s.config.OnNewPacket(packet, sess)
+		if response == nil && err == nil {
+			// The <Ao> tells the device the connection is alive
+			response = &server.AoPacket{Timestamp: time.Now()}
+		}
+		return response, err
+	}
+
+	return s.config.OnNewPacket(packet, sess)
+}
+
+// Runs the <Pa> handshake, responds with <As> on success and <Ar> otherwise.
+// Returns ErrAuthenticationFailed when the session was already authenticated,
+// so it does not keep the ident of the previous handshake
+func (s *TcpServer) authenticate(packet *client.PaPacket, sess *Session) (server.ServerPackets, error) {
+	var ident, passwd string
+	if packet.Ident != nil {
+		ident = *packet.Ident
+	}
+	if packet.Password != nil {
+		passwd = *packet.Password
+	}
+
+	var reason string
+	switch {
+	case ident == "":
+		reason = "invalid ident"
+	case s.config.OnAuthenticate != nil && !s.config.OnAuthenticate(ident, passwd, sess):
+		reason = "authentication failed"
+	default:
+		s.markAuthenticated(ident, false, sess)
+		return &server.AsPacket{}, nil
+	}
+
+	if sess.Authenticated() {
+		return &server.ArPacket{Reason: reason}, ErrAuthenticationFailed
+	}
+	return &server.ArPacket{Reason: reason}, nil
+}
+
+// Close the TCP server and release the port, all the connections are closed immediately.
+// Use Shutdown to drain the connections gracefully
+func (s *TcpServer) Close() error {
+	s.draining.Store(true)
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	ln := s.listener
+	sessions := s.activeSessions()
+	s.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	}
+
+	for _, sess := range sessions {
+		sess.forceClose()
 	}
-	return 0
+	return err
 }
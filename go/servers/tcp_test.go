@@ -2,11 +2,15 @@ package servers_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/goldenm-software/layrz-protocol/go/v3/packets"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/client"
 	"github.com/goldenm-software/layrz-protocol/go/v3/packets/server"
 	"github.com/goldenm-software/layrz-protocol/go/v3/servers"
@@ -31,7 +35,7 @@ func TestNew_MissingOnNewPacket(t *testing.T) {
 func TestNew_InvalidPort_Zero(t *testing.T) {
 	_, err := servers.New(&servers.TcpConfig{
 		Port:        0,
-		OnNewPacket: func(client.ClientPackets, net.Conn) (server.ServerPackets, error) { return nil, nil },
+		OnNewPacket: func(client.ClientPackets, *servers.Session) (server.ServerPackets, error) { return nil, nil },
 	})
 	if err == nil {
 		t.Error("expected error for port=0")
@@ -41,7 +45,7 @@ func TestNew_InvalidPort_Zero(t *testing.T) {
 func TestNew_InvalidPort_MaxBound(t *testing.T) {
 	_, err := servers.New(&servers.TcpConfig{
 		Port:        65535,
-		OnNewPacket: func(client.ClientPackets, net.Conn) (server.ServerPackets, error) { return nil, nil },
+		OnNewPacket: func(client.ClientPackets, *servers.Session) (server.ServerPackets, error) { return nil, nil },
 	})
 	if err == nil {
 		t.Error("expected error for port=65535")
@@ -51,7 +55,7 @@ func TestNew_InvalidPort_MaxBound(t *testing.T) {
 func TestNew_DefaultsOnDecodeError(t *testing.T) {
 	srv, err := servers.New(&servers.TcpConfig{
 		Port:        19000,
-		OnNewPacket: func(client.ClientPackets, net.Conn) (server.ServerPackets, error) { return nil, nil },
+		OnNewPacket: func(client.ClientPackets, *servers.Session) (server.ServerPackets, error) { return nil, nil },
 	})
 	if err != nil {
 		t.Fatalf("New failed: %v", err)
@@ -74,7 +78,7 @@ func TestTcpServer_StartAndClose(t *testing.T) {
 
 	srv, err := servers.New(&servers.TcpConfig{
 		Port:        port,
-		OnNewPacket: func(client.ClientPackets, net.Conn) (server.ServerPackets, error) { return nil, nil },
+		OnNewPacket: func(client.ClientPackets, *servers.Session) (server.ServerPackets, error) { return nil, nil },
 	})
 	if err != nil {
 		t.Fatalf("New failed: %v", err)
@@ -86,8 +90,7 @@ func TestTcpServer_StartAndClose(t *testing.T) {
 	go func() { _ = srv.Start(ctx) }()
 	time.Sleep(50 * time.Millisecond)
 
-	// Close should return without error; Start keeps blocking on Accept until a
-	// connection arrives, so we don't wait for it here.
+	// Close should return without error and release the listener
 	if err := srv.Close(); err != nil {
 		t.Errorf("Close returned error: %v", err)
 	}
@@ -96,6 +99,13 @@ func TestTcpServer_StartAndClose(t *testing.T) {
 // --- handleConnection behaviour tests using a real bound server ---
 
 func startTcpServer(t *testing.T, cfg *servers.TcpConfig) (port int, cancelFn func()) {
+	t.Helper()
+	return startTcpServerWith(t, cfg, nil, nil)
+}
+
+// startTcpServerWith is startTcpServer that also stores the started server in out and
+// sends the result of Start to done, if they are not nil
+func startTcpServerWith(t *testing.T, cfg *servers.TcpConfig, out **servers.TcpServer, done chan<- error) (port int, cancelFn func()) {
 	t.Helper()
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -109,9 +119,17 @@ func startTcpServer(t *testing.T, cfg *servers.TcpConfig) (port int, cancelFn fu
 	if err != nil {
 		t.Fatalf("New: %v", err)
 	}
+	if out != nil {
+		*out = srv
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	go func() { _ = srv.Start(ctx) }()
+	go func() {
+		err := srv.Start(ctx)
+		if done != nil {
+			done <- err
+		}
+	}()
 	time.Sleep(30 * time.Millisecond) // let the listener bind
 
 	return port, func() {
@@ -123,7 +141,7 @@ func startTcpServer(t *testing.T, cfg *servers.TcpConfig) (port int, cancelFn fu
 func TestTcpServer_ValidPacket_NilResponse(t *testing.T) {
 	called := make(chan struct{}, 1)
 	port, cancel := startTcpServer(t, &servers.TcpConfig{
-		OnNewPacket: func(p client.ClientPackets, conn net.Conn) (server.ServerPackets, error) {
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
 			called <- struct{}{}
 			return nil, nil
 		},
@@ -151,7 +169,7 @@ func TestTcpServer_ValidPacket_NilResponse(t *testing.T) {
 func TestTcpServer_ValidPacket_WithResponse(t *testing.T) {
 	asPacket := &server.AsPacket{}
 	port, cancel := startTcpServer(t, &servers.TcpConfig{
-		OnNewPacket: func(p client.ClientPackets, conn net.Conn) (server.ServerPackets, error) {
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
 			return asPacket, nil
 		},
 	})
@@ -183,10 +201,10 @@ func TestTcpServer_ValidPacket_WithResponse(t *testing.T) {
 func TestTcpServer_GarbagePacket_DecodeError(t *testing.T) {
 	decodeErrCalled := make(chan struct{}, 1)
 	port, cancel := startTcpServer(t, &servers.TcpConfig{
-		OnNewPacket: func(p client.ClientPackets, conn net.Conn) (server.ServerPackets, error) {
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
 			return nil, nil
 		},
-		OnDecodeError: func(err error, data []byte, conn net.Conn) {
+		OnDecodeError: func(err error, data []byte, session *servers.Session) {
 			decodeErrCalled <- struct{}{}
 		},
 	})
@@ -209,11 +227,52 @@ func TestTcpServer_GarbagePacket_DecodeError(t *testing.T) {
 	}
 }
 
+func TestTcpServer_LenientDecoding(t *testing.T) {
+	called := make(chan struct{}, 1)
+	warnings := make(chan error, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			called <- struct{}{}
+			return nil, nil
+		},
+		LenientDecoding: true,
+		OnDecodeWarning: func(err error, data []byte, session *servers.Session) {
+			warnings <- err
+		},
+	})
+	defer cancel()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// A heartbeat with a wrong CRC
+	if _, err := fmt.Fprint(conn, "<Pr>;0000</Pr>\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case err := <-warnings:
+		if !errors.Is(err, packets.ErrInvalidCrc) {
+			t.Errorf("expected ErrInvalidCrc, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnDecodeWarning was not called")
+	}
+	select {
+	case <-called:
+	case <-time.After(2 * time.Second):
+		t.Error("OnNewPacket was not called")
+	}
+}
+
 func TestTcpServer_MultipleConcatenatedPackets(t *testing.T) {
 	callCount := 0
 	done := make(chan struct{})
 	port, cancel := startTcpServer(t, &servers.TcpConfig{
-		OnNewPacket: func(p client.ClientPackets, conn net.Conn) (server.ServerPackets, error) {
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
 			callCount++
 			if callCount == 2 {
 				close(done)
@@ -243,11 +302,52 @@ func TestTcpServer_MultipleConcatenatedPackets(t *testing.T) {
 	}
 }
 
+func TestTcpServer_FramesAcrossReads(t *testing.T) {
+	received := make(chan client.ClientPackets, 2)
+	decodeErrors := make(chan []byte, 1)
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			received <- p
+			return nil, nil
+		},
+		OnDecodeError: func(err error, data []byte, session *servers.Session) { decodeErrors <- data },
+	})
+	defer cancel()
+
+	conn := dialTcp(t, port)
+
+	// A frame split across writes, garbage and a frame without line break
+	pr := *(&client.PrPacket{}).ToPacket()
+	for _, chunk := range []string{pr[:3], pr[3:] + "\r\nnoise\r\n", pr} {
+		if _, err := fmt.Fprint(conn, chunk); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 2 packets, got %d", i)
+		}
+	}
+
+	select {
+	case data := <-decodeErrors:
+		if string(data) != "noise" {
+			t.Errorf("unexpected garbage: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnDecodeError was not called for the garbage")
+	}
+}
+
 func TestTcpServer_HandlerError_NoPanic(t *testing.T) {
 	// When the callback returns an error, the server should log and continue — no panic
 	called := make(chan struct{}, 1)
 	port, cancel := startTcpServer(t, &servers.TcpConfig{
-		OnNewPacket: func(p client.ClientPackets, conn net.Conn) (server.ServerPackets, error) {
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
 			called <- struct{}{}
 			return nil, fmt.Errorf("handler error")
 		},
@@ -271,3 +371,68 @@ func TestTcpServer_HandlerError_NoPanic(t *testing.T) {
 		t.Error("OnNewPacket was not called")
 	}
 }
+
+func TestTcpServer_ConcurrentDevices(t *testing.T) {
+	// Every connection owns its framing state; run with -race to catch shared buffers
+	const devices = 300
+
+	var received atomic.Int64
+	port, cancel := startTcpServer(t, &servers.TcpConfig{
+		OnNewPacket: func(p client.ClientPackets, session *servers.Session) (server.ServerPackets, error) {
+			received.Add(1)
+			return &server.AoPacket{Timestamp: time.Unix(1700000000, 0)}, nil
+		},
+	})
+	defer cancel()
+
+	pr := *(&client.PrPacket{}).ToPacket()
+	want := *(&server.AoPacket{Timestamp: time.Unix(1700000000, 0)}).ToPacket()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, devices)
+	for i := 0; i < devices; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+			if err != nil {
+				errs <- fmt.Errorf("dial: %w", err)
+				return
+			}
+			defer func() { _ = conn.Close() }()
+
+			// Split the frame across two writes so every connection has to accumulate
+			half := len(pr) / 2
+			if _, err := fmt.Fprint(conn, pr[:half]); err != nil {
+				errs <- fmt.Errorf("write: %w", err)
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+			if _, err := fmt.Fprint(conn, pr[half:]+"\n"); err != nil {
+				errs <- fmt.Errorf("write: %w", err)
+				return
+			}
+
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 512)
+			n, err := conn.Read(buf)
+			if err != nil {
+				errs <- fmt.Errorf("read: %w", err)
+				return
+			}
+			if got := string(buf[:n]); got != want {
+				errs <- fmt.Errorf("response mismatch: got %q, want %q", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := received.Load(); got != devices {
+		t.Errorf("expected %d packets, got %d", devices, got)
+	}
+}